@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Backend stores the cached rows. A key holds the variants of a row (depth, tenant, roles) as fields,
+// so deleting the key invalidates all of them.
+type Backend interface {
+	Get(ctx context.Context, key string, field string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, field string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// LRU is an in-memory Backend holding at most Size fields; the least recently used are evicted first.
+type LRU struct {
+	size  int
+	mu    sync.Mutex
+	order *list.List
+	keys  map[string]map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	field   string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRU(size int) *LRU {
+	return &LRU{size: size, order: list.New(), keys: map[string]map[string]*list.Element{}}
+}
+
+func (l *LRU) Get(ctx context.Context, key string, field string) ([]byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	element, ok := l.keys[key][field]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		l.remove(element)
+		return nil, false, nil
+	}
+	l.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (l *LRU) Set(ctx context.Context, key string, field string, value []byte, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := &lruEntry{key: key, field: field, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if element, ok := l.keys[key][field]; ok {
+		element.Value = entry
+		l.order.MoveToFront(element)
+		return nil
+	}
+	if l.keys[key] == nil {
+		l.keys[key] = map[string]*list.Element{}
+	}
+	l.keys[key][field] = l.order.PushFront(entry)
+	for l.size > 0 && l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+	return nil
+}
+
+func (l *LRU) Delete(ctx context.Context, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		for _, element := range l.keys[key] {
+			l.order.Remove(element)
+		}
+		delete(l.keys, key)
+	}
+	return nil
+}
+
+// Len returns the number of cached fields.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+func (l *LRU) remove(element *list.Element) {
+	entry := l.order.Remove(element).(*lruEntry)
+	delete(l.keys[entry.key], entry.field)
+	if len(l.keys[entry.key]) == 0 {
+		delete(l.keys, entry.key)
+	}
+}
+
+// ErrNil is returned by RedisClient.HGet when the key or the field does not exist.
+var ErrNil = errors.New("cache: nil")
+
+// RedisClient is the subset of Redis commands used by Redis. Adapt a client such as go-redis
+// by returning ErrNil for redis.Nil; FakeRedis implements it in memory for tests and development.
+type RedisClient interface {
+	HGet(ctx context.Context, key string, field string) (string, error)
+	HSet(ctx context.Context, key string, field string, value string) error
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+}
+
+// Redis is a Backend storing every row as a hash, expired as a whole after the ttl of its last Set.
+type Redis struct {
+	Client RedisClient
+}
+
+func NewRedis(client RedisClient) *Redis {
+	return &Redis{Client: client}
+}
+
+func (r *Redis) Get(ctx context.Context, key string, field string) ([]byte, bool, error) {
+	value, err := r.Client.HGet(ctx, key, field)
+	if err == ErrNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, field string, value []byte, ttl time.Duration) error {
+	if err := r.Client.HSet(ctx, key, field, string(value)); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return r.Client.Expire(ctx, key, ttl)
+	}
+	return nil
+}
+
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.Client.Del(ctx, keys...)
+}
+
+// FakeRedis is an in-memory RedisClient with the semantics of HGET, HSET, EXPIRE and DEL.
+type FakeRedis struct {
+	mu      sync.Mutex
+	hashes  map[string]map[string]string
+	expires map[string]time.Time
+}
+
+func NewFakeRedis() *FakeRedis {
+	return &FakeRedis{hashes: map[string]map[string]string{}, expires: map[string]time.Time{}}
+}
+
+func (f *FakeRedis) HGet(ctx context.Context, key string, field string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(key)
+	value, ok := f.hashes[key][field]
+	if !ok {
+		return "", ErrNil
+	}
+	return value, nil
+}
+
+func (f *FakeRedis) HSet(ctx context.Context, key string, field string, value string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(key)
+	if f.hashes[key] == nil {
+		f.hashes[key] = map[string]string{}
+	}
+	f.hashes[key][field] = value
+	return nil
+}
+
+func (f *FakeRedis) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.hashes[key]; ok {
+		f.expires[key] = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (f *FakeRedis) Del(ctx context.Context, keys ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		delete(f.hashes, key)
+		delete(f.expires, key)
+	}
+	return nil
+}
+
+func (f *FakeRedis) expire(key string) {
+	if expires, ok := f.expires[key]; ok && time.Now().After(expires) {
+		delete(f.hashes, key)
+		delete(f.expires, key)
+	}
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+// KeyPrefix starts the keys of the cached rows, followed by "<table>:<id>".
+var KeyPrefix string = "s2s:cache:"
+
+// Repository is a read-through cache of GetByID in front of another repository; the other methods
+// are passed through. Rows are cached with their relations, so a change of a related table is seen
+// once the ttl expires. Relations loaded by other repositories query the database, even when their
+// table is cached.
+//
+// Cached rows are invalidated when a write made through any repository is committed: right after
+// Create, Update, Delete and the other writes, or on Commit for writes made in a transaction set
+// with SetTx. Reads inside a transaction skip the cache.
+type Repository[T any] struct {
+	repositories.IRepository[T]
+	backend Backend
+	ttl     time.Duration
+	ctx     context.Context
+	linked  *[]interface{}
+}
+
+var (
+	mu       sync.RWMutex
+	once     sync.Once
+	backends = map[string]map[Backend]bool{}
+	// ttls is the longest ttl of the repositories caching a table, -1 for no expiration
+	ttls = map[string]time.Duration{}
+)
+
+// New returns repo cached in backend for ttl (no expiration when 0), e.g.
+// cache.New[Role](repositories.NewRepository[Role](), cache.NewLRU(1000), time.Minute).
+func New[T any](repo repositories.IRepository[T], backend Backend, ttl time.Duration) *Repository[T] {
+	table := repo.GetTableName()
+	mu.Lock()
+	if backends[table] == nil {
+		backends[table] = map[Backend]bool{}
+	}
+	backends[table][backend] = true
+	if ttl <= 0 {
+		ttls[table] = -1
+	} else if current := ttls[table]; current >= 0 && ttl > current {
+		ttls[table] = ttl
+	}
+	mu.Unlock()
+	once.Do(func() {
+		repositories.OnChangeCommit(invalidate)
+	})
+	return &Repository[T]{
+		IRepository: repo,
+		backend:     backend,
+		ttl:         ttl,
+		ctx:         context.Background(),
+		linked:      &[]interface{}{},
+	}
+}
+
+// InvalidationWindow is added to the longest ttl of a table to get how long Invalidate marks a row
+// as changed, without expiration when a repository caches the table with ttl 0. Cached entries
+// carry the mark they were read under and are ignored once it changes, so a GetByID that read the
+// row before the change can not overwrite the invalidation with the old row.
+var InvalidationWindow = time.Minute
+
+var generations uint64
+
+// Key returns the key of the row of table with id.
+func Key(table string, id interface{}) string {
+	return KeyPrefix + table + ":" + fmt.Sprint(id)
+}
+
+// generationKey holds the mark of the last invalidation of key, outside key so deleting key keeps it.
+func generationKey(key string) string {
+	return key + ":gen"
+}
+
+// Invalidate removes the rows of table with ids from every backend caching table.
+func Invalidate(ctx context.Context, table string, ids ...interface{}) {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, Key(table, id))
+	}
+	mu.RLock()
+	targets := make([]Backend, 0, len(backends[table]))
+	for backend := range backends[table] {
+		targets = append(targets, backend)
+	}
+	window := time.Duration(0)
+	if ttls[table] >= 0 {
+		window = ttls[table] + InvalidationWindow
+	}
+	mu.RUnlock()
+	generation := []byte(strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(atomic.AddUint64(&generations, 1), 36))
+	for _, backend := range targets {
+		// the mark goes first, a fill checking it after the delete sees the change
+		for _, key := range keys {
+			if err := backend.Set(ctx, generationKey(key), "", generation, window); err != nil {
+				log.Printf("Error al invalidar la cache de %s[029-Cache]: %v", table, err)
+			}
+		}
+		if err := backend.Delete(ctx, keys...); err != nil {
+			log.Printf("Error al invalidar la cache de %s[029-Cache]: %v", table, err)
+		}
+	}
+}
+
+func invalidate(change repositories.Change) {
+	Invalidate(context.Background(), change.Table, change.ID)
+}
+
+func (r *Repository[T]) GetByID(id interface{}) (*T, error) {
+	if r.GetTx() != nil {
+		return r.IRepository.GetByID(id)
+	}
+	key, field := Key(r.GetTableName(), id), r.variant()
+	data, ok, err := r.backend.Get(r.ctx, key, field)
+	if err != nil {
+		log.Printf("Error al leer la cache de %s[030-Cache]: %v", r.GetTableName(), err)
+	}
+	// an entry counts while the key keeps the generation it was read under, so a fill that
+	// raced with an invalidation is never returned
+	generation, read := r.generation(key)
+	if ok && read {
+		item := repositories.CreateNewElement[T]()
+		if stored, err := decodeEntry(data, item); err == nil && stored == generation {
+			return item, nil
+		}
+	}
+
+	item, err := r.IRepository.GetByID(id)
+	if err != nil || item == nil {
+		return item, err
+	}
+	// a row invalidated while it was read may be the old one
+	if current, ok := r.generation(key); !read || !ok || current != generation {
+		return item, nil
+	}
+	value, err := encodeEntry(item, generation)
+	if err != nil {
+		return item, nil
+	}
+	if err := r.backend.Set(r.ctx, key, field, value, r.ttl); err != nil {
+		log.Printf("Error al escribir la cache de %s[031-Cache]: %v", r.GetTableName(), err)
+	}
+	return item, nil
+}
+
+// generation returns the mark of the last invalidation of key, false when it can not be read.
+func (r *Repository[T]) generation(key string) (string, bool) {
+	data, _, err := r.backend.Get(r.ctx, generationKey(key), "")
+	if err != nil {
+		log.Printf("Error al leer la cache de %s[030-Cache]: %v", r.GetTableName(), err)
+		return "", false
+	}
+	return string(data), true
+}
+
+// variant identifies what GetByID returns for the context: the depth, the tenant and the roles.
+func (r *Repository[T]) variant() string {
+	tenant := ""
+	if repositories.IsTenantBypassed(r.ctx) {
+		tenant = "*"
+	} else if value, ok := repositories.TenantFromContext(r.ctx); ok {
+		tenant = fmt.Sprint(value)
+	}
+	roles, ok := repositories.RolesFromContext(r.ctx)
+	if ok {
+		roles = append([]string{}, roles...)
+		sort.Strings(roles)
+	}
+	return fmt.Sprintf("depth=%d;tenant=%s;roles=%t:%s", r.GetDepth(), tenant, ok, strings.Join(roles, ","))
+}
+
+func (r *Repository[T]) Link(id interface{}, name string, targetID interface{}) error {
+	if err := r.IRepository.Link(id, name, targetID); err != nil {
+		return err
+	}
+	r.linkChanged(id)
+	return nil
+}
+
+func (r *Repository[T]) Unlink(id interface{}, name string, targetID interface{}) error {
+	if err := r.IRepository.Unlink(id, name, targetID); err != nil {
+		return err
+	}
+	r.linkChanged(id)
+	return nil
+}
+
+// linkChanged invalidates the row whose relations changed, on Commit inside a transaction.
+func (r *Repository[T]) linkChanged(id interface{}) {
+	if r.GetTx() != nil {
+		*r.linked = append(*r.linked, id)
+		return
+	}
+	Invalidate(r.ctx, r.GetTableName(), id)
+}
+
+func (r *Repository[T]) Commit() error {
+	err := r.IRepository.Commit()
+	if err == nil && len(*r.linked) > 0 {
+		Invalidate(r.ctx, r.GetTableName(), *r.linked...)
+	}
+	*r.linked = nil
+	return err
+}
+
+func (r *Repository[T]) Rollback() error {
+	*r.linked = nil
+	return r.IRepository.Rollback()
+}
+
+func (r *Repository[T]) SetTx(tx *sql.Tx) {
+	*r.linked = nil
+	r.IRepository.SetTx(tx)
+}
+
+func (r *Repository[T]) SetDepth(depth int) repositories.IRepository[T] {
+	r.IRepository.SetDepth(depth)
+	return r
+}
+
+func (r *Repository[T]) SetContext(ctx context.Context) {
+	r.IRepository.SetContext(ctx)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	r.ctx = ctx
+}
+
+// WithContext returns a copy of the cached repository that runs with ctx.
+func (r *Repository[T]) WithContext(ctx context.Context) repositories.IRepository[T] {
+	clone := *r
+	clone.IRepository = r.IRepository.WithContext(ctx)
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	clone.ctx = ctx
+	clone.linked = &[]interface{}{}
+	return &clone
+}
@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Role struct {
+	ID   int    `json:"id" db:"id" s2s_table_name:"roles"`
+	Name string `json:"name" db:"name"`
+}
+
+func TestCache(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE roles (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO roles (id, name) VALUES (1, 'admin')"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, backend := range map[string]Backend{"lru": NewLRU(10), "redis": NewRedis(NewFakeRedis())} {
+		t.Run(name, func(t *testing.T) {
+			db.Exec("UPDATE roles SET name = 'admin' WHERE id = 1")
+			repo := New[Role](repositories.NewRepository[Role](), backend, time.Minute)
+			name := func() string {
+				role, err := repo.GetByID(1)
+				if err != nil || role == nil {
+					t.Fatal(role, err)
+				}
+				return role.Name
+			}
+			if name() != "admin" {
+				t.Fatal("unexpected role")
+			}
+			// a change made behind the repositories is not seen while cached
+			db.Exec("UPDATE roles SET name = 'stale' WHERE id = 1")
+			if name() != "admin" {
+				t.Fatal("expected the cached role")
+			}
+
+			if err := repo.Update(&Role{ID: 1, Name: "root"}); err != nil {
+				t.Fatal(err)
+			}
+			if name() != "root" {
+				t.Fatal("expected the update to invalidate the role")
+			}
+
+			// inside a transaction the row is invalidated on commit
+			writer := repositories.NewRepository[Role]()
+			repositories.CreateTxAndSet(writer)
+			writer.Update(&Role{ID: 1, Name: "owner"})
+			if name() != "root" {
+				t.Fatal("expected the cached role before commit")
+			}
+			writer.Commit()
+			if name() != "owner" {
+				t.Fatal("expected the commit to invalidate the role")
+			}
+
+			// roles are part of the key
+			withRoles := repo.WithContext(repositories.WithRoles(context.Background(), "admin"))
+			db.Exec("UPDATE roles SET name = 'other' WHERE id = 1")
+			if role, _ := withRoles.GetByID(1); role.Name != "other" {
+				t.Fatal("expected another cache entry for the roles")
+			}
+
+			if err := repo.Delete(1); err != nil {
+				t.Fatal(err)
+			}
+			if role, _ := repo.GetByID(1); role != nil {
+				t.Fatal("expected the delete to invalidate the role")
+			}
+			repo.Create(&Role{ID: 1, Name: "admin"})
+		})
+	}
+}
+
+// racingRepository commits a change of the row right after reading it, as a concurrent writer would.
+type racingRepository struct {
+	repositories.IRepository[Role]
+	reads int
+}
+
+func (r *racingRepository) GetByID(id interface{}) (*Role, error) {
+	r.reads++
+	role, err := r.IRepository.GetByID(id)
+	if r.reads == 1 {
+		config.DB.Exec("UPDATE roles SET name = 'new' WHERE id = ?", id)
+		Invalidate(context.Background(), "roles", id)
+	}
+	return role, err
+}
+
+func TestCacheRace(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE roles (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO roles (id, name) VALUES (1, 'old')"); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, backend := range map[string]Backend{"lru": NewLRU(10), "redis": NewRedis(NewFakeRedis())} {
+		t.Run(name, func(t *testing.T) {
+			db.Exec("UPDATE roles SET name = 'old' WHERE id = 1")
+			inner := &racingRepository{IRepository: repositories.NewRepository[Role]()}
+			repo := New[Role](inner, backend, 0)
+			if role, _ := repo.GetByID(1); role.Name != "old" {
+				t.Fatal("expected the row read before the change", role)
+			}
+			if role, _ := repo.GetByID(1); role.Name != "new" || inner.reads != 2 {
+				t.Fatal("the row read before the invalidation must not be cached", role, inner.reads)
+			}
+			if role, _ := repo.GetByID(1); role.Name != "new" || inner.reads != 2 {
+				t.Fatal("expected the new row cached", role, inner.reads)
+			}
+		})
+	}
+}
+
+// racingBackend invalidates a row right before it is stored, between the check and the Set.
+type racingBackend struct {
+	Backend
+	sets int
+}
+
+func (b *racingBackend) Set(ctx context.Context, key string, field string, value []byte, ttl time.Duration) error {
+	if !strings.HasSuffix(key, ":gen") {
+		if b.sets++; b.sets == 1 {
+			config.DB.Exec("UPDATE roles SET name = 'new' WHERE id = 1")
+			Invalidate(ctx, "roles", 1)
+		}
+	}
+	return b.Backend.Set(ctx, key, field, value, ttl)
+}
+
+func TestCacheRaceSet(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE roles (id INTEGER PRIMARY KEY, name TEXT); INSERT INTO roles (id, name) VALUES (1, 'old')"); err != nil {
+		t.Fatal(err)
+	}
+	repo := New[Role](repositories.NewRepository[Role](), &racingBackend{Backend: NewLRU(10)}, 0)
+	if role, _ := repo.GetByID(1); role.Name != "old" {
+		t.Fatal("expected the row read before the change", role)
+	}
+	if role, _ := repo.GetByID(1); role.Name != "new" {
+		t.Fatal("the row stored after the invalidation must be ignored", role)
+	}
+}
+
+type Member struct {
+	ID    int     `json:"id" db:"id" s2s_table_name:"members"`
+	Level *int    `json:"level" db:"level"`
+	Roles *[]Role `json:"roles" s2s:"id in (select role_id from member_roles where member_id = ?)"`
+}
+
+func TestCacheZeroValues(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE roles (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE members (id INTEGER PRIMARY KEY, level INTEGER);
+		CREATE TABLE member_roles (member_id INTEGER, role_id INTEGER);
+		INSERT INTO members (id, level) VALUES (1, 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncached, _ := repositories.NewRepository[Member]().GetByID(1)
+	uncached.Roles = &[]Role{}
+	expected, _ := json.Marshal(uncached)
+
+	// gob drops zero values, the cached row must still match the stored one
+	value, err := encodeEntry(uncached, "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &Member{}
+	if generation, err := decodeEntry(value, decoded); err != nil || generation != "g" {
+		t.Fatal(generation, err)
+	}
+	if got, _ := json.Marshal(decoded); string(got) != string(expected) {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+
+	repo := New[Member](repositories.NewRepository[Member](), NewLRU(10), time.Minute)
+	first, _ := repo.GetByID(1)
+	cached, _ := repo.GetByID(1)
+	if a, b := mustJSON(first), mustJSON(cached); a != b || cached.Level == nil {
+		t.Fatalf("expected the cached row to match the stored one, got %s and %s", a, b)
+	}
+}
+
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	lru := NewLRU(2)
+	lru.Set(ctx, "a", "f", []byte("1"), 0)
+	lru.Set(ctx, "b", "f", []byte("2"), 0)
+	lru.Get(ctx, "a", "f")
+	lru.Set(ctx, "c", "f", []byte("3"), 0)
+	if _, ok, _ := lru.Get(ctx, "b", "f"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if _, ok, _ := lru.Get(ctx, "a", "f"); !ok {
+		t.Error("expected a to be kept")
+	}
+
+	// d evicts c and then expires, leaving a
+	lru.Set(ctx, "d", "f", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := lru.Get(ctx, "d", "f"); ok {
+		t.Error("expected d to expire")
+	}
+	if lru.Len() != 1 {
+		t.Error("unexpected size", lru.Len())
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+)
+
+// entry is the cached value of a row: the gob of the row and the generation of the key it was read
+// under. Gob omits zero values, so a non-nil pointer to a zero value or an empty slice or map comes
+// back nil; Pointers and Empty record their paths, field and element indexes, to restore them.
+type entry struct {
+	Generation string
+	Data       []byte
+	Pointers   [][]int
+	Empty      [][]int
+}
+
+func encodeEntry(item interface{}, generation string) ([]byte, error) {
+	e := entry{Generation: generation}
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(item); err != nil {
+		return nil, err
+	}
+	e.Data = data.Bytes()
+	e.collect(reflect.ValueOf(item).Elem(), nil)
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(e); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeEntry(data []byte, item interface{}) (string, error) {
+	e := entry{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		return "", err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(e.Data)).Decode(item); err != nil {
+		return "", err
+	}
+	root := reflect.ValueOf(item).Elem()
+	for _, path := range e.Pointers {
+		restore(root, path, false)
+	}
+	for _, path := range e.Empty {
+		restore(root, path, true)
+	}
+	return e.Generation, nil
+}
+
+// collect records the paths of v that gob would turn into nil.
+func (e *entry) collect(v reflect.Value, path []int) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if v.Elem().IsZero() {
+			e.Pointers = append(e.Pointers, append([]int{}, path...))
+			return
+		}
+		e.collect(v.Elem(), path)
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		if v.Len() == 0 {
+			e.Empty = append(e.Empty, append([]int{}, path...))
+			return
+		}
+		if v.Kind() == reflect.Slice {
+			for i := 0; i < v.Len(); i++ {
+				e.collect(v.Index(i), append(path, i))
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			e.collect(v.Index(i), append(path, i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				e.collect(v.Field(i), append(path, i))
+			}
+		}
+	}
+}
+
+// restore allocates the pointers along path and, when empty, the empty slice or map at its end.
+func restore(v reflect.Value, path []int, empty bool) {
+	for _, index := range path {
+		v = deref(v)
+		switch v.Kind() {
+		case reflect.Struct:
+			v = v.Field(index)
+		case reflect.Slice, reflect.Array:
+			if index >= v.Len() {
+				return
+			}
+			v = v.Index(index)
+		default:
+			return
+		}
+	}
+	if !empty {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return
+	}
+	v = deref(v)
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	}
+}
+
+func deref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/arturoeanton/go-struct2serve/gen"
+	"github.com/arturoeanton/go-struct2serve/migrate"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const usage = `usage: struct2serve <command> [arguments]
+
+commands:
+  gen       generate models, and optionally repositories, handlers and routes, from a database
+  migrate   apply, revert and list the migrations of a directory`
+
+// The sqlite3 driver is always linked in; the postgres and mysql build tags add lib/pq and
+// go-sql-driver/mysql, see driver_postgres.go and driver_mysql.go.
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "gen":
+		err = gen.Command(context.Background(), os.Args[2:], os.Stdout)
+	case "migrate":
+		err = migrate.Command(context.Background(), os.Args[2:], os.Stdout)
+	default:
+		err = errors.New(usage)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
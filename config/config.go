@@ -2,7 +2,23 @@ package config
 
 import "database/sql"
 
+const (
+	DialectSQLite   = "sqlite3"
+	DialectPostgres = "postgres"
+	DialectMySQL    = "mysql"
+)
+
 var (
 	DB      *sql.DB
 	FlagLog bool
+	Dialect string = DialectSQLite
 )
+
+// MaxParams returns the maximum number of bind parameters per statement for the current Dialect.
+func MaxParams() int {
+	switch Dialect {
+	case DialectPostgres, DialectMySQL:
+		return 65535
+	}
+	return 999
+}
@@ -0,0 +1,144 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Prefix marks encrypted values: "s2s:<key id>:<base64 nonce+ciphertext>".
+const Prefix = "s2s:"
+
+var (
+	ErrNoKeyProvider = errors.New("encryption: no key provider, set encryption.Provider")
+	ErrUnknownKey    = errors.New("encryption: unknown key")
+	ErrInvalidValue  = errors.New("encryption: invalid encrypted value")
+)
+
+// KeyProvider returns the AES keys (16, 24 or 32 bytes). New values are encrypted with the current key,
+// older values keep the id of the key used, so keys can be rotated without rewriting the table.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error)
+	Key(id string) ([]byte, error)
+}
+
+// Provider is used by the repositories for fields tagged with s2s_encrypt.
+var Provider KeyProvider
+
+// StaticKeys is a KeyProvider with the keys in memory, e.g. loaded from the environment.
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte
+}
+
+func (k StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Encrypt encrypts plaintext with AES-GCM and the current key of provider. binding, e.g. "customers.phone",
+// is authenticated with the value, so a ciphertext copied to another column does not decrypt.
+// Deterministic derives the nonce from binding and plaintext with a key derived from the AES key, so equal
+// values of a column give equal ciphertexts and can be compared in SQL.
+func Encrypt(provider KeyProvider, binding string, plaintext string, deterministic bool) (string, error) {
+	if provider == nil {
+		return "", ErrNoKeyProvider
+	}
+	id, key, err := provider.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(id, ":") {
+		return "", fmt.Errorf("%w: key id %q contains ':'", ErrUnknownKey, id)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if deterministic {
+		nonceKey := make([]byte, sha256.Size)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte("s2s deterministic nonce")), nonceKey); err != nil {
+			return "", err
+		}
+		mac := hmac.New(sha256.New, nonceKey)
+		mac.Write([]byte(binding))
+		mac.Write([]byte{0})
+		mac.Write([]byte(plaintext))
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), additionalData(id, binding))
+	return Prefix + id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt with the key named in value and the same binding. Values without Prefix are
+// returned unchanged, so columns can be encrypted on tables that still hold plain rows.
+func Decrypt(provider KeyProvider, binding string, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if provider == nil {
+		return "", ErrNoKeyProvider
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	if !ok {
+		return "", ErrInvalidValue
+	}
+	key, err := provider.Key(id)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrInvalidValue
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData(id, binding))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	return string(plaintext), nil
+}
+
+// additionalData binds the key id and the column; key ids can not contain ':'.
+func additionalData(id string, binding string) []byte {
+	return []byte(id + ":" + binding)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,54 @@
+package encryption
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) {
+	keys := StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef0123456789abcdef")}}
+
+	a, err := Encrypt(keys, "customers.phone", "555-1234", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Encrypt(keys, "customers.phone", "555-1234", false)
+	if a == b || !IsEncrypted(a) {
+		t.Error("random mode must use a new nonce", a, b)
+	}
+	c, _ := Encrypt(keys, "customers.phone", "555-1234", true)
+	d, _ := Encrypt(keys, "customers.phone", "555-1234", true)
+	if c != d {
+		t.Error("deterministic mode must give equal ciphertexts", c, d)
+	}
+
+	if other, _ := Encrypt(keys, "customers.fax", "555-1234", true); other == c {
+		t.Error("deterministic ciphertexts must differ between columns")
+	}
+	if _, err := Decrypt(keys, "customers.fax", a); !errors.Is(err, ErrInvalidValue) {
+		t.Error("a value moved to another column must not decrypt, got", err)
+	}
+	// rotation: values encrypted with k1 are still readable once k2 is the current key
+	keys.Keys["k2"] = []byte("fedcba9876543210")
+	keys.Current = "k2"
+	for _, value := range []string{a, c} {
+		plaintext, err := Decrypt(keys, "customers.phone", value)
+		if err != nil || plaintext != "555-1234" {
+			t.Error("expected 555-1234, got", plaintext, err)
+		}
+	}
+	if e, _ := Encrypt(keys, "customers.phone", "555-1234", true); e == c {
+		t.Error("the current key must be used after a rotation")
+	}
+
+	if plain, _ := Decrypt(keys, "customers.phone", "not encrypted"); plain != "not encrypted" {
+		t.Error("plain values must be returned unchanged", plain)
+	}
+	delete(keys.Keys, "k1")
+	if _, err := Decrypt(keys, "customers.phone", a); !errors.Is(err, ErrUnknownKey) {
+		t.Error("expected ErrUnknownKey, got", err)
+	}
+	if _, err := Encrypt(nil, "customers.phone", "x", false); !errors.Is(err, ErrNoKeyProvider) {
+		t.Error("expected ErrNoKeyProvider, got", err)
+	}
+}
@@ -0,0 +1,90 @@
+package gen
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/migrate"
+	"github.com/arturoeanton/go-struct2serve/schema"
+)
+
+// Command runs the gen command line: it introspects the database of -driver and -dsn and writes the
+// files of Generate in -out. Existing files are kept unless -force is set.
+// The driver must be imported by the program, e.g. _ "github.com/lib/pq".
+func Command(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("gen", flag.ContinueOnError)
+	flags.SetOutput(out)
+	driver := flags.String("driver", "sqlite3", "database/sql driver")
+	dsn := flags.String("dsn", os.Getenv("DATABASE_URL"), "data source name, $DATABASE_URL by default")
+	dialect := flags.String("dialect", "", "sqlite3, postgres or mysql, derived from the driver by default")
+	dir := flags.String("out", "models", "directory of the generated files")
+	pkg := flags.String("package", "", "package of the generated files, the name of -out by default")
+	tables := flags.String("tables", "", "comma separated tables, every table but the s2s_ ones by default")
+	repositories := flags.Bool("repositories", false, "generate a repository per model")
+	handlers := flags.Bool("handlers", false, "generate a handler per model")
+	routes := flags.Bool("routes", false, "generate RegisterRoutes")
+	force := flags.Bool("force", false, "overwrite existing files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return errors.New("-dsn is required")
+	}
+	if *dialect == "" {
+		*dialect = migrate.Dialect(*driver)
+	}
+	if *pkg == "" {
+		*pkg = strings.NewReplacer("-", "_", ".", "_").Replace(filepath.Base(*dir))
+	}
+	options := Options{Package: *pkg, Repositories: *repositories, Handlers: *handlers, Routes: *routes}
+	for _, table := range strings.Split(*tables, ",") {
+		if table = strings.TrimSpace(table); table != "" {
+			options.Tables = append(options.Tables, table)
+		}
+	}
+
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+	live, err := schema.Introspect(db, *dialect)
+	if err != nil {
+		return err
+	}
+	files, err := Generate(live, options)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+		if _, err := os.Stat(filepath.Join(*dir, name)); err == nil && !*force {
+			return fmt.Errorf("%s exists, use -force to overwrite it", filepath.Join(*dir, name))
+		}
+	}
+	sort.Strings(names)
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(*dir, name)
+		if err := os.WriteFile(path, files[name], 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "generated", path)
+	}
+	return nil
+}
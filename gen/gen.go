@@ -0,0 +1,282 @@
+package gen
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/schema"
+)
+
+// Options configures Generate.
+type Options struct {
+	// Package of the generated files, "models" by default.
+	Package string
+	// Tables limits the generated models, every table but the s2s_ ones by default.
+	Tables []string
+	// Repositories adds a <Model>Repository embedding repositories.Repository per model.
+	Repositories bool
+	// Handlers adds a <Model>Handler embedding handlers.Handler per model.
+	Handlers bool
+	// Routes adds RegisterRoutes, registering the handler of every model.
+	Routes bool
+}
+
+// Field is a field of a generated struct.
+type Field struct {
+	Name string
+	Type string
+	Tag  string
+}
+
+// Model is the struct generated for a table; models without a single column primary key, their
+// IDColumn, get no repository nor handler.
+type Model struct {
+	Name     string
+	Table    string
+	HasID    bool
+	IDColumn string
+	Fields   []Field
+}
+
+var initialisms = map[string]string{"id": "ID", "url": "URL", "uri": "URI", "uuid": "UUID", "api": "API", "http": "HTTP",
+	"json": "JSON", "xml": "XML", "html": "HTML", "sql": "SQL", "ip": "IP", "ui": "UI"}
+
+// camel turns a snake case name into an exported Go name, e.g. author_id into AuthorID.
+func camel(name string) string {
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool { return r == '_' || r == '-' || r == ' ' }) {
+		if initialism, ok := initialisms[word]; ok {
+			b.WriteString(initialism)
+			continue
+		}
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	if b.Len() == 0 || (b.String()[0] >= '0' && b.String()[0] <= '9') {
+		return "X" + b.String()
+	}
+	return b.String()
+}
+
+// singular returns the singular of an English plural table name, e.g. categories into category.
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "sses"), strings.HasSuffix(name, "xes"), strings.HasSuffix(name, "ches"), strings.HasSuffix(name, "shes"):
+		return strings.TrimSuffix(name, "es")
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") && !strings.HasSuffix(name, "us") && !strings.HasSuffix(name, "is"):
+		return strings.TrimSuffix(name, "s")
+	}
+	return name
+}
+
+// trimID removes the _id suffix of a foreign key column, e.g. author_id into author.
+func trimID(column string) string {
+	if trimmed := strings.TrimSuffix(strings.TrimSuffix(column, "_id"), "_ID"); trimmed != "" {
+		return trimmed
+	}
+	return column
+}
+
+// goType returns the Go type of a column, a pointer when the column is nullable.
+func goType(column schema.Column) string {
+	t := "string"
+	switch schema.Family(column.Type) {
+	case "integer":
+		t = "int64"
+	case "real":
+		t = "float64"
+	case "boolean":
+		t = "bool"
+	case "time":
+		t = "time.Time"
+	case "blob":
+		return "[]byte"
+	}
+	if column.Nullable && !column.PrimaryKey {
+		return "*" + t
+	}
+	return t
+}
+
+// linkTable reports whether table only holds two foreign keys, e.g. user_roles (user_id, role_id).
+func linkTable(table schema.Table) bool {
+	if len(table.ForeignKeys) != 2 || len(table.Columns) != 2 {
+		return false
+	}
+	if _, ok := primaryKey(table); ok {
+		return false
+	}
+	return table.ForeignKeys[0].Column != table.ForeignKeys[1].Column
+}
+
+// Models returns the models of tables, one per table but the many-to-many link tables, which become
+// relations of the tables they link.
+func Models(tables map[string]schema.Table, options Options) []Model {
+	selected := map[string]bool{}
+	if len(options.Tables) > 0 {
+		for _, name := range options.Tables {
+			if _, ok := tables[name]; ok {
+				selected[name] = true
+			}
+		}
+	} else {
+		for name := range tables {
+			selected[name] = !strings.HasPrefix(name, "s2s_")
+		}
+	}
+	names := []string{}
+	for name := range tables {
+		if selected[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	structNames := map[string]string{}
+	for _, name := range names {
+		structNames[name] = camel(singular(name))
+	}
+	models := map[string]*Model{}
+	result := []*Model{}
+	for _, name := range names {
+		if linkTable(tables[name]) {
+			continue
+		}
+		model := &Model{Name: structNames[name], Table: name}
+		if column, ok := primaryKey(tables[name]); ok {
+			model.HasID, model.IDColumn = true, column.Name
+		}
+		models[name] = model
+		result = append(result, model)
+	}
+
+	taken := map[string]map[string]bool{}
+	unique := func(model *Model, name string) string {
+		if taken[model.Table] == nil {
+			taken[model.Table] = map[string]bool{}
+			for _, column := range tables[model.Table].Columns {
+				taken[model.Table][camel(column.Name)] = true
+			}
+		}
+		candidate := name
+		for i := 2; taken[model.Table][candidate]; i++ {
+			candidate = fmt.Sprintf("%s%d", name, i)
+		}
+		taken[model.Table][candidate] = true
+		return candidate
+	}
+
+	// has-many and many-to-many relations go after the columns of their model
+	extra := map[string][]Field{}
+	for _, name := range names {
+		table := tables[name]
+		if model, ok := models[name]; ok {
+			tableTagged := false
+			for _, column := range table.Columns {
+				field := Field{Name: camel(column.Name), Type: goType(column), Tag: fmt.Sprintf(`json:"%s" db:"%s"`, column.Name, column.Name)}
+				if column.Name == model.IDColumn || (!model.HasID && !tableTagged) {
+					if column.Name == model.IDColumn {
+						field.Tag += ` s2s_id:"true"`
+					}
+					field.Tag += fmt.Sprintf(` s2s_table_name:"%s"`, name)
+					tableTagged = true
+				}
+				var relation *Field
+				for _, fk := range table.ForeignKeys {
+					ref, ok := models[fk.RefTable]
+					if fk.Column != column.Name || !ok {
+						continue
+					}
+					relationName := camel(trimID(fk.Column))
+					if relationName == field.Name {
+						relationName += ref.Name
+					}
+					relationName = unique(model, relationName)
+					field.Tag += fmt.Sprintf(` s2s_ref_value:"%s.%s"`, relationName, camel(fk.RefColumn))
+					relation = &Field{Name: relationName, Type: "*" + ref.Name,
+						Tag: fmt.Sprintf(`json:"%s,omitempty" s2s:"%s = ?" s2s_param:"%s"`, snake(relationName), fk.RefColumn, field.Name)}
+
+					manyName := camel(name)
+					if countRefs(table, fk.RefTable) > 1 {
+						manyName += "By" + camel(trimID(fk.Column))
+					}
+					tag := fmt.Sprintf(`json:"%s,omitempty" s2s:"%s = ?"`, snake(manyName), fk.Column)
+					if fk.RefColumn != ref.IDColumn {
+						tag += fmt.Sprintf(` s2s_param:"%s"`, camel(fk.RefColumn))
+					}
+					extra[fk.RefTable] = append(extra[fk.RefTable], Field{Name: unique(ref, manyName), Type: "*[]" + model.Name, Tag: tag})
+					break
+				}
+				model.Fields = append(model.Fields, field)
+				if relation != nil {
+					model.Fields = append(model.Fields, *relation)
+				}
+			}
+			continue
+		}
+
+		// many-to-many: owner.Targets loads the rows of target linked to the owner id
+		for i, fk := range table.ForeignKeys {
+			other := table.ForeignKeys[1-i]
+			owner, okOwner := models[fk.RefTable]
+			target, okTarget := models[other.RefTable]
+			if !okOwner || !okTarget || fk.RefColumn != owner.IDColumn || !owner.HasID {
+				continue
+			}
+			manyName := camel(other.RefTable)
+			if fk.RefTable == other.RefTable {
+				manyName = camel(trimID(other.Column)) + "s"
+			}
+			tag := fmt.Sprintf(`json:"%s,omitempty" s2s:"%s in (select %s from %s where %s = ?)"`,
+				snake(manyName), other.RefColumn, other.Column, name, fk.Column)
+			extra[fk.RefTable] = append(extra[fk.RefTable], Field{Name: unique(owner, manyName), Type: "*[]" + target.Name, Tag: tag})
+		}
+	}
+
+	generated := make([]Model, 0, len(result))
+	for _, model := range result {
+		model.Fields = append(model.Fields, extra[model.Table]...)
+		generated = append(generated, *model)
+	}
+	return generated
+}
+
+func countRefs(table schema.Table, refTable string) int {
+	count := 0
+	for _, fk := range table.ForeignKeys {
+		if fk.RefTable == refTable {
+			count++
+		}
+	}
+	return count
+}
+
+// primaryKey returns the primary key of table when it is a single column.
+func primaryKey(table schema.Table) (schema.Column, bool) {
+	var key schema.Column
+	count := 0
+	for _, column := range table.Columns {
+		if column.PrimaryKey {
+			key = column
+			count++
+		}
+	}
+	return key, count == 1
+}
+
+// snake turns an exported Go name into a snake case json name, e.g. BooksByAuthor into books_by_author.
+func snake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 && !(name[i-1] >= 'A' && name[i-1] <= 'Z') {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package gen
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/schema"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDB(t *testing.T) (*sql.DB, string) {
+	dsn := filepath.Join(t.TempDir(), "gen.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	for _, statement := range []string{
+		"CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT NOT NULL, homepage_url TEXT, born_at TIMESTAMP)",
+		"CREATE TABLE categories (id INTEGER PRIMARY KEY, name TEXT NOT NULL, parent_id INTEGER REFERENCES categories(id))",
+		"CREATE TABLE books (id INTEGER PRIMARY KEY, title VARCHAR(200) NOT NULL, price REAL, published BOOLEAN NOT NULL, " +
+			"author_id INTEGER NOT NULL REFERENCES authors(id), editor_id INTEGER REFERENCES authors(id), category_id INTEGER REFERENCES categories(id))",
+		"CREATE TABLE tags (id INTEGER PRIMARY KEY, label TEXT NOT NULL)",
+		"CREATE TABLE book_tags (book_id INTEGER NOT NULL REFERENCES books(id), tag_id INTEGER NOT NULL REFERENCES tags(id), PRIMARY KEY (book_id, tag_id))",
+		"CREATE TABLE settings (key TEXT PRIMARY KEY, value TEXT)",
+		"CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT NOT NULL)",
+		"CREATE TABLE cities (id INTEGER PRIMARY KEY, name TEXT NOT NULL, country_code TEXT NOT NULL REFERENCES countries(code))",
+		"CREATE TABLE events (at TIMESTAMP NOT NULL, message TEXT)",
+		"CREATE TABLE s2s_migrations (version TEXT PRIMARY KEY)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(statement, err)
+		}
+	}
+	return db, dsn
+}
+
+func TestNames(t *testing.T) {
+	for name, expected := range map[string]string{"categories": "Category", "user_roles": "UserRole", "addresses": "Address",
+		"boxes": "Box", "status": "Status", "people": "People", "api_keys": "APIKey", "2fa_codes": "X2faCode"} {
+		if got := camel(singular(name)); got != expected {
+			t.Fatal(name, got, expected)
+		}
+	}
+	if snake("BooksByAuthorID") != "books_by_author_id" || trimID("owner") != "owner" || trimID("owner_id") != "owner" {
+		t.Fatal("unexpected names")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	db, _ := openDB(t)
+	tables, err := schema.Introspect(db, config.DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := Generate(tables, Options{Package: "library"})
+	if err != nil || len(files) != 1 {
+		t.Fatal(files, err)
+	}
+	// fields compared with single spaces, whatever the gofmt alignment
+	models := strings.Join(strings.Fields(string(files["models.go"])), " ")
+	for _, expected := range []string{
+		"package library",
+		`ID int64 ` + "`" + `json:"id" db:"id" s2s_id:"true" s2s_table_name:"authors"` + "`",
+		"HomepageURL *string",
+		"BornAt *time.Time",
+		`BooksByEditor *[]Book ` + "`" + `json:"books_by_editor,omitempty" s2s:"editor_id = ?"` + "`",
+		`AuthorID int64 ` + "`" + `json:"author_id" db:"author_id" s2s_ref_value:"Author.ID"` + "`",
+		`Author *Author ` + "`" + `json:"author,omitempty" s2s:"id = ?" s2s_param:"AuthorID"` + "`",
+		`Parent *Category ` + "`" + `json:"parent,omitempty" s2s:"id = ?" s2s_param:"ParentID"` + "`",
+		`Categories *[]Category`,
+		`s2s:"id in (select tag_id from book_tags where book_id = ?)"`,
+		`s2s:"id in (select book_id from book_tags where tag_id = ?)"`,
+		`Key string ` + "`" + `json:"key" db:"key" s2s_id:"true" s2s_table_name:"settings"` + "`",
+		`Cities *[]City ` + "`" + `json:"cities,omitempty" s2s:"country_code = ?"` + "`",
+		`CountryCodeCountry *Country ` + "`" + `json:"country_code_country,omitempty" s2s:"code = ?" s2s_param:"CountryCode"` + "`",
+		"// Event is the table events. It has no single column primary key",
+	} {
+		if !strings.Contains(models, expected) {
+			t.Fatal("expected", expected, "in", models)
+		}
+	}
+	if strings.Contains(models, "BookTag") || strings.Contains(models, "S2sMigration") {
+		t.Fatal("expected no model for link and s2s tables", models)
+	}
+
+	files, err = Generate(tables, Options{Tables: []string{"authors", "books"}, Repositories: true, Handlers: true, Routes: true})
+	if err != nil || len(files) != 4 {
+		t.Fatal(files, err)
+	}
+	models = strings.Join(strings.Fields(string(files["models.go"])), " ")
+	if strings.Contains(models, "*Category") || !strings.Contains(models, "CategoryID *int64 `json:\"category_id\" db:\"category_id\"`") {
+		t.Fatal("expected the relations to other tables to be left out", models)
+	}
+	if !strings.Contains(string(files["handlers.go"]), "h.SetRepository(NewBookRepository())") ||
+		!strings.Contains(string(files["routes.go"]), `NewBookHandler().RegisterRoutes(g.Group("/books"))`) {
+		t.Fatal("unexpected scaffolding", string(files["handlers.go"]), string(files["routes.go"]))
+	}
+	files, _ = Generate(tables, Options{Tables: []string{"tags"}, Routes: true})
+	if !strings.Contains(string(files["routes.go"]), `handlers.NewHandler[Tag]().RegisterRoutes(g.Group("/tags"))`) {
+		t.Fatal("expected the generic handler", string(files["routes.go"]))
+	}
+	if _, err := Generate(tables, Options{Tables: []string{"missing"}}); err == nil {
+		t.Fatal("expected no tables")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	_, dsn := openDB(t)
+	dir := filepath.Join(t.TempDir(), "models")
+	out := &bytes.Buffer{}
+	if err := Command(context.Background(), []string{"-dsn", dsn, "-out", dir, "-repositories"}, out); err != nil {
+		t.Fatal(err, out.String())
+	}
+	models, err := os.ReadFile(filepath.Join(dir, "models.go"))
+	if err != nil || !strings.Contains(string(models), "package models") || !strings.Contains(out.String(), "repositories.go") {
+		t.Fatal(string(models), err, out.String())
+	}
+	if err := Command(context.Background(), []string{"-dsn", dsn, "-out", dir}, out); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Fatal("expected existing files to be kept", err)
+	}
+	if err := Command(context.Background(), []string{"-dsn", dsn, "-out", dir, "-package", "db", "-force"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if models, _ := os.ReadFile(filepath.Join(dir, "models.go")); !strings.Contains(string(models), "package db") {
+		t.Fatal("expected the file to be overwritten", string(models))
+	}
+}
@@ -0,0 +1,123 @@
+package gen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/schema"
+)
+
+const header = "// Generated by struct2serve gen from the database schema; edit as needed.\n\npackage %s\n\n"
+
+// Generate returns the source of the models of tables and, as set in options, of their repositories,
+// handlers and routes, keyed by file name: models.go, repositories.go, handlers.go and routes.go.
+func Generate(tables map[string]schema.Table, options Options) (map[string][]byte, error) {
+	if options.Package == "" {
+		options.Package = "models"
+	}
+	models := Models(tables, options)
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no tables to generate")
+	}
+	files := map[string][]byte{}
+	add := func(name string, imports []string, body string) error {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, header, options.Package)
+		if len(imports) > 0 {
+			b.WriteString("import (\n")
+			for _, path := range imports {
+				fmt.Fprintf(&b, "\t%q\n", path)
+			}
+			b.WriteString(")\n\n")
+		}
+		b.WriteString(body)
+		source, err := format.Source(b.Bytes())
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		files[name] = source
+		return nil
+	}
+
+	var body strings.Builder
+	imports := []string{}
+	for _, model := range models {
+		if !model.HasID {
+			fmt.Fprintf(&body, "// %s is the table %s. It has no single column primary key, which repositories use as id.\n", model.Name, model.Table)
+		} else {
+			fmt.Fprintf(&body, "// %s is the table %s.\n", model.Name, model.Table)
+		}
+		fmt.Fprintf(&body, "type %s struct {\n", model.Name)
+		for _, field := range model.Fields {
+			fmt.Fprintf(&body, "\t%s %s `%s`\n", field.Name, field.Type, field.Tag)
+			if strings.Contains(field.Type, "time.Time") && len(imports) == 0 {
+				imports = append(imports, "time")
+			}
+		}
+		body.WriteString("}\n\n")
+	}
+	if err := add("models.go", imports, body.String()); err != nil {
+		return nil, err
+	}
+
+	served := []Model{}
+	for _, model := range models {
+		if model.HasID {
+			served = append(served, model)
+		}
+	}
+
+	if options.Repositories {
+		body.Reset()
+		for _, model := range served {
+			fmt.Fprintf(&body, "type %sRepository struct {\n\trepositories.Repository[%s]\n}\n\n", model.Name, model.Name)
+			fmt.Fprintf(&body, "func New%sRepository() *%sRepository {\n\treturn &%sRepository{\n\t\tRepository: *repositories.NewRepository[%s](),\n\t}\n}\n\n",
+				model.Name, model.Name, model.Name, model.Name)
+		}
+		if err := add("repositories.go", []string{"github.com/arturoeanton/go-struct2serve/repositories"}, body.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Handlers {
+		body.Reset()
+		for _, model := range served {
+			fmt.Fprintf(&body, "type %sHandler struct {\n\t*handlers.Handler[%s]\n}\n\n", model.Name, model.Name)
+			fmt.Fprintf(&body, "func New%sHandler() *%sHandler {\n\th := handlers.NewHandler[%s]()\n", model.Name, model.Name, model.Name)
+			if options.Repositories {
+				fmt.Fprintf(&body, "\th.SetRepository(New%sRepository())\n", model.Name)
+			}
+			fmt.Fprintf(&body, "\treturn &%sHandler{Handler: h}\n}\n\n", model.Name)
+		}
+		if err := add("handlers.go", []string{"github.com/arturoeanton/go-struct2serve/handlers"}, body.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Routes {
+		body.Reset()
+		body.WriteString("// RegisterRoutes registers the CRUD routes of every model under g, e.g. RegisterRoutes(e.Group(\"/api\")).\n")
+		body.WriteString("func RegisterRoutes(g *echo.Group) {\n")
+		imports := []string{"github.com/labstack/echo/v4"}
+		for _, model := range served {
+			handler := "New" + model.Name + "Handler()"
+			if !options.Handlers {
+				handler = "handlers.NewHandler[" + model.Name + "]()"
+				if options.Repositories {
+					handler += ".SetRepository(New" + model.Name + "Repository())"
+				}
+			}
+			fmt.Fprintf(&body, "\t%s.RegisterRoutes(g.Group(%q))\n", handler, "/"+strings.ReplaceAll(model.Table, "_", "-"))
+		}
+		body.WriteString("}\n")
+		if !options.Handlers {
+			imports = append([]string{"github.com/arturoeanton/go-struct2serve/handlers"}, imports...)
+		}
+		if err := add("routes.go", imports, body.String()); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
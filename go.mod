@@ -3,8 +3,12 @@ module github.com/arturoeanton/go-struct2serve
 go 1.20
 
 require (
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/labstack/echo/v4 v4.10.2
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.17
+	golang.org/x/crypto v0.6.0
+	golang.org/x/net v0.7.0
 )
 
 require (
@@ -13,8 +17,6 @@ require (
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.6.0 // indirect
-	golang.org/x/net v0.7.0 // indirect
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 )
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/labstack/echo/v4"
+)
+
+// S2S_VERSION marks the field whose value changes on every update, e.g. `db:"revision" s2s_version:"true"`.
+// Without it a db column named "version" or "updated_at" is used.
+var S2S_VERSION string = "s2s_version"
+
+// SetCacheControl sets the Cache-Control header of GetAll and GetByID, e.g. "private, max-age=60"
+// or "no-cache" to make clients revalidate every time with the ETag.
+func (h *Handler[T]) SetCacheControl(value string) *Handler[T] {
+	h.cacheControl = value
+	return h
+}
+
+// versionField returns the index of the version field of itemType.
+func versionField(itemType reflect.Type) (int, bool) {
+	fallback := -1
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Tag.Get(S2S_VERSION) == "true" {
+			return i, true
+		}
+		if column := field.Tag.Get("db"); fallback < 0 && (column == "version" || column == "updated_at") {
+			fallback = i
+		}
+	}
+	return fallback, fallback >= 0
+}
+
+// respondCached writes v, the redacted items, like respond, with an ETag and a Last-Modified
+// from the version field of items, and answers 304 when the client already has the representation.
+// Without a version field, or when relations are loaded, whose rows and links the version does not
+// cover, the ETag is the hash of the body. If-Modified-Since is only honored for a single row
+// without relations, since removing a row from a list does not change its Last-Modified.
+func (h *Handler[T]) respondCached(c echo.Context, items []*T, v interface{}, list bool) error {
+	header := c.Response().Header()
+	if h.cacheControl != "" {
+		header.Set(echo.HeaderCacheControl, h.cacheControl)
+	}
+	encoder := negotiate(c)
+	contentType := encoder.ContentType()
+	if _, ok := encoder.(JSONEncoder); ok {
+		contentType = echo.MIMEApplicationJSONCharsetUTF8
+	}
+	header.Add(echo.HeaderVary, echo.HeaderAccept)
+
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	nested := relationsLoaded(items)
+	var lastModified time.Time
+	if index, ok := modifiedField(itemType); ok && !nested {
+		for _, item := range items {
+			if t, ok := timeOf(reflect.ValueOf(item).Elem().Field(index).Interface()); ok && t.After(lastModified) {
+				lastModified = t
+			}
+		}
+	}
+	var etag string
+	if index, ok := versionField(itemType); ok && !nested {
+		roles, _ := h.roles(c)
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s\n%s\n%d\n", contentType, strings.Join(roles, ","), len(items))
+		for _, item := range items {
+			version := reflect.ValueOf(item).Elem().Field(index).Interface()
+			if t, ok := timeOf(version); ok {
+				version = t.UnixNano()
+			}
+			fmt.Fprintf(hash, "%v:%v\n", itemID(item), version)
+		}
+		etag = `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	}
+	if !lastModified.IsZero() {
+		header.Set(echo.HeaderLastModified, lastModified.UTC().Format(http.TimeFormat))
+	}
+	since := lastModified
+	if list {
+		since = time.Time{}
+	}
+	if etag != "" {
+		header.Set("ETag", etag)
+		if notModified(c, etag, since) {
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+
+	body, bodyType, err := encodeBody(encoder, v)
+	if err != nil {
+		return err
+	}
+	if etag == "" {
+		sum := sha256.Sum256(body)
+		etag = `"` + hex.EncodeToString(sum[:16]) + `"`
+		header.Set("ETag", etag)
+		if notModified(c, etag, since) {
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+	if _, ok := encoder.(JSONEncoder); !ok {
+		contentType = bodyType
+	}
+	return c.Blob(http.StatusOK, contentType, body)
+}
+
+// relationsLoaded reports whether any s2s relation of items was loaded.
+func relationsLoaded[T any](items []*T) bool {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < itemType.NumField(); i++ {
+		if itemType.Field(i).Tag.Get(repositories.S2S) == "" {
+			continue
+		}
+		for _, item := range items {
+			if !reflect.ValueOf(item).Elem().Field(i).IsZero() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when it is absent, as in RFC 9110.
+func notModified(c echo.Context, etag string, lastModified time.Time) bool {
+	req := c.Request()
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if since := req.Header.Get("If-Modified-Since"); since != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// modifiedField returns the index of the updated_at field, or of the version field when it is a time.
+func modifiedField(itemType reflect.Type) (int, bool) {
+	timeType := reflect.TypeOf(time.Time{})
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Tag.Get("db") == "updated_at" && (field.Type == timeType || field.Type == reflect.PtrTo(timeType)) {
+			return i, true
+		}
+	}
+	if index, ok := versionField(itemType); ok {
+		if field := itemType.Field(index); field.Type == timeType || field.Type == reflect.PtrTo(timeType) {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+func timeOf(value interface{}) (time.Time, bool) {
+	switch t := value.(type) {
+	case time.Time:
+		return t, !t.IsZero()
+	case *time.Time:
+		if t != nil {
+			return *t, !t.IsZero()
+		}
+	}
+	return time.Time{}, false
+}
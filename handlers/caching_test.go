@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/labstack/echo/v4"
+)
+
+type Page struct {
+	ID        int       `json:"id" db:"id" s2s_table_name:"pages"`
+	Title     string    `json:"title" db:"title"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type Label struct {
+	ID   int    `json:"id" db:"id" s2s_table_name:"labels"`
+	Name string `json:"name" db:"name"`
+}
+
+type Post struct {
+	ID      int      `json:"id" db:"id" s2s_table_name:"posts"`
+	Version int      `json:"version" db:"version"`
+	Labels  *[]Label `json:"labels,omitempty" s2s:"id in (select label_id from post_labels where post_id = ?)"`
+}
+
+func conditional(e *echo.Echo, path string, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-User", "ann")
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCachingBodyHash(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+
+	rec := conditional(e, "/notes/1", "", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Header().Get("Last-Modified") != "" {
+		t.Fatal("expected an ETag without Last-Modified", rec.Code, rec.Header())
+	}
+	if rec := conditional(e, "/notes/1", "If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatal("expected 304, got", rec.Code)
+	}
+
+	list := conditional(e, "/notes", "", "").Header().Get("ETag")
+	repositories.NewRepository[Note]().Update(&Note{ID: 1, Owner: "ann", Text: "a1 edited"})
+	if rec := conditional(e, "/notes/1", "If-None-Match", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatal("expected a new representation after the update, got", rec.Code)
+	}
+	if rec := conditional(e, "/notes", "If-None-Match", list); rec.Code != http.StatusOK {
+		t.Fatal("expected the list to change, got", rec.Code)
+	}
+}
+
+func TestCachingVersion(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	modified := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := config.DB.Exec("CREATE TABLE pages (id INTEGER PRIMARY KEY, title TEXT, updated_at TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	repo := repositories.NewRepository[Page]()
+	repo.Create(&Page{ID: 1, Title: "home", UpdatedAt: modified})
+	repo.Create(&Page{ID: 2, Title: "about", UpdatedAt: modified.Add(-time.Hour)})
+	NewHandler[Page]().SetCacheControl("private, max-age=60").RegisterRoutes(e.Group("/pages"))
+
+	rec := conditional(e, "/pages/1", "", "")
+	if rec.Header().Get("Cache-Control") != "private, max-age=60" || rec.Header().Get("Last-Modified") != modified.Format(http.TimeFormat) {
+		t.Fatal("unexpected headers", rec.Header())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag[:2] != "W/" {
+		t.Fatal("expected a weak ETag from the version, got", etag)
+	}
+	if rec := conditional(e, "/pages/1", "If-Modified-Since", modified.Format(http.TimeFormat)); rec.Code != http.StatusNotModified {
+		t.Fatal("expected 304, got", rec.Code)
+	}
+	if rec := conditional(e, "/pages/1", "If-Modified-Since", modified.Add(-time.Minute).Format(http.TimeFormat)); rec.Code != http.StatusOK {
+		t.Fatal("expected 200, got", rec.Code)
+	}
+	list := conditional(e, "/pages", "", "")
+	if list.Header().Get("Last-Modified") != modified.Format(http.TimeFormat) {
+		t.Fatal("expected the newest row to set Last-Modified", list.Header())
+	}
+
+	// deleting a row keeps Last-Modified but changes the ETag of the list
+	repo.Delete(2)
+	if rec := conditional(e, "/pages", "If-None-Match", list.Header().Get("ETag")); rec.Code != http.StatusOK {
+		t.Fatal("expected the list to change, got", rec.Code)
+	}
+	if rec := conditional(e, "/pages", "If-Modified-Since", modified.Format(http.TimeFormat)); rec.Code != http.StatusOK {
+		t.Fatal("expected If-Modified-Since to be ignored on lists, got", rec.Code)
+	}
+}
+
+func TestCachingRelations(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	_, err := config.DB.Exec(`CREATE TABLE posts (id INTEGER PRIMARY KEY, version INTEGER);
+		CREATE TABLE labels (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE post_labels (post_id INTEGER, label_id INTEGER);
+		INSERT INTO posts VALUES (1, 1);
+		INSERT INTO labels VALUES (1, 'go'), (2, 'sql');
+		INSERT INTO post_labels VALUES (1, 1);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewHandler[Post]().RegisterRoutes(e.Group("/posts"))
+
+	rec := conditional(e, "/posts/1", "", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || etag[:2] == "W/" {
+		t.Fatal("expected the hash of the body when relations are loaded, got", rec.Code, etag)
+	}
+
+	// linking a row does not bump the version of the post
+	config.DB.Exec("INSERT INTO post_labels VALUES (1, 2)")
+	if rec := conditional(e, "/posts/1", "If-None-Match", etag); rec.Code != http.StatusOK {
+		t.Fatal("expected the new link to change the ETag, got", rec.Code)
+	}
+}
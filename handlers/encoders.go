@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/validators"
+	"github.com/labstack/echo/v4"
+)
+
+// Encoder writes a response body in one format.
+type Encoder interface {
+	ContentType() string
+	Encode(w io.Writer, v interface{}) error
+}
+
+var (
+	encodersMutex sync.RWMutex
+	encoders      = map[string]Encoder{
+		"json":   JSONEncoder{},
+		"ndjson": NDJSONEncoder{},
+		"csv":    CSVEncoder{FlattenRelations: true},
+		"xml":    XMLEncoder{Root: "items"},
+	}
+	// encoderNames keeps the registration order, the first match wins during negotiation.
+	encoderNames = []string{"json", "ndjson", "csv", "xml"}
+)
+
+// RegisterEncoder adds or replaces the encoder used for ?format=name and for its content type in Accept.
+func RegisterEncoder(name string, encoder Encoder) {
+	encodersMutex.Lock()
+	defer encodersMutex.Unlock()
+	if _, ok := encoders[name]; !ok {
+		encoderNames = append(encoderNames, name)
+	}
+	encoders[name] = encoder
+}
+
+// negotiate picks the encoder from ?format= or the Accept header, json by default.
+// Media types are tried by decreasing q, and in header order on ties; wildcards and
+// types matching several encoders pick the first registered one.
+func negotiate(c echo.Context) Encoder {
+	encodersMutex.RLock()
+	defer encodersMutex.RUnlock()
+	if format := c.QueryParam("format"); format != "" {
+		if encoder, ok := encoders[format]; ok {
+			return encoder
+		}
+	}
+	for _, mediaType := range acceptedTypes(c.Request().Header.Get(echo.HeaderAccept)) {
+		if mediaType == "*/*" {
+			return encoders["json"]
+		}
+		for _, name := range encoderNames {
+			if matchMediaType(mediaType, encoders[name].ContentType()) {
+				return encoders[name]
+			}
+		}
+	}
+	return encoders["json"]
+}
+
+// acceptedTypes returns the media types of an Accept header sorted by q, leaving out q=0.
+func acceptedTypes(header string) []string {
+	type accepted struct {
+		mediaType string
+		q         float64
+	}
+	types := []accepted{}
+	for _, accept := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			types = append(types, accepted{mediaType, q})
+		}
+	}
+	sort.SliceStable(types, func(i, j int) bool { return types[i].q > types[j].q })
+	mediaTypes := make([]string, 0, len(types))
+	for _, t := range types {
+		mediaTypes = append(mediaTypes, t.mediaType)
+	}
+	return mediaTypes
+}
+
+// matchMediaType reports whether contentType is mediaType, or in it when mediaType is type/*.
+func matchMediaType(mediaType, contentType string) bool {
+	if prefix, ok := strings.CutSuffix(mediaType, "/*"); ok {
+		return strings.HasPrefix(contentType, prefix+"/")
+	}
+	return mediaType == contentType
+}
+
+// respond writes v with the negotiated encoder, see encodeBody.
+func respond(c echo.Context, code int, v interface{}) error {
+	encoder := negotiate(c)
+	if _, ok := encoder.(JSONEncoder); ok {
+		return c.JSON(code, v)
+	}
+	body, contentType, err := encodeBody(encoder, v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(code, contentType, body)
+}
+
+// encodeBody encodes v into a buffer, so a value the encoder does not support, e.g. a map in XML,
+// falls back to json instead of a truncated body.
+func encodeBody(encoder Encoder, v interface{}) ([]byte, string, error) {
+	var body bytes.Buffer
+	err := encoder.Encode(&body, v)
+	if err == nil {
+		return body.Bytes(), encoder.ContentType(), nil
+	}
+	if _, ok := encoder.(JSONEncoder); ok {
+		return nil, "", err
+	}
+	body.Reset()
+	if err := (JSONEncoder{}).Encode(&body, v); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), echo.MIMEApplicationJSONCharsetUTF8, nil
+}
+
+type JSONEncoder struct{}
+
+func (JSONEncoder) ContentType() string { return echo.MIMEApplicationJSON }
+
+func (JSONEncoder) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// NDJSONEncoder writes one json document per line, one per element when v is a slice.
+type NDJSONEncoder struct{}
+
+func (NDJSONEncoder) ContentType() string { return "application/x-ndjson" }
+
+func (NDJSONEncoder) Encode(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Slice {
+		return enc.Encode(v)
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := enc.Encode(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// XMLEncoder wraps slices in a Root element and writes maps with string keys, e.g. error bodies, as
+// a <response> element with an element per key.
+type XMLEncoder struct {
+	Root string
+}
+
+func (XMLEncoder) ContentType() string { return echo.MIMEApplicationXML }
+
+func (e XMLEncoder) Encode(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String {
+		return encodeXMLMap(enc, value)
+	}
+	if value.Kind() != reflect.Slice {
+		return enc.Encode(v)
+	}
+	root := xml.StartElement{Name: xml.Name{Local: e.Root}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := enc.Encode(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+func encodeXMLMap(enc *xml.Encoder, value reflect.Value) error {
+	keys := make([]string, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	root := xml.StartElement{Name: xml.Name{Local: "response"}}
+	if err := enc.EncodeToken(root); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		element := value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key())).Interface()
+		if err := enc.EncodeElement(element, xml.StartElement{Name: xml.Name{Local: key}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(root.End()); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// CSVEncoder writes one row per element with the columns in field order, named by json tag (or db tag).
+// Single nested structs are flattened as parent.child when FlattenRelations is set; slices are omitted.
+// Nil items are skipped, so a nil pointer gives just the header.
+type CSVEncoder struct {
+	FlattenRelations bool
+}
+
+func (CSVEncoder) ContentType() string { return "text/csv" }
+
+func (e CSVEncoder) Encode(w io.Writer, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if !value.IsValid() {
+		return fmt.Errorf("csv: unsupported type nil")
+	}
+	if value.Kind() != reflect.Slice {
+		slice := reflect.MakeSlice(reflect.SliceOf(value.Type()), 0, 1)
+		if value.Kind() != reflect.Ptr || !value.IsNil() {
+			slice = reflect.Append(slice, value)
+		}
+		value = slice
+	}
+	itemType := value.Type().Elem()
+	for itemType.Kind() == reflect.Ptr {
+		itemType = itemType.Elem()
+	}
+	if itemType.Kind() != reflect.Struct {
+		return fmt.Errorf("csv: unsupported type %s", itemType)
+	}
+
+	columns := e.columns(itemType, "", []int{}, 1)
+	writer := csv.NewWriter(w)
+	header := make([]string, 0, len(columns))
+	for _, column := range columns {
+		header = append(header, column.name)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for i := 0; i < value.Len(); i++ {
+		item := value.Index(i)
+		if item.Kind() == reflect.Ptr && item.IsNil() {
+			continue
+		}
+		record := make([]string, 0, len(columns))
+		for _, column := range columns {
+			record = append(record, csvValue(item, column.index))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+func (e CSVEncoder) columns(itemType reflect.Type, prefix string, index []int, depth int) []csvColumn {
+	columns := []csvColumn{}
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		name := validators.JsonName(field)
+		if field.Tag.Get("json") == "" && field.Tag.Get("db") != "" {
+			name = field.Tag.Get("db")
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		switch {
+		case fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Map:
+			continue
+		case fieldType.Kind() == reflect.Struct && fieldType != reflect.TypeOf(time.Time{}):
+			if e.FlattenRelations && depth > 0 {
+				columns = append(columns, e.columns(fieldType, prefix+name+".", fieldIndex, depth-1)...)
+			}
+			continue
+		}
+		columns = append(columns, csvColumn{name: prefix + name, index: fieldIndex})
+	}
+	return columns
+}
+
+// csvValue follows index through nested (possibly nil) pointers and formats the value.
+func csvValue(value reflect.Value, index []int) string {
+	for _, i := range index {
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return ""
+			}
+			value = value.Elem()
+		}
+		value = value.Field(i)
+	}
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	if t, ok := value.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(value.Interface())
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/labstack/echo/v4"
+)
+
+type encodedAuthor struct {
+	Name string `json:"name"`
+}
+
+type encodedBook struct {
+	ID        int              `json:"id" db:"id"`
+	Title     string           `json:"title"`
+	Published time.Time        `json:"published"`
+	Author    *encodedAuthor   `json:"author"`
+	Tags      []string         `json:"tags"`
+	Authors   *[]encodedAuthor `json:"authors"`
+	Secret    string           `json:"-"`
+}
+
+func encode(t *testing.T, encoder Encoder, v interface{}) string {
+	out := &bytes.Buffer{}
+	if err := encoder.Encode(out, v); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestEncoders(t *testing.T) {
+	published := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	books := []*encodedBook{
+		{ID: 1, Title: "Dune", Published: published, Author: &encodedAuthor{Name: "Frank"}, Secret: "x"},
+		nil,
+		{ID: 2, Title: "Emma, a novel", Published: published},
+	}
+
+	if got := encode(t, JSONEncoder{}, books[0]); !strings.Contains(got, `"title":"Dune"`) || strings.Contains(got, "Secret") {
+		t.Error("unexpected json", got)
+	}
+	if got := encode(t, NDJSONEncoder{}, books); strings.Count(got, "\n") != 3 || !strings.Contains(got, "\nnull\n") {
+		t.Error("expected a line per element", got)
+	}
+
+	got := encode(t, CSVEncoder{FlattenRelations: true}, books)
+	expected := "id,title,published,author.name\n" +
+		"1,Dune,2023-05-01T00:00:00Z,Frank\n" +
+		"2,\"Emma, a novel\",2023-05-01T00:00:00Z,\n"
+	if got != expected {
+		t.Errorf("unexpected csv %q", got)
+	}
+	if got := encode(t, CSVEncoder{}, books[0]); got != "id,title,published\n1,Dune,2023-05-01T00:00:00Z\n" {
+		t.Errorf("unexpected csv of one item %q", got)
+	}
+	if got := encode(t, CSVEncoder{}, (*encodedBook)(nil)); got != "id,title,published\n" {
+		t.Errorf("expected only the header for nil %q", got)
+	}
+	if err := (CSVEncoder{}).Encode(&bytes.Buffer{}, []int{1}); err == nil {
+		t.Error("expected an error for non struct items")
+	}
+
+	got = encode(t, XMLEncoder{Root: "books"}, []*encodedBook{books[0], books[2]})
+	if !strings.HasPrefix(got, "<?xml") || strings.Count(got, "<encodedBook>") != 2 || !strings.Contains(got, "<books>") {
+		t.Error("unexpected xml", got)
+	}
+	if got := encode(t, XMLEncoder{Root: "books"}, books[0]); strings.Contains(got, "<books>") {
+		t.Error("expected no root for a single item", got)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	e := echo.New()
+	for accept, expected := range map[string]string{
+		"":                                      echo.MIMEApplicationJSON,
+		"*/*":                                   echo.MIMEApplicationJSON,
+		"text/csv":                              "text/csv",
+		"text/*":                                "text/csv",
+		"text/html, application/xml":            echo.MIMEApplicationXML,
+		"text/csv;q=0.5, application/xml":       echo.MIMEApplicationXML,
+		"application/xml;q=0.8, text/csv;q=0.8": echo.MIMEApplicationXML,
+		"text/csv;q=0, */*;q=0.1":               echo.MIMEApplicationJSON,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAccept, accept)
+		if got := negotiate(e.NewContext(req, httptest.NewRecorder())).ContentType(); got != expected {
+			t.Errorf("Accept %q: expected %s, got %s", accept, expected, got)
+		}
+	}
+	req := httptest.NewRequest(http.MethodGet, "/?format=ndjson", nil)
+	req.Header.Set(echo.HeaderAccept, "text/csv")
+	if got := negotiate(e.NewContext(req, httptest.NewRecorder())).ContentType(); got != "application/x-ndjson" {
+		t.Error("expected ?format= to win over Accept", got)
+	}
+}
+
+func TestGetByIDCSVMissing(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+
+	rec := request(e, http.MethodGet, "/notes/42?format=csv", "ann")
+	if rec.Code != http.StatusOK || rec.Body.String() != "id,owner,text,locked\n" {
+		t.Error("expected only the header", rec.Code, rec.Body.String())
+	}
+	rec = request(e, http.MethodGet, "/notes/1?format=csv", "ann")
+	if rec.Body.String() != "id,owner,text,locked\n1,ann,a1,false\n" {
+		t.Error("unexpected csv", rec.Body.String())
+	}
+}
+
+func TestXMLMaps(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+
+	// error bodies are maps
+	e.GET("/missing", func(c echo.Context) error {
+		return respond(c, http.StatusNotFound, map[string]string{"error": "Not found"})
+	})
+	rec := request(e, http.MethodGet, "/missing?format=xml", "ann")
+	if rec.Code != http.StatusNotFound || rec.Body.String() != xml.Header+"<response><error>Not found</error></response>" {
+		t.Error("expected the error in xml, got", rec.Code, rec.Body.String())
+	}
+
+	// values xml can not encode, e.g. structs with maps, are sent as json instead of a truncated body
+	e.GET("/revisions", func(c echo.Context) error {
+		return respond(c, http.StatusOK, []repositories.Revision{{Action: "update", After: map[string]interface{}{"text": "a"}}})
+	})
+	rec = request(e, http.MethodGet, "/revisions?format=xml", "ann")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON) ||
+		!strings.Contains(rec.Body.String(), `"after":{"text":"a"}`) {
+		t.Error("expected the json fallback, got", rec.Header(), rec.Body.String())
+	}
+}
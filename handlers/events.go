@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/notifier"
+	"github.com/arturoeanton/go-struct2serve/policies"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/arturoeanton/go-struct2serve/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// EventsHeartbeat is the interval of the comments that keep idle event streams open.
+var EventsHeartbeat = 15 * time.Second
+
+// ChangeEvent is the data of a change sent by Events. Data is the row as returned by GetByID;
+// delete events only carry the id.
+type ChangeEvent struct {
+	Action string      `json:"action"`
+	ID     interface{} `json:"id"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// changeFilter holds the equality filters on columns and the accepted actions of a subscription.
+type changeFilter struct {
+	columns map[string]string
+	actions map[string]bool
+}
+
+// Events answers GET /events with a Server-Sent Events stream of the committed creates, updates and deletes.
+// Query parameters named after a readable column filter the rows and ?action=create,update the actions;
+// rows the caller can not get under the policy, tenant or roles are not sent. Deleted rows are checked
+// with Authorize and Scope on their stored values.
+func (h *Handler[T]) Events(c echo.Context) error {
+	if err := h.authorize(c, policies.ActionList, nil); err != nil {
+		return forbidden(c)
+	}
+	filter := h.changeFilter(c)
+	changes, unsubscribe := notifier.Subscribe(repositories.TableName(reflect.TypeOf((*T)(nil)).Elem()))
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	heartbeat := time.NewTicker(EventsHeartbeat)
+	defer heartbeat.Stop()
+	sequence := 0
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			if _, err := res.Write([]byte(": ping\n\n")); err != nil {
+				return nil
+			}
+			res.Flush()
+		case change, ok := <-changes:
+			if !ok {
+				return nil
+			}
+			event, ok := h.changeEvent(c, change, filter)
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			sequence++
+			if _, err := fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", sequence, change.Action, data); err != nil {
+				return nil
+			}
+			res.Flush()
+		}
+	}
+}
+
+func (h *Handler[T]) changeFilter(c echo.Context) changeFilter {
+	filter := changeFilter{columns: map[string]string{}}
+	for _, column := range h.readableColumns(c) {
+		if value := c.QueryParam(column); value != "" {
+			filter.columns[column] = value
+		}
+	}
+	if actions := splitParam(c.QueryParam("action")); len(actions) > 0 {
+		filter.actions = map[string]bool{}
+		for _, action := range actions {
+			filter.actions[action] = true
+		}
+	}
+	return filter
+}
+
+// matches reports whether the row of change passes the filter.
+func (f changeFilter) matches(change repositories.Change) bool {
+	if f.actions != nil && !f.actions[change.Action] {
+		return false
+	}
+	row := change.After
+	if row == nil {
+		row = change.Before
+	}
+	for column, value := range f.columns {
+		if row[column] == nil || fmt.Sprint(row[column]) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// changeEvent returns the event of change for the caller, false when the caller must not see it.
+func (h *Handler[T]) changeEvent(c echo.Context, change repositories.Change, filter changeFilter) (ChangeEvent, bool) {
+	event := ChangeEvent{Action: change.Action, ID: change.ID}
+	if !filter.matches(change) {
+		return event, false
+	}
+	if change.Tenant != nil {
+		tenant, ok := repositories.TenantFromContext(c.Request().Context())
+		if !ok || fmt.Sprint(tenant) != fmt.Sprint(change.Tenant) {
+			return event, false
+		}
+	}
+	if change.Action == repositories.ChangeDelete {
+		// the row is gone, so the policy is checked on the stored values
+		if h.policy != nil && h.authorize(c, policies.ActionGet, rowItem[T](change.Before)) != nil {
+			return event, false
+		}
+		ok, err := h.rowInScope(c, policies.ActionGet, change.Before)
+		return event, err == nil && ok
+	}
+	item, err := h.findByID(c, policies.ActionGet, change.ID)
+	if err != nil || item == nil || h.authorize(c, policies.ActionGet, item) != nil {
+		return event, false
+	}
+	event.Data = h.redact(c, item)
+	return event, true
+}
+
+// rowInScope reports whether the stored values of a row satisfy the scope of action, selecting
+// them as a derived table named like the table of T so the scope can refer to its columns.
+func (h *Handler[T]) rowInScope(c echo.Context, action policies.Action, row map[string]interface{}) (bool, error) {
+	scope, scopeArgs := h.scope(c, action)
+	if scope == "" {
+		return true, nil
+	}
+	if len(row) == 0 {
+		return false, nil
+	}
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	selects := make([]string, 0, len(columns))
+	args := make([]interface{}, 0, len(columns)+len(scopeArgs))
+	for _, column := range columns {
+		selects = append(selects, "? AS "+column)
+		args = append(args, row[column])
+	}
+	args = append(args, scopeArgs...)
+	table := repositories.TableName(reflect.TypeOf((*T)(nil)).Elem())
+	var count int64
+	err := config.DB.QueryRowContext(c.Request().Context(), "SELECT COUNT(*) FROM (SELECT "+strings.Join(selects, ", ")+") "+table+
+		" WHERE "+scope, args...).Scan(&count)
+	return count == 1, err
+}
+
+// rowItem builds a T from the stored columns of a change.
+func rowItem[T any](row map[string]interface{}) *T {
+	item := new(T)
+	itemValue := reflect.ValueOf(item).Elem()
+	itemType := itemValue.Type()
+	for i := 0; i < itemType.NumField(); i++ {
+		value, ok := row[itemType.Field(i).Tag.Get("db")]
+		if !ok || value == nil {
+			continue
+		}
+		utils.SetFromString(itemValue.Field(i), fmt.Sprint(value))
+	}
+	return item
+}
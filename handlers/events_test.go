@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+func TestEvents(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/notes/events?action=create,update", nil)
+	req.Header.Set("X-User", "ann")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatal("unexpected content type", res.Header.Get("Content-Type"))
+	}
+
+	repo := repositories.NewRepository[Note]()
+	// bob's note and the delete are filtered out, ann's update is sent
+	repo.Create(&Note{Owner: "bob", Text: "b2"})
+	repo.Delete(1)
+	repo.Update(&Note{ID: 2, Owner: "ann", Text: "a2 edited", Locked: true})
+
+	events := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				events <- strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	select {
+	case data := <-events:
+		event := struct {
+			Action string `json:"action"`
+			Data   Note   `json:"data"`
+		}{}
+		json.Unmarshal([]byte(data), &event)
+		if event.Action != "update" || event.Data.ID != 2 || event.Data.Text != "a2 edited" {
+			t.Error("expected the update of ann's note, got", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestEventsDelete(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/notes/events?action=delete", nil)
+	req.Header.Set("X-User", "ann")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	// the delete of bob's note is outside ann's scope, the delete of hers is sent
+	repo := repositories.NewRepository[Note]()
+	repo.Delete(3)
+	repo.Delete(1)
+
+	scanner := bufio.NewScanner(res.Body)
+	events := make(chan string)
+	go func() {
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				events <- strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	select {
+	case data := <-events:
+		if data != `{"action":"delete","id":1}` {
+			t.Error("expected the delete of ann's note, got", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+}
@@ -1,11 +1,22 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/arturoeanton/go-struct2serve/policies"
 	"github.com/arturoeanton/go-struct2serve/repositories"
 	"github.com/arturoeanton/go-struct2serve/services"
+	"github.com/arturoeanton/go-struct2serve/validators"
 	"github.com/labstack/echo/v4"
 )
 
@@ -16,11 +27,28 @@ type IHandler[T any] interface {
 	Create(c echo.Context) error
 	DeleteByID(c echo.Context) error
 	Update(c echo.Context) error
+	CreateMany(c echo.Context) error
+	UpdateMany(c echo.Context) error
+	DeleteMany(c echo.Context) error
+	Stats(c echo.Context) error
+	Stream(c echo.Context) error
+	Import(c echo.Context) error
+	History(c echo.Context) error
+	Events(c echo.Context) error
+	Live(c echo.Context) error
+	RegisterRoutes(g *echo.Group)
 }
 
 type Handler[T any] struct {
-	service services.IService[T]
-	name    string
+	service        services.IService[T]
+	name           string
+	statsColumns   map[string]bool
+	policy         policies.Policy[T]
+	principalFunc  func(c echo.Context) interface{}
+	rolesFunc      func(c echo.Context) []string
+	cacheControl   string
+	idempotencyTTL time.Duration
+	liveOrigins    []string
 }
 
 func NewHandler[T any]() *Handler[T] {
@@ -37,36 +65,138 @@ func (h *Handler[T]) Name() string {
 	return h.name
 }
 
+// GetAll answers GET with the rows visible to the principal, see listCriteria for the query parameters.
 func (h *Handler[T]) GetAll(c echo.Context) error {
-	items, err := h.service.GetAll()
+	if err := h.authorize(c, policies.ActionList, nil); err != nil {
+		return forbidden(c)
+	}
+	criteria, args, err := h.listCriteria(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	var items []*T
+	if criteria != "" {
+		items, err = h.svc(c).GetByCriteria(criteria, args...)
+	} else {
+		items, err = h.svc(c).GetAll()
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to get " + h.Name(),
 		})
 	}
-	return c.JSON(http.StatusOK, items)
+	return h.respondCached(c, items, h.redact(c, items), true)
+}
+
+// listParams are the query parameters of GetAll and Stream that are not column filters.
+var listParams = []string{"format", "sort", "limit", "offset"}
+
+// listCriteria builds the criteria of GetAll and Stream from the scope and the query parameters:
+// ?status=active filters by equality on a readable column, ?sort=name,-created_at orders
+// (descending with -) and ?limit=20&offset=40 paginates. offset requires limit.
+func (h *Handler[T]) listCriteria(c echo.Context) (string, []interface{}, error) {
+	allowed := map[string]bool{}
+	for _, column := range h.readableColumns(c) {
+		allowed[column] = true
+	}
+	reserved := map[string]bool{}
+	for _, param := range listParams {
+		reserved[param] = true
+	}
+
+	filters := []string{}
+	args := []interface{}{}
+	columns := make([]string, 0, len(c.QueryParams()))
+	for column := range c.QueryParams() {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		if reserved[column] || !allowed[column] {
+			continue
+		}
+		filters = append(filters, column+" = ?")
+		args = append(args, c.QueryParam(column))
+	}
+	scope, scopeArgs := h.scope(c, policies.ActionList)
+	criteria, args := policies.Combine(strings.Join(filters, " AND "), args, scope, scopeArgs)
+
+	tail := ""
+	orderBy := []string{}
+	for _, column := range splitParam(c.QueryParam("sort")) {
+		direction := " ASC"
+		if strings.HasPrefix(column, "-") {
+			column, direction = column[1:], " DESC"
+		}
+		if !allowed[column] {
+			return "", nil, errors.New("Column not allowed: " + column)
+		}
+		orderBy = append(orderBy, column+direction)
+	}
+	if len(orderBy) > 0 {
+		tail += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+	for _, param := range []string{"limit", "offset"} {
+		value := c.QueryParam(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return "", nil, errors.New("Invalid " + param + ": " + value)
+		}
+		if param == "offset" && c.QueryParam("limit") == "" {
+			return "", nil, errors.New("offset requires limit")
+		}
+		// inlined, a placeholder would come before the tenant argument appended by the repository
+		tail += " " + strings.ToUpper(param) + " " + strconv.Itoa(n)
+	}
+	if tail != "" && criteria == "" {
+		criteria = "1 = 1"
+	}
+	return criteria + tail, args, nil
 }
 
 func (h *Handler[T]) GetByID(c echo.Context) error {
 	id := c.Param("id")
-	item, err := h.service.GetByID(id)
+	item, err := h.findByID(c, policies.ActionGet, id)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to get " + h.Name(),
 		})
 	}
-	return c.JSON(http.StatusOK, item)
+	if item == nil {
+		return respond(c, http.StatusOK, item)
+	}
+	if err := h.authorize(c, policies.ActionGet, item); err != nil {
+		return forbidden(c)
+	}
+	return h.respondCached(c, []*T{item}, h.redact(c, item), false)
 }
 
+// Create answers POST with the id of the new row, see SetIdempotency for the Idempotency-Key header.
 func (h *Handler[T]) Create(c echo.Context) error {
+	return h.idempotent(c, h.create)
+}
+
+func (h *Handler[T]) create(c echo.Context) error {
 	item := new(T)
 	if err := c.Bind(item); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to get " + h.Name(),
 		})
 	}
-	id, err := h.service.Create(item)
+	h.sanitizeCreate(c, item)
+	if err := h.authorize(c, policies.ActionCreate, item); err != nil {
+		return forbidden(c)
+	}
+	id, err := h.svc(c).Create(item)
 	if err != nil {
+		if verr, ok := validationErrors(err); ok {
+			return c.JSON(http.StatusUnprocessableEntity, verr)
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to get " + h.Name(),
 		})
@@ -77,7 +207,21 @@ func (h *Handler[T]) Create(c echo.Context) error {
 
 func (h *Handler[T]) DeleteByID(c echo.Context) error {
 	id := c.Param("id")
-	err := h.service.Delete(id)
+	if h.policy != nil {
+		existing, err := h.findByID(c, policies.ActionDelete, id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to get " + h.Name(),
+			})
+		}
+		if existing == nil {
+			return notFound(c)
+		}
+		if err := h.authorize(c, policies.ActionDelete, existing); err != nil {
+			return forbidden(c)
+		}
+	}
+	err := h.svc(c).Delete(id)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to get " + h.Name(),
@@ -86,6 +230,7 @@ func (h *Handler[T]) DeleteByID(c echo.Context) error {
 	return c.JSON(http.StatusOK, id)
 }
 
+// Update answers PUT /:id, and PUT with the id in the body. A body id different from the path is rejected.
 func (h *Handler[T]) Update(c echo.Context) error {
 	item := new(T)
 	if err := c.Bind(item); err != nil {
@@ -93,11 +238,529 @@ func (h *Handler[T]) Update(c echo.Context) error {
 			"error": "Failed to get " + h.Name(),
 		})
 	}
-	err := h.service.Update(item)
+	if id := c.Param("id"); id != "" {
+		if err := setItemID(item, id); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+	}
+	// the stored row must be in the update scope and pass the policy, as well as the new values
+	existing, err := h.findByID(c, policies.ActionUpdate, itemID(item))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get " + h.Name(),
+		})
+	}
+	if existing == nil {
+		return notFound(c)
+	}
+	if h.authorize(c, policies.ActionUpdate, existing) != nil || h.authorize(c, policies.ActionUpdate, item) != nil {
+		return forbidden(c)
+	}
+	if err := h.mergeUpdates(c, item); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get " + h.Name(),
+		})
+	}
+	err = h.svc(c).Update(item)
 	if err != nil {
+		if verr, ok := validationErrors(err); ok {
+			return c.JSON(http.StatusUnprocessableEntity, verr)
+		}
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": "Failed to get " + h.Name(),
 		})
 	}
 	return c.JSON(http.StatusNoContent, nil)
 }
+
+func (h *Handler[T]) CreateMany(c echo.Context) error {
+	return h.idempotent(c, h.createMany)
+}
+
+func (h *Handler[T]) createMany(c echo.Context) error {
+	items := []*T{}
+	if err := c.Bind(&items); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid body for " + h.Name(),
+		})
+	}
+	for _, item := range items {
+		h.sanitizeCreate(c, item)
+		if err := h.authorize(c, policies.ActionCreate, item); err != nil {
+			return forbidden(c)
+		}
+	}
+	ids, err := h.svc(c).CreateMany(items)
+	if err != nil {
+		if verr, ok := validationErrors(err); ok {
+			return c.JSON(http.StatusUnprocessableEntity, verr)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to create " + h.Name(),
+		})
+	}
+	return c.JSON(http.StatusOK, ids)
+}
+
+func (h *Handler[T]) UpdateMany(c echo.Context) error {
+	items := []*T{}
+	if err := c.Bind(&items); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid body for " + h.Name(),
+		})
+	}
+	ids := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if err := h.authorize(c, policies.ActionUpdate, item); err != nil {
+			return forbidden(c)
+		}
+		ids = append(ids, itemID(item))
+	}
+	ok, err := h.visible(c, policies.ActionUpdate, ids...)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update " + h.Name(),
+		})
+	}
+	if !ok {
+		return forbidden(c)
+	}
+	if h.policy != nil || h.merges(c) {
+		// the stored rows are authorized too, so the body can not claim someone else's row
+		stored, err := h.stored(c, ids)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to update " + h.Name(),
+			})
+		}
+		if err := h.authorizeStored(c, policies.ActionUpdate, stored, ids); err != nil {
+			return forbidden(c)
+		}
+		if h.merges(c) {
+			h.mergeStored(c, stored, items)
+		}
+	}
+	err = h.svc(c).UpdateMany(items)
+	if err != nil {
+		if verr, ok := validationErrors(err); ok {
+			return c.JSON(http.StatusUnprocessableEntity, verr)
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to update " + h.Name(),
+		})
+	}
+	return c.JSON(http.StatusNoContent, nil)
+}
+
+func (h *Handler[T]) DeleteMany(c echo.Context) error {
+	ids := []interface{}{}
+	if err := c.Bind(&ids); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "Invalid body for " + h.Name(),
+		})
+	}
+	for i, id := range ids {
+		// json numbers arrive as float64
+		if f, ok := id.(float64); ok && f == float64(int64(f)) {
+			ids[i] = int64(f)
+		}
+	}
+	ok, err := h.visible(c, policies.ActionDelete, ids...)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete " + h.Name(),
+		})
+	}
+	if !ok {
+		return forbidden(c)
+	}
+	if h.policy != nil {
+		stored, err := h.stored(c, ids)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to delete " + h.Name(),
+			})
+		}
+		if err := h.authorizeStored(c, policies.ActionDelete, stored, ids); err != nil {
+			return forbidden(c)
+		}
+	}
+	err = h.svc(c).DeleteMany(ids)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to delete " + h.Name(),
+		})
+	}
+	return c.JSON(http.StatusOK, ids)
+}
+
+// Stream answers GET /stream writing every row as soon as it is scanned, as a json array
+// or as NDJSON when ?format=ndjson or Accept: application/x-ndjson. It takes the filters, sort
+// and pagination of GetAll.
+func (h *Handler[T]) Stream(c echo.Context) error {
+	if err := h.authorize(c, policies.ActionList, nil); err != nil {
+		return forbidden(c)
+	}
+	criteria, args, err := h.listCriteria(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	ndjson := c.QueryParam("format") == "ndjson" || strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "application/x-ndjson")
+	res := c.Response()
+	// the status is sent with the first row, so that a failing query still gets a 500
+	begin := func() {
+		if res.Committed {
+			return
+		}
+		if ndjson {
+			res.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		} else {
+			res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		}
+		res.WriteHeader(http.StatusOK)
+		if !ndjson {
+			res.Write([]byte("["))
+		}
+	}
+
+	enc := json.NewEncoder(res)
+	first := true
+	err = h.svc(c).ForEach(criteria, func(item *T) error {
+		begin()
+		if !ndjson && !first {
+			if _, err := res.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		first = false
+		// Encode appends a new line, which is the NDJSON separator and harmless inside the array
+		if err := enc.Encode(h.redact(c, item)); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}, args...)
+	if err != nil {
+		log.Printf("Error al enviar %s[020-Stream]: %v", h.Name(), err)
+		if !res.Committed {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to get " + h.Name(),
+			})
+		}
+		// the status was already sent with the first rows, the client sees a truncated body
+		return nil
+	}
+	begin()
+	if !ndjson {
+		res.Write([]byte("]"))
+	}
+	return nil
+}
+
+// Import answers POST /import?format=csv|ndjson&dry_run=true. The rows are read from the multipart
+// field "file" or from the raw body; the format defaults to the Content-Type of the upload.
+func (h *Handler[T]) Import(c echo.Context) error {
+	if err := h.authorize(c, policies.ActionCreate, nil); err != nil {
+		return forbidden(c)
+	}
+	var reader io.Reader = c.Request().Body
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	if strings.HasPrefix(contentType, echo.MIMEMultipartForm) {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Missing file for " + h.Name(),
+			})
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid file for " + h.Name(),
+			})
+		}
+		defer file.Close()
+		reader = file
+		contentType = fileHeader.Header.Get(echo.HeaderContentType)
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = services.ImportCSV
+		if strings.Contains(contentType, "ndjson") || strings.Contains(contentType, "json") {
+			format = services.ImportNDJSON
+		}
+	}
+	dryRun := c.QueryParam("dry_run") == "true"
+
+	report, err := h.svc(c).Import(reader, format, dryRun)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownImportFormat) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error":  "Failed to import " + h.Name(),
+			"report": report,
+		})
+	}
+	if len(report.Errors) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, report)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// GetRelation returns the handler of GET /:id/<relation>, which loads only that s2s relation.
+func (h *Handler[T]) GetRelation(name string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, err := h.authorizeParent(c, policies.ActionGet); !ok {
+			return err
+		}
+		related, err := h.svc(c).GetRelation(c.Param("id"), name)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to get " + name + " of " + h.Name(),
+			})
+		}
+		if related == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error": "Not found",
+			})
+		}
+		return respond(c, http.StatusOK, h.redact(c, related))
+	}
+}
+
+// Link returns the handler of POST /:id/<relation>/:relatedId, which adds a row to the link table.
+func (h *Handler[T]) Link(name string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, err := h.authorizeParent(c, policies.ActionUpdate); !ok {
+			return err
+		}
+		err := h.svc(c).Link(c.Param("id"), name, c.Param("relatedId"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound(c)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to link " + name + " of " + h.Name(),
+			})
+		}
+		return c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// Unlink returns the handler of DELETE /:id/<relation>/:relatedId, which removes a row from the link table.
+func (h *Handler[T]) Unlink(name string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if ok, err := h.authorizeParent(c, policies.ActionUpdate); !ok {
+			return err
+		}
+		err := h.svc(c).Unlink(c.Param("id"), name, c.Param("relatedId"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound(c)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "Failed to unlink " + name + " of " + h.Name(),
+			})
+		}
+		return c.JSON(http.StatusNoContent, nil)
+	}
+}
+
+// History answers GET /:id/history with the audit revisions of the row, see repositories.EnableAudit.
+// Columns the caller can not read are removed from the revisions.
+func (h *Handler[T]) History(c echo.Context) error {
+	if ok, err := h.authorizeParent(c, policies.ActionGet); !ok {
+		return err
+	}
+	revisions, err := h.svc(c).History(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get history of " + h.Name(),
+		})
+	}
+	readable := map[string]bool{}
+	for _, column := range h.readableColumns(c) {
+		readable[column] = true
+	}
+	for _, revision := range revisions {
+		for _, values := range []map[string]interface{}{revision.Before, revision.After} {
+			for column := range values {
+				if !readable[column] {
+					delete(values, column)
+				}
+			}
+		}
+	}
+	return respond(c, http.StatusOK, revisions)
+}
+
+// authorizeParent checks the policy on the row :id before touching one of its relations.
+// When it is not allowed the error response has already been sent and ok is false.
+func (h *Handler[T]) authorizeParent(c echo.Context, action policies.Action) (bool, error) {
+	if h.policy == nil {
+		return true, nil
+	}
+	parent, err := h.findByID(c, action, c.Param("id"))
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get " + h.Name(),
+		})
+	}
+	if parent == nil {
+		return false, notFound(c)
+	}
+	if err := h.authorize(c, action, parent); err != nil {
+		return false, forbidden(c)
+	}
+	return true, nil
+}
+
+// SetRepository replaces the repository of the handler, e.g. with a cache.Repository.
+func (h *Handler[T]) SetRepository(repo repositories.IRepository[T]) *Handler[T] {
+	h.service = services.NewService[T](repo)
+	return h
+}
+
+// SetStatsColumns limits the columns usable by Stats in group_by, agg and filters. By default every readable db column is allowed.
+func (h *Handler[T]) SetStatsColumns(columns ...string) *Handler[T] {
+	h.statsColumns = make(map[string]bool, len(columns))
+	for _, column := range columns {
+		h.statsColumns[column] = true
+	}
+	return h
+}
+
+// Stats answers GET /stats?group_by=group_id&agg=count,sum:amount&status=active.
+// Query parameters named after an allowed column are used as equality filters.
+func (h *Handler[T]) Stats(c echo.Context) error {
+	if err := h.authorize(c, policies.ActionList, nil); err != nil {
+		return forbidden(c)
+	}
+	allowed := map[string]bool{}
+	for _, column := range h.readableColumns(c) {
+		if h.statsColumns == nil || h.statsColumns[column] {
+			allowed[column] = true
+		}
+	}
+	badRequest := func(msg string) error {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": msg,
+		})
+	}
+
+	groupBy := []string{}
+	for _, column := range splitParam(c.QueryParam("group_by")) {
+		if !allowed[column] {
+			return badRequest("Column not allowed: " + column)
+		}
+		groupBy = append(groupBy, column)
+	}
+
+	aggregates := []repositories.Aggregate{}
+	aggParam := c.QueryParam("agg")
+	if aggParam == "" {
+		aggParam = "count"
+	}
+	for _, agg := range splitParam(aggParam) {
+		fn, column, _ := strings.Cut(agg, ":")
+		if column != "" && !allowed[column] {
+			return badRequest("Column not allowed: " + column)
+		}
+		aggregates = append(aggregates, repositories.Aggregate{Func: fn, Column: column})
+	}
+
+	filters := []string{}
+	args := []interface{}{}
+	columns := make([]string, 0, len(c.QueryParams()))
+	for column := range c.QueryParams() {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	for _, column := range columns {
+		if column == "group_by" || column == "agg" || !allowed[column] {
+			continue
+		}
+		filters = append(filters, column+" = ?")
+		args = append(args, c.QueryParam(column))
+	}
+
+	scope, scopeArgs := h.scope(c, policies.ActionList)
+	criteria, args := policies.Combine(strings.Join(filters, " AND "), args, scope, scopeArgs)
+	result, err := h.svc(c).GroupBy(groupBy, aggregates, criteria, args...)
+	if err != nil {
+		if errors.Is(err, repositories.ErrUnknownAggregate) || errors.Is(err, repositories.ErrUnknownColumn) {
+			return badRequest(err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "Failed to get stats of " + h.Name(),
+		})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// RegisterRoutes adds the CRUD and bulk routes of the handler to g, e.g. e.Group("/users"),
+// plus GET /:id/<relation> for every s2s relation and POST/DELETE /:id/<relation>/:relatedId
+// for the many-to-many ones, and records them in Resources.
+func (h *Handler[T]) RegisterRoutes(g *echo.Group) {
+	routes := []*echo.Route{
+		g.GET("", h.GetAll),
+		g.POST("", h.Create),
+		g.PUT("", h.Update),
+		g.POST("/bulk", h.CreateMany),
+		g.PUT("/bulk", h.UpdateMany),
+		g.DELETE("/bulk", h.DeleteMany),
+		g.POST("/import", h.Import),
+		g.GET("/stats", h.Stats),
+		g.GET("/stream", h.Stream),
+		g.GET("/events", h.Events),
+		g.GET("/live", h.Live),
+		g.GET("/:id", h.GetByID),
+		g.PUT("/:id", h.Update),
+		g.DELETE("/:id", h.DeleteByID),
+		g.GET("/:id/history", h.History),
+	}
+	for _, relation := range h.service.Relations() {
+		routes = append(routes, g.GET("/:id/"+relation.Name, h.GetRelation(relation.Name)))
+		if relation.LinkTable != "" {
+			routes = append(routes,
+				g.POST("/:id/"+relation.Name+"/:relatedId", h.Link(relation.Name)),
+				g.DELETE("/:id/"+relation.Name+"/:relatedId", h.Unlink(relation.Name)),
+			)
+		}
+	}
+	registerResource(&Resource{
+		Name:   h.Name(),
+		Path:   routes[0].Path,
+		Model:  reflect.TypeOf((*T)(nil)).Elem(),
+		Routes: routes,
+	})
+}
+
+// validationErrors builds the 422 body when err comes from validators.Validate.
+func validationErrors(err error) (map[string]interface{}, bool) {
+	var verr validators.ValidationErrors
+	if !errors.As(err, &verr) {
+		return nil, false
+	}
+	return map[string]interface{}{
+		"error":  "Validation failed",
+		"fields": verr,
+	}, true
+}
+
+func splitParam(param string) []string {
+	values := []string{}
+	for _, value := range strings.Split(param, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
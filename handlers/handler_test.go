@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+func TestStream(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+
+	rec := request(e, http.MethodGet, "/notes/stream", "ann")
+	notes := []*Note{}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &notes) != nil || len(notes) != 2 {
+		t.Fatal("expected the notes of ann", rec.Code, rec.Body.String())
+	}
+	if rec := request(e, http.MethodGet, "/notes/stream", "zed"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Error("expected an empty array", rec.Code, rec.Body.String())
+	}
+	if rec := request(e, http.MethodGet, "/notes/stream?format=ndjson", "ann"); strings.Count(rec.Body.String(), "\n") != 2 {
+		t.Error("expected a line per note", rec.Body.String())
+	}
+	// the filters, sort and pagination of GetAll apply
+	rec = request(e, http.MethodGet, "/notes/stream?sort=-text&limit=1", "ann")
+	notes = []*Note{}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &notes) != nil || len(notes) != 1 || notes[0].Text != "a2" {
+		t.Error("expected the last note of ann", rec.Code, rec.Body.String())
+	}
+	rec = request(e, http.MethodGet, "/notes/stream?text=a1", "ann")
+	notes = []*Note{}
+	if json.Unmarshal(rec.Body.Bytes(), &notes) != nil || len(notes) != 1 || notes[0].Text != "a1" {
+		t.Error("expected the filtered note", rec.Body.String())
+	}
+	if rec := request(e, http.MethodGet, "/notes/stream?sort=secret", "ann"); rec.Code != http.StatusBadRequest {
+		t.Error("expected a 400", rec.Code, rec.Body.String())
+	}
+
+	// a failing query is reported before any row is sent
+	if _, err := config.DB.Exec("DROP TABLE notes"); err != nil {
+		t.Fatal(err)
+	}
+	rec = request(e, http.MethodGet, "/notes/stream", "ann")
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "error") {
+		t.Error("expected a 500", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGetAllQuery(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	if _, err := config.DB.Exec("INSERT INTO notes (owner, text, locked) VALUES ('ann', 'a3', 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := func(path string) string {
+		rec := request(e, http.MethodGet, path, "ann")
+		notes := []*Note{}
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &notes) != nil {
+			t.Fatal(path, rec.Code, rec.Body.String())
+		}
+		result := []string{}
+		for _, note := range notes {
+			result = append(result, note.Text)
+		}
+		return strings.Join(result, ",")
+	}
+	for path, expected := range map[string]string{
+		"/notes?sort=-text":                      "a3,a2,a1",
+		"/notes?locked=0&sort=text":              "a1,a3",
+		"/notes?owner=bob":                       "",
+		"/notes?sort=text&limit=2":               "a1,a2",
+		"/notes?sort=text&limit=2&offset=2":      "a3",
+		"/notes?unknown=1&sort=text&format=json": "a1,a2,a3",
+	} {
+		if got := texts(path); got != expected {
+			t.Error(path, "expected", expected, "got", got)
+		}
+	}
+	for _, path := range []string{"/notes?sort=secret", "/notes?limit=-1", "/notes?limit=x", "/notes?offset=2"} {
+		if rec := request(e, http.MethodGet, path, "ann"); rec.Code != http.StatusBadRequest {
+			t.Error(path, "expected a 400", rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	// IdempotencyTable stores the responses of the requests sent with an Idempotency-Key header.
+	IdempotencyTable string = "s2s_idempotency"
+	// IdempotencyWait is how long a duplicate waits for the request in progress with the same key.
+	IdempotencyWait = 10 * time.Second
+	// IdempotencyLease is how long a request in progress holds its key; once it passes, a duplicate
+	// takes the key over and runs the request, e.g. after the process that held it crashed.
+	IdempotencyLease = time.Minute
+)
+
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// CreateIdempotencyTable creates IdempotencyTable if it does not exist.
+func CreateIdempotencyTable() error {
+	_, err := config.DB.Exec("CREATE TABLE IF NOT EXISTS " + IdempotencyTable + " (scope VARCHAR(255) NOT NULL, " +
+		"idem_key VARCHAR(255) NOT NULL, request_hash VARCHAR(64) NOT NULL, status_code INTEGER, content_type VARCHAR(255), " +
+		"body TEXT, owner VARCHAR(32) NOT NULL, created_at TIMESTAMP NOT NULL, locked_until TIMESTAMP NOT NULL, " +
+		"expires_at TIMESTAMP NOT NULL, PRIMARY KEY (scope, idem_key))")
+	return err
+}
+
+// SetIdempotency makes Create and CreateMany honor the Idempotency-Key header: the first response
+// with a status below 500 is stored in IdempotencyTable for ttl and returned to the retries of
+// the same request. Keys are scoped by route and principal; reusing one with another body answers 422.
+func (h *Handler[T]) SetIdempotency(ttl time.Duration) *Handler[T] {
+	h.idempotencyTTL = ttl
+	return h
+}
+
+// idempotencyLocks serializes the requests with the same key within the process;
+// the other processes wait on the row of the first request.
+var idempotencyLocks = struct {
+	sync.Mutex
+	keys map[string]*idempotencyLock
+}{keys: map[string]*idempotencyLock{}}
+
+type idempotencyLock struct {
+	sync.Mutex
+	users int
+}
+
+func lockIdempotency(key string) func() {
+	idempotencyLocks.Lock()
+	lock := idempotencyLocks.keys[key]
+	if lock == nil {
+		lock = &idempotencyLock{}
+		idempotencyLocks.keys[key] = lock
+	}
+	lock.users++
+	idempotencyLocks.Unlock()
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		idempotencyLocks.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(idempotencyLocks.keys, key)
+		}
+		idempotencyLocks.Unlock()
+	}
+}
+
+// idempotencyPurge throttles the purge of the expired keys to once a minute per process.
+var idempotencyPurge = struct {
+	sync.Mutex
+	last time.Time
+}{}
+
+// purgeIdempotency deletes the expired keys of every route, except the requests still in progress.
+func purgeIdempotency(ctx context.Context, now time.Time) {
+	idempotencyPurge.Lock()
+	if now.Sub(idempotencyPurge.last) < time.Minute {
+		idempotencyPurge.Unlock()
+		return
+	}
+	idempotencyPurge.last = now
+	idempotencyPurge.Unlock()
+	_, err := config.DB.ExecContext(ctx, "DELETE FROM "+IdempotencyTable+" WHERE expires_at < ? AND (status_code IS NOT NULL OR locked_until < ?)", now, now)
+	if err != nil {
+		log.Printf("Error al purgar las claves de idempotencia[034-Idempotency]: %v", err)
+	}
+}
+
+type storedResponse struct {
+	hash        string
+	status      sql.NullInt64
+	contentType sql.NullString
+	body        sql.NullString
+}
+
+// idempotent runs next once per Idempotency-Key and replays its stored response to the duplicates.
+func (h *Handler[T]) idempotent(c echo.Context, next echo.HandlerFunc) error {
+	key := c.Request().Header.Get(HeaderIdempotencyKey)
+	if key == "" || h.idempotencyTTL <= 0 {
+		return next(c)
+	}
+	if len(key) > 255 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid " + HeaderIdempotencyKey})
+	}
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid body for " + h.Name()})
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	scope := fmt.Sprintf("%s %s %v", c.Request().Method, c.Path(), h.principal(c))
+	if len(scope) > 255 {
+		sum := sha256.Sum256([]byte(scope))
+		scope = hex.EncodeToString(sum[:])
+	}
+
+	unlock := lockIdempotency(scope + "\n" + key)
+	defer unlock()
+	ctx := c.Request().Context()
+	now := time.Now().UTC()
+	purgeIdempotency(ctx, now)
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create " + h.Name()})
+	}
+	token := hex.EncodeToString(owner)
+
+	deadline := now.Add(IdempotencyWait)
+	for {
+		now := time.Now().UTC()
+		_, err := config.DB.ExecContext(ctx, "INSERT INTO "+IdempotencyTable+" (scope, idem_key, request_hash, owner, created_at, locked_until, expires_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?)", scope, key, hash, token, now, now.Add(IdempotencyLease), now.Add(h.idempotencyTTL))
+		if err == nil {
+			return h.storeResponse(c, next, scope, key, token)
+		}
+		// take over an expired key, or the key of a request whose lease passed
+		n, err := execRows(ctx, "UPDATE "+IdempotencyTable+" SET request_hash = ?, status_code = NULL, content_type = NULL, body = NULL, "+
+			"owner = ?, created_at = ?, locked_until = ?, expires_at = ? WHERE scope = ? AND idem_key = ? AND "+
+			"(expires_at < ? OR (status_code IS NULL AND locked_until < ? AND request_hash = ?))",
+			hash, token, now, now.Add(IdempotencyLease), now.Add(h.idempotencyTTL), scope, key, now, now, hash)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create " + h.Name()})
+		}
+		if n == 1 {
+			return h.storeResponse(c, next, scope, key, token)
+		}
+		stored := storedResponse{}
+		errSelect := config.DB.QueryRowContext(ctx, "SELECT request_hash, status_code, content_type, body FROM "+IdempotencyTable+
+			" WHERE scope = ? AND idem_key = ?", scope, key).Scan(&stored.hash, &stored.status, &stored.contentType, &stored.body)
+		if errSelect == sql.ErrNoRows && time.Now().Before(deadline) {
+			// the request in progress failed and released the key
+			continue
+		}
+		if errSelect != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create " + h.Name()})
+		}
+		if stored.hash != hash {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": HeaderIdempotencyKey + " was used with another request"})
+		}
+		if stored.status.Valid {
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			return c.Blob(int(stored.status.Int64), stored.contentType.String, []byte(stored.body.String))
+		}
+		// another process is running the request
+		if time.Now().After(deadline) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "A request with this " + HeaderIdempotencyKey + " is in progress"})
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// responseRecorder keeps a copy of the body written to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func execRows(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	result, err := config.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// storeResponse runs next and stores its response, or releases the key when it failed. A request
+// that lost its lease to a duplicate leaves the key to it.
+func (h *Handler[T]) storeResponse(c echo.Context, next echo.HandlerFunc, scope string, key string, owner string) error {
+	res := c.Response()
+	recorder := &responseRecorder{ResponseWriter: res.Writer}
+	res.Writer = recorder
+	err := next(c)
+	res.Writer = recorder.ResponseWriter
+
+	if err != nil || !res.Committed || res.Status >= http.StatusInternalServerError {
+		if _, errDelete := config.DB.Exec("DELETE FROM "+IdempotencyTable+" WHERE scope = ? AND idem_key = ? AND owner = ?", scope, key, owner); errDelete != nil {
+			log.Printf("Error al liberar la clave de idempotencia[032-Idempotency]: %v", errDelete)
+		}
+		return err
+	}
+	n, errUpdate := execRows(context.Background(), "UPDATE "+IdempotencyTable+" SET status_code = ?, content_type = ?, body = ?, expires_at = ? "+
+		"WHERE scope = ? AND idem_key = ? AND owner = ?", res.Status, res.Header().Get(echo.HeaderContentType), recorder.body.String(),
+		time.Now().UTC().Add(h.idempotencyTTL), scope, key, owner)
+	if errUpdate != nil {
+		log.Printf("Error al guardar la respuesta idempotente[033-Idempotency]: %v", errUpdate)
+	} else if n == 0 {
+		log.Printf("La clave de idempotencia fue tomada por otra peticion[035-Idempotency]: %s", key)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/labstack/echo/v4"
+)
+
+func TestIdempotency(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	if err := CreateIdempotencyTable(); err != nil {
+		t.Fatal(err)
+	}
+	NewHandler[Note]().SetIdempotency(time.Hour).RegisterRoutes(e.Group("/idempotent"))
+
+	post := func(key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/idempotent", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-User", "ann")
+		req.Header.Set(HeaderIdempotencyKey, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	count := func() int {
+		var n int
+		config.DB.QueryRow("SELECT COUNT(*) FROM notes").Scan(&n)
+		return n
+	}
+
+	first := post("k1", `{"owner":"ann","text":"a3"}`)
+	if first.Code != http.StatusOK || count() != 4 {
+		t.Fatal("expected the note to be created, got", first.Code, first.Body.String())
+	}
+	retry := post("k1", `{"owner":"ann","text":"a3"}`)
+	if retry.Code != http.StatusOK || retry.Body.String() != first.Body.String() || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("expected the stored response, got", retry.Code, retry.Body.String())
+	}
+	if count() != 4 {
+		t.Fatal("expected no duplicate row")
+	}
+	if rec := post("k1", `{"owner":"ann","text":"other"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatal("expected 422 for another body, got", rec.Code)
+	}
+
+	// concurrent duplicates run the create once and share its response
+	config.DB.Exec("DELETE FROM notes WHERE text = 'a3'")
+	responses := make([]*httptest.ResponseRecorder, 5)
+	var wg sync.WaitGroup
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = post("k2", `{"owner":"ann","text":"a4"}`)
+		}(i)
+	}
+	wg.Wait()
+	for _, rec := range responses {
+		if rec.Code != http.StatusOK || rec.Body.String() != responses[0].Body.String() {
+			t.Fatal("expected the same response for every duplicate, got", rec.Code, rec.Body.String())
+		}
+	}
+	if count() != 4 {
+		t.Fatal("expected a single row for the concurrent duplicates, got", count())
+	}
+
+	// a stale request in progress, e.g. of a crashed process, is taken over once its lease passes
+	config.DB.Exec("DELETE FROM notes WHERE text = 'a4'")
+	body := `{"owner":"ann","text":"a5"}`
+	sum := sha256.Sum256([]byte(body))
+	past := time.Now().UTC().Add(-time.Minute)
+	_, err := config.DB.Exec("INSERT INTO "+IdempotencyTable+" (scope, idem_key, request_hash, owner, created_at, locked_until, expires_at) VALUES "+
+		"('POST /idempotent ann', 'k3', ?, 'crashed', ?, ?, ?), ('POST /other bob', 'old', '', 'done', ?, ?, ?)",
+		hex.EncodeToString(sum[:]), past, past, past.Add(time.Hour), past, past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idempotencyPurge.last = time.Time{}
+	if rec := post("k3", body); rec.Code != http.StatusOK || count() != 4 {
+		t.Fatal("expected the stale key to be taken over, got", rec.Code, rec.Body.String())
+	}
+	var keys int
+	config.DB.QueryRow("SELECT COUNT(*) FROM " + IdempotencyTable + " WHERE idem_key = 'old'").Scan(&keys)
+	if keys != 0 {
+		t.Fatal("expected the expired keys of other routes to be purged")
+	}
+}
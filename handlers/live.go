@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/notifier"
+	"github.com/arturoeanton/go-struct2serve/policies"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/websocket"
+)
+
+// LiveMessage is a message of the GET /live WebSocket.
+//
+// The client sends {"type": "subscribe", "id": "q1", "filter": {"group_id": "3"}} and {"type": "unsubscribe", "id": "q1"}.
+// The server answers with a "snapshot" holding the matching rows, then sends a "diff" with the rows
+// added to, updated in or removed from the result every time a write affects it, and "error" on failures.
+type LiveMessage struct {
+	Type    string            `json:"type"`
+	ID      string            `json:"id,omitempty"`
+	Filter  map[string]string `json:"filter,omitempty"`
+	Rows    interface{}       `json:"rows,omitempty"`
+	Added   []interface{}     `json:"added,omitempty"`
+	Updated []interface{}     `json:"updated,omitempty"`
+	Removed []interface{}     `json:"removed,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// liveQuery is a subscription of a connection and the ids of its current result.
+type liveQuery struct {
+	criteria string
+	args     []interface{}
+	ids      map[string]bool
+}
+
+// Live answers GET /live, a WebSocket where clients subscribe to the rows matching equality filters
+// on readable columns (as in Stats) and receive the changes of the result made through the repositories.
+// Changed rows are queried again when the change is received, so diffs carry the current row.
+func (h *Handler[T]) Live(c echo.Context) error {
+	if err := h.authorize(c, policies.ActionList, nil); err != nil {
+		return forbidden(c)
+	}
+	websocket.Server{
+		Handshake: h.checkOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			h.live(c, ws)
+		},
+	}.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// SetAllowedOrigins sets the origins, e.g. "https://app.example.com" or "*", allowed to open GET /live
+// from a browser besides the host of the request.
+func (h *Handler[T]) SetAllowedOrigins(origins ...string) *Handler[T] {
+	h.liveOrigins = origins
+	return h
+}
+
+// checkOrigin rejects the WebSockets opened by pages of other sites, which would be sent the cookies
+// of the user. Clients that are not browsers send no Origin and are accepted.
+func (h *Handler[T]) checkOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(parsed.Host, req.Host) {
+		return nil
+	}
+	for _, allowed := range h.liveOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin not allowed: %s", origin)
+}
+
+func (h *Handler[T]) live(c echo.Context, ws *websocket.Conn) {
+	changes, unsubscribe := notifier.Subscribe(repositories.TableName(reflect.TypeOf((*T)(nil)).Elem()))
+	defer unsubscribe()
+
+	incoming := make(chan LiveMessage)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(incoming)
+		for {
+			var message LiveMessage
+			if err := websocket.JSON.Receive(ws, &message); err != nil {
+				return
+			}
+			select {
+			case incoming <- message:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	queries := map[string]*liveQuery{}
+	send := func(message LiveMessage) bool {
+		if err := websocket.JSON.Send(ws, message); err != nil {
+			log.Printf("Error al enviar a %s[028-Live]: %v", h.Name(), err)
+			return false
+		}
+		return true
+	}
+	for {
+		select {
+		case message, ok := <-incoming:
+			if !ok {
+				return
+			}
+			switch message.Type {
+			case "subscribe":
+				query, rows, err := h.subscribe(c, message.Filter)
+				if err != nil {
+					if !send(LiveMessage{Type: "error", ID: message.ID, Error: err.Error()}) {
+						return
+					}
+					continue
+				}
+				queries[message.ID] = query
+				if !send(LiveMessage{Type: "snapshot", ID: message.ID, Rows: rows}) {
+					return
+				}
+			case "unsubscribe":
+				delete(queries, message.ID)
+			default:
+				if !send(LiveMessage{Type: "error", ID: message.ID, Error: "unknown message type " + message.Type}) {
+					return
+				}
+			}
+		case change, ok := <-changes:
+			if !ok {
+				return
+			}
+			for _, id := range sortedKeys(queries) {
+				diff, changed := h.liveDiff(c, queries[id], change)
+				if changed {
+					diff.ID = id
+					if !send(diff) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// subscribe validates filter and returns the query with its current rows.
+func (h *Handler[T]) subscribe(c echo.Context, filter map[string]string) (*liveQuery, []*T, error) {
+	readable := map[string]bool{}
+	for _, column := range h.readableColumns(c) {
+		readable[column] = true
+	}
+	columns := make([]string, 0, len(filter))
+	for column := range filter {
+		if !readable[column] {
+			return nil, nil, fmt.Errorf("column not allowed: %s", column)
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	conditions := make([]string, 0, len(columns))
+	args := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		conditions = append(conditions, column+" = ?")
+		args = append(args, filter[column])
+	}
+	scope, scopeArgs := h.scope(c, policies.ActionList)
+	criteria, args := policies.Combine(strings.Join(conditions, " AND "), args, scope, scopeArgs)
+
+	query := &liveQuery{criteria: criteria, args: args, ids: map[string]bool{}}
+	var items []*T
+	var err error
+	if criteria == "" {
+		items, err = h.svc(c).GetAll()
+	} else {
+		items, err = h.svc(c).GetByCriteria(criteria, args...)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	// rows are authorized like the diffs, so the snapshot holds no row a later diff would hide
+	rows := make([]*T, 0, len(items))
+	for _, item := range items {
+		if h.authorize(c, policies.ActionGet, item) != nil {
+			continue
+		}
+		query.ids[fmt.Sprint(itemID(item))] = true
+		rows = append(rows, item)
+	}
+	return query, h.redact(c, rows).([]*T), nil
+}
+
+// liveDiff checks whether the row of change belongs to the result of query and returns the diff.
+func (h *Handler[T]) liveDiff(c echo.Context, query *liveQuery, change repositories.Change) (LiveMessage, bool) {
+	diff := LiveMessage{Type: "diff"}
+	key := fmt.Sprint(change.ID)
+	var item *T
+	if change.Action != repositories.ChangeDelete {
+		criteria, args := policies.Combine("id = ?", []interface{}{change.ID}, query.criteria, query.args)
+		items, err := h.svc(c).GetByCriteria(criteria, args...)
+		if err != nil {
+			return diff, false
+		}
+		if len(items) > 0 && h.authorize(c, policies.ActionGet, items[0]) == nil {
+			item = items[0]
+		}
+	}
+	switch {
+	case item != nil && query.ids[key]:
+		diff.Updated = []interface{}{h.redact(c, item)}
+	case item != nil:
+		query.ids[key] = true
+		diff.Added = []interface{}{h.redact(c, item)}
+	case query.ids[key]:
+		delete(query.ids, key)
+		diff.Removed = []interface{}{change.ID}
+	default:
+		return diff, false
+	}
+	return diff, true
+}
+
+func sortedKeys(queries map[string]*liveQuery) []string {
+	keys := make([]string, 0, len(queries))
+	for key := range queries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/policies"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"golang.org/x/net/websocket"
+)
+
+func TestLive(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	wsConfig, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/notes/live", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsConfig.Header.Set("X-User", "ann")
+	ws, err := websocket.DialConfig(wsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	receive := func() LiveMessage {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var message LiveMessage
+		if err := websocket.JSON.Receive(ws, &message); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+
+	websocket.JSON.Send(ws, LiveMessage{Type: "subscribe", ID: "bad", Filter: map[string]string{"secret": "x"}})
+	if message := receive(); message.Type != "error" || message.ID != "bad" {
+		t.Fatal("expected an error for an unknown column, got", message)
+	}
+
+	websocket.JSON.Send(ws, LiveMessage{Type: "subscribe", ID: "q1", Filter: map[string]string{"locked": "0"}})
+	message := receive()
+	if message.Type != "snapshot" || message.ID != "q1" || len(message.Rows.([]interface{})) != 1 {
+		t.Fatal("expected ann's unlocked note, got", message)
+	}
+
+	repo := repositories.NewRepository[Note]()
+	// bob's note and ann's locked note are not in the result
+	repo.Create(&Note{ID: 4, Owner: "bob", Text: "b2"})
+	repo.Update(&Note{ID: 2, Owner: "ann", Text: "a2 edited", Locked: true})
+	repo.Create(&Note{ID: 5, Owner: "ann", Text: "a3"})
+	message = receive()
+	if len(message.Added) != 1 || message.Added[0].(map[string]interface{})["text"] != "a3" {
+		t.Fatal("expected a3 to be added, got", message)
+	}
+	repo.Update(&Note{ID: 1, Owner: "ann", Text: "a1 edited"})
+	message = receive()
+	if len(message.Updated) != 1 || message.Updated[0].(map[string]interface{})["text"] != "a1 edited" {
+		t.Fatal("expected a1 to be updated, got", message)
+	}
+	repo.Update(&Note{ID: 5, Owner: "ann", Text: "a3", Locked: true})
+	message = receive()
+	if len(message.Removed) != 1 || fmt.Sprint(message.Removed[0]) != "5" {
+		t.Fatal("expected a3 to be removed once locked, got", message)
+	}
+}
+
+func TestLiveOriginAndSnapshot(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	// Authorize alone hides the locked notes, without Scope
+	NewHandler[Note]().SetPolicy(policies.Funcs[Note]{
+		AuthorizeFunc: func(principal interface{}, action policies.Action, item *Note) error {
+			if item != nil && item.Locked {
+				return policies.ErrForbidden
+			}
+			return nil
+		},
+	}).SetAllowedOrigins("https://app.example.com").RegisterRoutes(e.Group("/unlocked"))
+	server := httptest.NewServer(e)
+	defer server.Close()
+	dial := func(origin string) (*websocket.Conn, error) {
+		wsConfig, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http")+"/unlocked/live", origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return websocket.DialConfig(wsConfig)
+	}
+
+	if _, err := dial("https://evil.example.com"); err == nil {
+		t.Fatal("expected another site to be rejected")
+	}
+	ws, err := dial("https://app.example.com")
+	if err != nil {
+		t.Fatal("expected the allowed origin to connect", err)
+	}
+	defer ws.Close()
+	websocket.JSON.Send(ws, LiveMessage{Type: "subscribe", ID: "q1"})
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message LiveMessage
+	if err := websocket.JSON.Receive(ws, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != "snapshot" || len(message.Rows.([]interface{})) != 2 {
+		t.Fatal("expected the 2 unlocked notes, got", message)
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/policies"
+	"github.com/labstack/echo/v4"
+)
+
+// PrincipalKey is the echo context key read by the default principal function,
+// e.g. an authentication middleware does c.Set(handlers.PrincipalKey, user).
+var PrincipalKey = "principal"
+
+// SetPolicy makes the handler evaluate policy on every action.
+func (h *Handler[T]) SetPolicy(policy policies.Policy[T]) *Handler[T] {
+	h.policy = policy
+	return h
+}
+
+// SetPrincipalFunc replaces how the principal is read from the request.
+func (h *Handler[T]) SetPrincipalFunc(fn func(c echo.Context) interface{}) *Handler[T] {
+	h.principalFunc = fn
+	return h
+}
+
+func (h *Handler[T]) principal(c echo.Context) interface{} {
+	if h.principalFunc != nil {
+		return h.principalFunc(c)
+	}
+	return c.Get(PrincipalKey)
+}
+
+func (h *Handler[T]) authorize(c echo.Context, action policies.Action, item *T) error {
+	if h.policy == nil {
+		return nil
+	}
+	return h.policy.Authorize(h.principal(c), action, item)
+}
+
+func (h *Handler[T]) scope(c echo.Context, action policies.Action) (string, []interface{}) {
+	if h.policy == nil {
+		return "", nil
+	}
+	return h.policy.Scope(h.principal(c), action)
+}
+
+// findByID returns the row with id if it is visible under the scope of action, nil otherwise.
+func (h *Handler[T]) findByID(c echo.Context, action policies.Action, id interface{}) (*T, error) {
+	scope, scopeArgs := h.scope(c, action)
+	if scope == "" {
+		return h.svc(c).GetByID(id)
+	}
+	criteria, args := policies.Combine("id = ?", []interface{}{id}, scope, scopeArgs)
+	items, err := h.svc(c).GetByCriteria(criteria, args...)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+// visible reports whether every id is visible under the scope of action, counting the rows
+// in batches that fit in config.MaxParams() with the scope arguments.
+func (h *Handler[T]) visible(c echo.Context, action policies.Action, ids ...interface{}) (bool, error) {
+	scope, scopeArgs := h.scope(c, action)
+	if scope == "" || len(ids) == 0 {
+		return true, nil
+	}
+	unique := uniqueIDs(ids)
+	batchSize := config.MaxParams() - len(scopeArgs) - 1
+	for start := 0; start < len(unique); start += batchSize {
+		end := start + batchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		criteria, args := policies.Combine("id IN ("+placeholders(end-start)+")", unique[start:end], scope, scopeArgs)
+		count, err := h.svc(c).Count(criteria, args...)
+		if err != nil || count != int64(end-start) {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// stored returns the stored rows with ids keyed by fmt.Sprint of their id, read in batches and
+// without projection, so fields hidden to the caller are preserved.
+func (h *Handler[T]) stored(c echo.Context, ids []interface{}) (map[string]*T, error) {
+	unique := uniqueIDs(ids)
+	service := h.service.WithContext(c.Request().Context())
+	byID := make(map[string]*T, len(unique))
+	batchSize := config.MaxParams() - 1
+	for start := 0; start < len(unique); start += batchSize {
+		end := start + batchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+		items, err := service.GetByCriteria("id IN ("+placeholders(end-start)+")", unique[start:end]...)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			byID[fmt.Sprint(itemID(item))] = item
+		}
+	}
+	return byID, nil
+}
+
+// authorizeStored runs Authorize of action on the stored rows with ids; ids without a row are skipped.
+func (h *Handler[T]) authorizeStored(c echo.Context, action policies.Action, stored map[string]*T, ids []interface{}) error {
+	if h.policy == nil {
+		return nil
+	}
+	for _, id := range ids {
+		if existing := stored[fmt.Sprint(id)]; existing != nil {
+			if err := h.authorize(c, action, existing); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func uniqueIDs(ids []interface{}) []interface{} {
+	unique := []interface{}{}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		key := fmt.Sprint(id)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// itemID returns the value of the id field of item (s2s_id:"true", db:"id" or ID).
+func itemID[T any](item *T) interface{} {
+	itemValue := reflect.ValueOf(item).Elem()
+	name := idField(itemValue.Type())
+	if name == "" {
+		return nil
+	}
+	value := itemValue.FieldByName(name)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	return value.Interface()
+}
+
+var errIDMismatch = errors.New("id of the body does not match the path")
+
+// setItemID sets the id field of item to the path id, failing when the body carries a different id.
+func setItemID[T any](item *T, id string) error {
+	itemValue := reflect.ValueOf(item).Elem()
+	name := idField(itemValue.Type())
+	if name == "" {
+		return nil
+	}
+	value := itemValue.FieldByName(name)
+	if !value.IsZero() {
+		if fmt.Sprint(itemID(item)) != id {
+			return errIDMismatch
+		}
+		return nil
+	}
+	target := value
+	if value.Kind() == reflect.Ptr {
+		target = reflect.New(value.Type().Elem()).Elem()
+	}
+	switch target.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || target.OverflowInt(n) {
+			return fmt.Errorf("invalid id %q", id)
+		}
+		target.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || target.OverflowUint(n) {
+			return fmt.Errorf("invalid id %q", id)
+		}
+		target.SetUint(n)
+	case reflect.String:
+		target.SetString(id)
+	default:
+		return fmt.Errorf("unsupported id type %s", target.Type())
+	}
+	if value.Kind() == reflect.Ptr {
+		value.Set(target.Addr())
+	}
+	return nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func forbidden(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error": "Forbidden",
+	})
+}
+
+func notFound(c echo.Context) error {
+	return c.JSON(http.StatusNotFound, map[string]string{
+		"error": "Not found",
+	})
+}
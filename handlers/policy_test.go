@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/policies"
+	"github.com/labstack/echo/v4"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Note struct {
+	ID     int    `json:"id" db:"id" s2s_table_name:"notes"`
+	Owner  string `json:"owner" db:"owner"`
+	Text   string `json:"text" db:"text"`
+	Locked bool   `json:"locked" db:"locked"`
+}
+
+func mockNotes(t *testing.T) *echo.Echo {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "notes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	_, err = db.Exec(`CREATE TABLE notes (id INTEGER PRIMARY KEY, owner TEXT, text TEXT, locked BOOLEAN);
+		INSERT INTO notes (owner, text, locked) VALUES ('ann', 'a1', 0), ('ann', 'a2', 1), ('bob', 'b1', 0);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(PrincipalKey, c.Request().Header.Get("X-User"))
+			return next(c)
+		}
+	})
+	NewHandler[Note]().SetPolicy(policies.Funcs[Note]{
+		AuthorizeFunc: func(principal interface{}, action policies.Action, item *Note) error {
+			if principal == "" {
+				return policies.ErrForbidden
+			}
+			if action == policies.ActionDelete && item != nil && item.Locked {
+				return errors.New("locked")
+			}
+			return nil
+		},
+		ScopeFunc: func(principal interface{}, action policies.Action) (string, []interface{}) {
+			return "owner = ?", []interface{}{principal}
+		},
+	}).RegisterRoutes(e.Group("/notes"))
+	return e
+}
+
+func request(e *echo.Echo, method string, path string, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPolicy(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+
+	if rec := request(e, http.MethodGet, "/notes", ""); rec.Code != http.StatusForbidden {
+		t.Error("anonymous list must be forbidden", rec.Code)
+	}
+
+	rec := request(e, http.MethodGet, "/notes", "ann")
+	notes := []*Note{}
+	json.Unmarshal(rec.Body.Bytes(), &notes)
+	if len(notes) != 2 {
+		t.Error("ann must see 2 notes, got", len(notes))
+	}
+
+	rec = request(e, http.MethodGet, "/notes/3", "ann")
+	if rec.Body.String() != "null\n" {
+		t.Error("ann must not see bob's note", rec.Body.String())
+	}
+	if rec := request(e, http.MethodDelete, "/notes/3", "ann"); rec.Code != http.StatusNotFound {
+		t.Error("ann must not delete bob's note", rec.Code)
+	}
+	if rec := request(e, http.MethodDelete, "/notes/2", "ann"); rec.Code != http.StatusForbidden {
+		t.Error("locked notes can not be deleted", rec.Code)
+	}
+	if rec := request(e, http.MethodDelete, "/notes/1", "ann"); rec.Code != http.StatusOK {
+		t.Error("ann can delete her note", rec.Code)
+	}
+}
+
+func TestPolicyUpdate(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	send := func(path string, user string, body string) int {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	text := func(id int) string {
+		var text string
+		config.DB.QueryRow("SELECT text FROM notes WHERE id = ?", id).Scan(&text)
+		return text
+	}
+
+	if code := send("/notes/1", "ann", `{"id": 3, "owner": "ann", "text": "stolen"}`); code != http.StatusBadRequest || text(3) != "b1" {
+		t.Error("a body id different from the path must be rejected", code, text(3))
+	}
+	if code := send("/notes/3", "ann", `{"owner": "ann", "text": "stolen"}`); code != http.StatusNotFound || text(3) != "b1" {
+		t.Error("ann must not update bob's note", code, text(3))
+	}
+	if code := send("/notes", "ann", `{"id": 3, "owner": "ann", "text": "stolen"}`); code != http.StatusNotFound || text(3) != "b1" {
+		t.Error("ann must not update bob's note by body id", code, text(3))
+	}
+	if code := send("/notes/x", "ann", `{"text": "a"}`); code != http.StatusBadRequest {
+		t.Error("expected an invalid id", code)
+	}
+	if code := send("/notes/1", "ann", `{"owner": "ann", "text": "edited"}`); code != http.StatusNoContent || text(1) != "edited" {
+		t.Error("ann can update her note", code, text(1))
+	}
+	if code := send("/notes/1", "ann", `{"id": 1, "owner": "ann", "text": "again"}`); code != http.StatusNoContent || text(1) != "again" {
+		t.Error("a matching body id is accepted", code, text(1))
+	}
+}
+
+func TestPolicyBulk(t *testing.T) {
+	e := mockNotes(t)
+	defer config.DB.Close()
+	// an owner check without Scope: the stored rows must be authorized, not only the body
+	NewHandler[Note]().SetPolicy(policies.Funcs[Note]{
+		AuthorizeFunc: func(principal interface{}, action policies.Action, item *Note) error {
+			if item != nil && item.Owner != principal {
+				return policies.ErrForbidden
+			}
+			return nil
+		},
+	}).RegisterRoutes(e.Group("/owned"))
+	send := func(method string, user string, body string) int {
+		req := httptest.NewRequest(method, "/owned/bulk", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	text := func(id int) string {
+		var text string
+		config.DB.QueryRow("SELECT text FROM notes WHERE id = ?", id).Scan(&text)
+		return text
+	}
+
+	if code := send(http.MethodPut, "ann", `[{"id": 1, "owner": "ann", "text": "mine"}, {"id": 3, "owner": "ann", "text": "stolen"}]`); code != http.StatusForbidden || text(3) != "b1" || text(1) != "a1" {
+		t.Error("ann must not update bob's note in bulk", code, text(3))
+	}
+	if code := send(http.MethodDelete, "ann", `[1, 3]`); code != http.StatusForbidden || text(3) != "b1" {
+		t.Error("ann must not delete bob's note in bulk", code)
+	}
+	if code := send(http.MethodPut, "ann", `[{"id": 1, "owner": "ann", "text": "mine"}]`); code != http.StatusNoContent || text(1) != "mine" {
+		t.Error("ann can update her notes in bulk", code, text(1))
+	}
+	if code := send(http.MethodDelete, "ann", `[1]`); code != http.StatusOK || text(1) != "" {
+		t.Error("ann can delete her notes in bulk", code)
+	}
+
+	// more ids than bind parameters, even the 32766 of this SQLite build, are checked in batches
+	ids := make([]string, 33000)
+	for i := range ids {
+		ids[i] = fmt.Sprint(i + 100)
+	}
+	_, err := config.DB.Exec(`WITH RECURSIVE n(i) AS (SELECT 100 UNION ALL SELECT i + 1 FROM n WHERE i < ?)
+		INSERT INTO notes (id, owner, text, locked) SELECT i, 'bob', 'many', 0 FROM n`, len(ids)+99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodDelete, "/notes/bulk", strings.NewReader("["+strings.Join(ids, ",")+"]"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("X-User", "bob")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	var left int
+	config.DB.QueryRow("SELECT COUNT(*) FROM notes WHERE text = 'many'").Scan(&left)
+	if rec.Code != http.StatusOK || left != 0 {
+		t.Error("expected bob to delete all his notes, got", rec.Code, left)
+	}
+}
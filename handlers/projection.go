@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/labstack/echo/v4"
+)
+
+// S2S_ACCESS marks how a field is exposed by the handler:
+// read_only fields are returned but ignored on bind, write_only fields (e.g. password hashes)
+// are accepted on bind but never returned and hidden fields are neither read nor written.
+var S2S_ACCESS string = "s2s_access"
+
+const (
+	AccessReadOnly  = "read_only"
+	AccessWriteOnly = "write_only"
+	AccessHidden    = "hidden"
+)
+
+// SetRolesFunc makes the handler project every query and response on the roles returned by fn,
+// so fields tagged with s2s_roles are only selected, returned and written for those roles.
+func (h *Handler[T]) SetRolesFunc(fn func(c echo.Context) []string) *Handler[T] {
+	h.rolesFunc = fn
+	return h
+}
+
+func (h *Handler[T]) roles(c echo.Context) ([]string, bool) {
+	if h.rolesFunc == nil {
+		return nil, false
+	}
+	return h.rolesFunc(c), true
+}
+
+// readable reports whether field may be returned to the caller.
+func (h *Handler[T]) readable(c echo.Context, field reflect.StructField) bool {
+	access := field.Tag.Get(S2S_ACCESS)
+	if access == AccessWriteOnly || access == AccessHidden {
+		return false
+	}
+	roles, active := h.roles(c)
+	return !active || repositories.CanSee(field, roles)
+}
+
+// readableColumns returns the db columns of T the caller may read.
+func (h *Handler[T]) readableColumns(c echo.Context) []string {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	columns := []string{}
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if column := field.Tag.Get("db"); column != "" && h.readable(c, field) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// writable reports whether field may be set from the request body.
+func (h *Handler[T]) writable(c echo.Context, field reflect.StructField) bool {
+	access := field.Tag.Get(S2S_ACCESS)
+	if access == AccessReadOnly || access == AccessHidden {
+		return false
+	}
+	roles, active := h.roles(c)
+	return !active || repositories.CanSee(field, roles)
+}
+
+// protects reports whether T has fields that the client can not write.
+func (h *Handler[T]) protects(c echo.Context) bool {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < itemType.NumField(); i++ {
+		if !h.writable(c, itemType.Field(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeCreate zeroes the fields of a bound item the client can not write, including its id.
+func (h *Handler[T]) sanitizeCreate(c echo.Context, item *T) {
+	itemValue := reflect.ValueOf(item).Elem()
+	itemType := itemValue.Type()
+	idName := idField(itemType)
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Name == idName || !h.writable(c, field) {
+			itemValue.Field(i).Set(reflect.Zero(field.Type))
+		}
+	}
+}
+
+// mergeUpdate copies into a bound item the fields the client can not write from the stored row,
+// and keeps stored write only fields the client did not send. Without stored row they are zeroed.
+func (h *Handler[T]) mergeUpdate(c echo.Context, item *T, existing *T) {
+	itemValue := reflect.ValueOf(item).Elem()
+	itemType := itemValue.Type()
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		value := itemValue.Field(i)
+		keep := !h.writable(c, field) || (field.Tag.Get(S2S_ACCESS) == AccessWriteOnly && value.IsZero())
+		if !keep {
+			continue
+		}
+		if existing == nil {
+			value.Set(reflect.Zero(field.Type))
+			continue
+		}
+		value.Set(reflect.ValueOf(existing).Elem().Field(i))
+	}
+}
+
+// merges reports whether updates must be merged with the stored rows.
+func (h *Handler[T]) merges(c echo.Context) bool {
+	return h.protects(c) || hasWriteOnly[T]()
+}
+
+// mergeUpdates applies mergeUpdate to items loading the stored rows, see stored.
+func (h *Handler[T]) mergeUpdates(c echo.Context, items ...*T) error {
+	if !h.merges(c) {
+		return nil
+	}
+	ids := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, itemID(item))
+	}
+	byID, err := h.stored(c, ids)
+	if err != nil {
+		return err
+	}
+	h.mergeStored(c, byID, items)
+	return nil
+}
+
+func (h *Handler[T]) mergeStored(c echo.Context, byID map[string]*T, items []*T) {
+	for _, item := range items {
+		h.mergeUpdate(c, item, byID[fmt.Sprint(itemID(item))])
+	}
+}
+
+// redact zeroes, in place, every field of v the caller can not read, following pointers,
+// slices and nested structs so relations are redacted too.
+func (h *Handler[T]) redact(c echo.Context, v interface{}) interface{} {
+	roles, active := h.roles(c)
+	redactValue(reflect.ValueOf(v), roles, active)
+	return v
+}
+
+func redactValue(v reflect.Value, roles []string, active bool) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			redactValue(v.Elem(), roles, active)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			redactValue(v.Index(i), roles, active)
+		}
+	case reflect.Struct:
+		itemType := v.Type()
+		for i := 0; i < itemType.NumField(); i++ {
+			field := itemType.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			access := field.Tag.Get(S2S_ACCESS)
+			if access == AccessWriteOnly || access == AccessHidden || (active && !repositories.CanSee(field, roles)) {
+				if v.Field(i).CanSet() {
+					v.Field(i).Set(reflect.Zero(field.Type))
+				}
+				continue
+			}
+			redactValue(v.Field(i), roles, active)
+		}
+	}
+}
+
+func hasWriteOnly[T any]() bool {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < itemType.NumField(); i++ {
+		if itemType.Field(i).Tag.Get(S2S_ACCESS) == AccessWriteOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// idField returns the name of the id field of itemType (s2s_id:"true", db:"id" or ID).
+func idField(itemType reflect.Type) string {
+	name := ""
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Tag.Get("s2s_id") == "true" {
+			return field.Name
+		}
+		if name == "" && (field.Tag.Get("db") == "id" || field.Name == "ID") {
+			name = field.Name
+		}
+	}
+	return name
+}
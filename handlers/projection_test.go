@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/labstack/echo/v4"
+)
+
+type Account struct {
+	ID       int    `json:"id" db:"id" s2s_table_name:"accounts"`
+	Name     string `json:"name" db:"name"`
+	Password string `json:"password" db:"password" s2s_access:"write_only"`
+	Email    string `json:"email" db:"email" s2s_roles:"admin"`
+	Created  string `json:"created" db:"created" s2s_access:"read_only"`
+}
+
+func TestProjection(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	defer db.Close()
+	_, err = db.Exec(`CREATE TABLE accounts (id INTEGER PRIMARY KEY, name TEXT, password TEXT, email TEXT, created TEXT);
+		INSERT INTO accounts (name, password, email, created) VALUES ('ann', 'hash', 'ann@example.com', '2024-01-01');`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	NewHandler[Account]().SetRolesFunc(func(c echo.Context) []string {
+		return strings.Split(c.Request().Header.Get("X-Role"), ",")
+	}).RegisterRoutes(e.Group("/accounts"))
+	send := func(method string, path string, role string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("X-Role", role)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	account := Account{}
+	json.Unmarshal(send(http.MethodGet, "/accounts/1", "user", "").Body.Bytes(), &account)
+	if account.Name != "ann" || account.Password != "" || account.Email != "" || account.Created != "2024-01-01" {
+		t.Error("user must see name and created only", account)
+	}
+	account = Account{}
+	json.Unmarshal(send(http.MethodGet, "/accounts/1", "admin", "").Body.Bytes(), &account)
+	if account.Email != "ann@example.com" || account.Password != "" {
+		t.Error("admin must see email but not password", account)
+	}
+
+	rec := send(http.MethodPost, "/accounts", "user", `{"id": 50, "name": "bob", "password": "secret", "email": "bob@example.com", "created": "forged"}`)
+	if rec.Code != http.StatusOK || rec.Body.String() == "50\n" {
+		t.Fatal("client ids must be ignored", rec.Code, rec.Body.String())
+	}
+	var password, email, created sql.NullString
+	db.QueryRow("SELECT password, email, created FROM accounts WHERE name = 'bob'").Scan(&password, &email, &created)
+	if password.String != "secret" || email.String != "" || created.String != "" {
+		t.Error("only writable fields must be stored", password, email, created)
+	}
+
+	if rec := send(http.MethodPut, "/accounts/1", "user", `{"id": 1, "name": "ann2", "email": "x@example.com", "created": "forged"}`); rec.Code != http.StatusNoContent {
+		t.Fatal("update failed", rec.Code)
+	}
+	var name string
+	db.QueryRow("SELECT name, password, email, created FROM accounts WHERE id = 1").Scan(&name, &password, &email, &created)
+	if name != "ann2" || password.String != "hash" || email.String != "ann@example.com" || created.String != "2024-01-01" {
+		t.Error("protected fields must keep their stored values", name, password, email, created)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Resource describes a handler registered with RegisterRoutes.
+type Resource struct {
+	Name   string
+	Path   string
+	Model  reflect.Type
+	Routes []*echo.Route
+}
+
+var (
+	resourcesMutex sync.RWMutex
+	resources      []*Resource
+)
+
+// Resources returns the resources registered so far on e, in registration order.
+// A resource belongs to e when its routes are in one of the routers of e.
+func Resources(e *echo.Echo) []*Resource {
+	routes := map[*echo.Route]bool{}
+	for _, route := range e.Routes() {
+		routes[route] = true
+	}
+	for _, router := range e.Routers() {
+		for _, route := range router.Routes() {
+			routes[route] = true
+		}
+	}
+
+	resourcesMutex.RLock()
+	defer resourcesMutex.RUnlock()
+	registered := []*Resource{}
+	for _, resource := range resources {
+		if len(resource.Routes) > 0 && routes[resource.Routes[0]] {
+			registered = append(registered, resource)
+		}
+	}
+	return registered
+}
+
+func registerResource(resource *Resource) {
+	resourcesMutex.Lock()
+	defer resourcesMutex.Unlock()
+	resources = append(resources, resource)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/arturoeanton/go-struct2serve/services"
+	"github.com/labstack/echo/v4"
+)
+
+// TenantMiddleware stores the tenant returned by fn in the request context, so every handler
+// limits its queries to that tenant. Requests without tenant (fn returns nil or "") are rejected.
+func TenantMiddleware(fn func(c echo.Context) interface{}) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tenant := fn(c)
+			if tenant == nil || tenant == "" {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "Missing tenant",
+				})
+			}
+			ctx := repositories.WithTenant(c.Request().Context(), tenant)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// svc returns the service bound to the request context and, with SetRolesFunc, to the roles of the caller.
+// The principal is the actor of the writes unless the context already has one.
+func (h *Handler[T]) svc(c echo.Context) services.IService[T] {
+	ctx := c.Request().Context()
+	if principal := h.principal(c); principal != nil && repositories.ActorFromContext(ctx) == nil {
+		ctx = repositories.WithActor(ctx, principal)
+	}
+	if roles, ok := h.roles(c); ok {
+		ctx = repositories.WithRoles(ctx, roles...)
+	}
+	return h.service.WithContext(ctx)
+}
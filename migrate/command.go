@@ -0,0 +1,131 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+const usage = `usage: migrate [flags] up | down [steps] | redo | status | unlock | create <name>`
+
+// Dialect returns the dialect of a database/sql driver name.
+func Dialect(driver string) string {
+	switch driver {
+	case "postgres", "pgx":
+		return config.DialectPostgres
+	case "mysql":
+		return config.DialectMySQL
+	}
+	return config.DialectSQLite
+}
+
+var nameRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// Command runs the migrate command line: the flags -driver, -dsn, -dir and -dialect, then the action.
+// The driver must be imported by the program, e.g. _ "github.com/lib/pq".
+func Command(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	driver := flags.String("driver", "sqlite3", "database/sql driver")
+	dsn := flags.String("dsn", os.Getenv("DATABASE_URL"), "data source name, $DATABASE_URL by default")
+	dir := flags.String("dir", "migrations", "directory of the migration files")
+	dialect := flags.String("dialect", "", "sqlite3, postgres or mysql, derived from the driver by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New(usage)
+	}
+	action := flags.Arg(0)
+
+	if action == "create" {
+		if flags.NArg() < 2 {
+			return errors.New(usage)
+		}
+		name := strings.Trim(nameRegex.ReplaceAllString(strings.ToLower(strings.Join(flags.Args()[1:], "_")), "_"), "_")
+		base := filepath.Join(*dir, time.Now().UTC().Format("20060102150405")+"_"+name)
+		if err := os.MkdirAll(*dir, 0o755); err != nil {
+			return err
+		}
+		for _, file := range []string{base + ".up.sql", base + ".down.sql"} {
+			if err := os.WriteFile(file, nil, 0o644); err != nil {
+				return err
+			}
+			fmt.Fprintln(out, "created", file)
+		}
+		return nil
+	}
+
+	if *dsn == "" {
+		return errors.New("-dsn is required")
+	}
+	if *dialect == "" {
+		*dialect = Dialect(*driver)
+	}
+	db, err := sql.Open(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	m := New(db, *dialect)
+	if err := m.LoadDir(*dir); err != nil {
+		return err
+	}
+
+	switch action {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintln(out, "applied", migration.Version, migration.Name)
+		}
+		return err
+	case "down":
+		steps := 1
+		if flags.NArg() > 1 {
+			if steps, err = strconv.Atoi(flags.Arg(1)); err != nil || steps < 1 {
+				return fmt.Errorf("invalid steps %q", flags.Arg(1))
+			}
+		}
+		reverted, err := m.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Fprintln(out, "reverted", migration.Version, migration.Name)
+		}
+		return err
+	case "redo":
+		migration, err := m.Redo(ctx)
+		if migration != nil && err == nil {
+			fmt.Fprintln(out, "redone", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Missing {
+				state += " (missing file)"
+			}
+			fmt.Fprintf(out, "%s %s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+	case "unlock":
+		return m.Unlock(ctx)
+	}
+	return errors.New(usage)
+}
@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"hash/fnv"
+	"log"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// locked runs fn holding the migration lock: an advisory lock on PostgreSQL and MySQL, released
+// if the connection is lost, and a row of Table+"_lock" on SQLite, see sqliteLock and Unlock.
+func (m *Migrator) locked(ctx context.Context, fn func() error) error {
+	if err := m.createTable(ctx); err != nil {
+		return err
+	}
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	acquire, release := m.lockQueries()
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		ok, err := acquire(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	defer release(context.Background(), conn)
+	return fn()
+}
+
+type lockFunc func(ctx context.Context, conn *sql.Conn) (bool, error)
+
+func (m *Migrator) lockQueries() (acquire lockFunc, release lockFunc) {
+	switch m.Dialect {
+	case config.DialectPostgres:
+		hash := fnv.New64a()
+		hash.Write([]byte(Table))
+		key := int64(hash.Sum64() >> 1)
+		return func(ctx context.Context, conn *sql.Conn) (bool, error) {
+				var ok bool
+				err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok)
+				return ok, err
+			}, func(ctx context.Context, conn *sql.Conn) (bool, error) {
+				_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key)
+				return err == nil, err
+			}
+	case config.DialectMySQL:
+		return func(ctx context.Context, conn *sql.Conn) (bool, error) {
+				var ok sql.NullInt64
+				err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", Table).Scan(&ok)
+				return ok.Int64 == 1, err
+			}, func(ctx context.Context, conn *sql.Conn) (bool, error) {
+				_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", Table)
+				return err == nil, err
+			}
+	}
+	return m.sqliteLock()
+}
+
+// sqliteLock takes the row of Table+"_lock" for LockLease, renewed while the runner holds it, and
+// breaks the row of a runner whose lease passed, e.g. because it crashed.
+func (m *Migrator) sqliteLock() (acquire lockFunc, release lockFunc) {
+	lockTable := Table + "_lock"
+	token := make([]byte, 16)
+	rand.Read(token)
+	owner := hex.EncodeToString(token)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	return func(ctx context.Context, conn *sql.Conn) (bool, error) {
+			if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+lockTable+" (id INTEGER PRIMARY KEY, owner VARCHAR(32) NOT NULL, "+
+				"locked_until TIMESTAMP NOT NULL)"); err != nil {
+				return false, err
+			}
+			now := time.Now().UTC()
+			if _, err := conn.ExecContext(ctx, "DELETE FROM "+lockTable+" WHERE id = 1 AND locked_until < ?", now); err != nil {
+				return false, err
+			}
+			result, err := conn.ExecContext(ctx, "INSERT OR IGNORE INTO "+lockTable+" (id, owner, locked_until) VALUES (1, ?, ?)", owner, now.Add(LockLease))
+			if err != nil {
+				return false, err
+			}
+			inserted, err := result.RowsAffected()
+			if inserted == 1 && err == nil {
+				go func() {
+					defer close(done)
+					ticker := time.NewTicker(LockLease / 3)
+					defer ticker.Stop()
+					for {
+						select {
+						case <-stop:
+							return
+						case <-ticker.C:
+							_, err := conn.ExecContext(context.Background(), "UPDATE "+lockTable+" SET locked_until = ? WHERE id = 1 AND owner = ?",
+								time.Now().UTC().Add(LockLease), owner)
+							if err != nil {
+								log.Printf("Error al renovar el bloqueo de migraciones[036-Migrate]: %v", err)
+							}
+						}
+					}
+				}()
+			}
+			return inserted == 1, err
+		}, func(ctx context.Context, conn *sql.Conn) (bool, error) {
+			close(stop)
+			<-done
+			_, err := conn.ExecContext(ctx, "DELETE FROM "+lockTable+" WHERE id = 1 AND owner = ?", owner)
+			return err == nil, err
+		}
+}
+
+// Unlock releases the SQLite lock left by a runner that did not finish before its lease passes;
+// advisory locks need no unlock.
+func (m *Migrator) Unlock(ctx context.Context) error {
+	if m.Dialect == config.DialectPostgres || m.Dialect == config.DialectMySQL {
+		return nil
+	}
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM "+Table+"_lock WHERE id = 1")
+	return err
+}
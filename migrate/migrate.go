@@ -0,0 +1,376 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+var (
+	// Table records the applied migrations.
+	Table string = "s2s_migrations"
+	// LockTimeout is how long a runner waits for the lock held by another replica.
+	LockTimeout = time.Minute
+	// LockLease is how long the SQLite lock outlives a runner that stopped renewing it.
+	LockLease = 5 * time.Minute
+
+	ErrLocked  = errors.New("migrations are locked by another runner")
+	ErrNoDown  = errors.New("migration has no down")
+	ErrVersion = errors.New("duplicated migration version")
+)
+
+// NoTransaction, as the first line of a SQL file, runs it outside a transaction, e.g. for CREATE INDEX CONCURRENTLY.
+const NoTransaction = "-- s2s:no-transaction"
+
+// StatementBegin and StatementEnd enclose a statement with ";" inside, e.g. a PostgreSQL $$ function body
+// or a MySQL BEGIN ... END trigger, so Split runs it whole.
+const (
+	StatementBegin = "-- s2s:statement-begin"
+	StatementEnd   = "-- s2s:statement-end"
+)
+
+// Executor runs the statements of a migration, the *sql.Tx of the migration or the *sql.DB with NoTx.
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Func is a Go migration.
+type Func func(ctx context.Context, db Executor) error
+
+// Migration is a versioned change, applied in the order of Version.
+type Migration struct {
+	Version string
+	Name    string
+	Up      Func
+	Down    Func
+	// NoTx runs the migration outside a transaction.
+	NoTx bool
+}
+
+// Status is the state of a migration; migrations applied but no longer known have Missing set.
+type Status struct {
+	Version   string
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	Missing   bool
+}
+
+// Migrator applies migrations to DB and records them in Table, holding a lock so that replicas
+// starting at the same time do not apply them twice.
+type Migrator struct {
+	DB      *sql.DB
+	Dialect string
+
+	migrations []Migration
+}
+
+// New returns a migrator for db, config.DB and config.Dialect when db is nil.
+func New(db *sql.DB, dialect string) *Migrator {
+	if db == nil {
+		db, dialect = config.DB, config.Dialect
+	}
+	return &Migrator{DB: db, Dialect: dialect}
+}
+
+// Add adds Go migrations.
+func (m *Migrator) Add(migrations ...Migration) error {
+	for _, migration := range migrations {
+		for _, existing := range m.migrations {
+			if existing.Version == migration.Version {
+				return fmt.Errorf("%w: %s", ErrVersion, migration.Version)
+			}
+		}
+		m.migrations = append(m.migrations, migration)
+	}
+	sort.SliceStable(m.migrations, func(i, j int) bool { return versionLess(m.migrations[i].Version, m.migrations[j].Version) })
+	return nil
+}
+
+// versionLess compares numeric versions as numbers and the others as strings.
+func versionLess(a string, b string) bool {
+	x, errA := strconv.ParseUint(a, 10, 64)
+	y, errB := strconv.ParseUint(b, 10, 64)
+	if errA == nil && errB == nil {
+		return x < y
+	}
+	return a < b
+}
+
+var fileRegex = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadDir adds the SQL migrations of dir, see LoadFS.
+func (m *Migrator) LoadDir(dir string) error {
+	return m.LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS adds the files <version>_<name>.up.sql and <version>_<name>.down.sql of dir in fsys, e.g. an embed.FS,
+// as written by schema.Generate. Statements end with ";" at the end of a line.
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	byVersion := map[string]*Migration{}
+	versions := []string{}
+	for _, entry := range entries {
+		match := fileRegex.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, strings.TrimPrefix(dir+"/"+entry.Name(), "./"))
+		if err != nil {
+			return err
+		}
+		migration, ok := byVersion[match[1]]
+		if !ok {
+			migration = &Migration{Version: match[1], Name: match[2]}
+			byVersion[match[1]] = migration
+			versions = append(versions, match[1])
+		}
+		if migration.Name != match[2] {
+			return fmt.Errorf("%w: %s", ErrVersion, match[1])
+		}
+		sqlFunc := SQL(string(content))
+		if match[3] == "up" {
+			migration.Up = sqlFunc
+			migration.NoTx = strings.HasPrefix(string(content), NoTransaction)
+		} else {
+			migration.Down = sqlFunc
+		}
+	}
+	for _, version := range versions {
+		if err := m.Add(*byVersion[version]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQL returns a Func that runs the statements of script.
+func SQL(script string) Func {
+	statements := Split(script)
+	return func(ctx context.Context, db Executor) error {
+		for _, statement := range statements {
+			if _, err := db.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("%w in %q", err, statement)
+			}
+		}
+		return nil
+	}
+}
+
+// Split splits script in statements ending with ";" at the end of a line, skipping the comment lines.
+// It does not parse SQL: a ";" at the end of a line inside a string or a function body also ends the
+// statement, so such statements go between StatementBegin and StatementEnd lines and are kept whole.
+func Split(script string) []string {
+	statements := []string{}
+	var current []string
+	flush := func() {
+		if statement := strings.TrimSuffix(strings.TrimSpace(strings.Join(current, "\n")), ";"); statement != "" {
+			statements = append(statements, statement)
+		}
+		current = nil
+	}
+	block := false
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, StatementBegin) || strings.HasPrefix(trimmed, StatementEnd):
+			flush()
+			block = strings.HasPrefix(trimmed, StatementBegin)
+			continue
+		case block:
+			current = append(current, line)
+			continue
+		case trimmed == "" || strings.HasPrefix(trimmed, "--"):
+			continue
+		}
+		current = append(current, line)
+		if strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+	flush()
+	return statements
+}
+
+// placeholder returns the n-th bind parameter of the dialect.
+func (m *Migrator) placeholder(n int) string {
+	if m.Dialect == config.DialectPostgres {
+		return "$" + strconv.Itoa(n)
+	}
+	return "?"
+}
+
+func (m *Migrator) createTable(ctx context.Context) error {
+	_, err := m.DB.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+Table+
+		" (version VARCHAR(255) NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)")
+	return err
+}
+
+type applied struct {
+	name string
+	at   time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[string]applied, error) {
+	rows, err := m.DB.QueryContext(ctx, "SELECT version, name, applied_at FROM "+Table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := map[string]applied{}
+	for rows.Next() {
+		var version string
+		var a applied
+		if err := rows.Scan(&version, &a.name, &a.at); err != nil {
+			return nil, err
+		}
+		result[version] = a
+	}
+	return result, rows.Err()
+}
+
+// Status returns the state of every migration, the missing ones last.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.createTable(ctx); err != nil {
+		return nil, err
+	}
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []Status{}
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if a, ok := done[migration.Version]; ok {
+			status.Applied, status.AppliedAt = true, a.at
+			delete(done, migration.Version)
+		}
+		result = append(result, status)
+	}
+	missing := []Status{}
+	for version, a := range done {
+		missing = append(missing, Status{Version: version, Name: a.name, Applied: true, AppliedAt: a.at, Missing: true})
+	}
+	sort.Slice(missing, func(i, j int) bool { return versionLess(missing[i].Version, missing[j].Version) })
+	return append(result, missing...), nil
+}
+
+// Up applies the pending migrations in order and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	result := []Migration{}
+	err := m.locked(ctx, func() error {
+		done, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, migration, true); err != nil {
+				return err
+			}
+			result = append(result, migration)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// Down reverts the last steps applied migrations and returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var result []Migration
+	err := m.locked(ctx, func() error {
+		var err error
+		result, err = m.down(ctx, steps)
+		return err
+	})
+	return result, err
+}
+
+func (m *Migrator) down(ctx context.Context, steps int) ([]Migration, error) {
+	done, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := []Migration{}
+	for i := len(m.migrations) - 1; i >= 0 && len(result) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := done[migration.Version]; !ok {
+			continue
+		}
+		if err := m.run(ctx, migration, false); err != nil {
+			return result, err
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+// Redo reverts and applies again the last applied migration, holding the lock for both.
+func (m *Migrator) Redo(ctx context.Context) (*Migration, error) {
+	var migration *Migration
+	err := m.locked(ctx, func() error {
+		reverted, err := m.down(ctx, 1)
+		if err != nil || len(reverted) == 0 {
+			return err
+		}
+		migration = &reverted[0]
+		return m.run(ctx, *migration, true)
+	})
+	return migration, err
+}
+
+// run applies (up) or reverts migration and updates Table in the same transaction.
+func (m *Migrator) run(ctx context.Context, migration Migration, up bool) error {
+	fn := migration.Up
+	record := "INSERT INTO " + Table + " (version, name, applied_at) VALUES (" + m.placeholder(1) + ", " + m.placeholder(2) + ", " + m.placeholder(3) + ")"
+	args := []interface{}{migration.Version, migration.Name, time.Now().UTC()}
+	if !up {
+		fn = migration.Down
+		record = "DELETE FROM " + Table + " WHERE version = " + m.placeholder(1)
+		args = args[:1]
+	}
+	if fn == nil {
+		if !up {
+			return fmt.Errorf("%w: %s_%s", ErrNoDown, migration.Version, migration.Name)
+		}
+		fn = func(ctx context.Context, db Executor) error { return nil }
+	}
+
+	if migration.NoTx {
+		if err := fn(ctx, m.DB); err != nil {
+			return fmt.Errorf("%s_%s: %w", migration.Version, migration.Name, err)
+		}
+		_, err := m.DB.ExecContext(ctx, record, args...)
+		return err
+	}
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, tx); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("%s_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
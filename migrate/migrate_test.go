@@ -0,0 +1,253 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openDB(t *testing.T) (*sql.DB, string) {
+	dsn := filepath.Join(t.TempDir(), "migrate.db")
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, dsn
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func tableExists(db *sql.DB, table string) bool {
+	var name string
+	return db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name) == nil
+}
+
+func TestSplit(t *testing.T) {
+	statements := Split("-- comment\nCREATE TABLE a (\n  id INTEGER\n);\n\nINSERT INTO a VALUES (1); \nINSERT INTO a VALUES (2)")
+	if len(statements) != 3 || statements[0] != "CREATE TABLE a (\n  id INTEGER\n)" || statements[2] != "INSERT INTO a VALUES (2)" {
+		t.Fatal("unexpected statements", statements)
+	}
+
+	// statements with ";" inside are kept whole between the markers
+	function := "CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n  NEW.updated_at := now();\n  RETURN NEW;\nEND;\n$$ LANGUAGE plpgsql"
+	statements = Split("CREATE TABLE a (id INTEGER);\n" + StatementBegin + "\n" + function + ";\n" + StatementEnd + "\nDROP TABLE b;")
+	if len(statements) != 3 || statements[1] != function || statements[2] != "DROP TABLE b" {
+		t.Fatal("expected the function in one statement", statements)
+	}
+}
+
+func TestStatementBlock(t *testing.T) {
+	db, _ := openDB(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"1_counters.up.sql": `CREATE TABLE items (id INTEGER PRIMARY KEY);
+CREATE TABLE counters (total INTEGER);
+INSERT INTO counters VALUES (0);
+` + StatementBegin + `
+CREATE TRIGGER count_items AFTER INSERT ON items
+BEGIN
+  UPDATE counters SET total = total + 1;
+END;
+` + StatementEnd + `
+INSERT INTO items (id) VALUES (1);
+`,
+	})
+	m := New(db, config.DialectSQLite)
+	if err := m.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var total int
+	if err := db.QueryRow("SELECT total FROM counters").Scan(&total); err != nil || total != 1 {
+		t.Fatal("expected the trigger to count the item", total, err)
+	}
+}
+
+func TestUpDownRedo(t *testing.T) {
+	db, _ := openDB(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"1_create_users.up.sql":   "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT);\n",
+		"1_create_users.down.sql": "DROP TABLE users;\n",
+		"10_create_posts.up.sql":  NoTransaction + "\nCREATE TABLE posts (id INTEGER PRIMARY KEY);\n",
+		"README.md":               "ignored",
+	})
+	m := New(db, config.DialectSQLite)
+	if err := m.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Add(Migration{Version: "2", Name: "seed",
+		Up: func(ctx context.Context, db Executor) error {
+			_, err := db.ExecContext(ctx, "INSERT INTO users (id, name) VALUES (1, 'ana')")
+			return err
+		},
+		Down: SQL("DELETE FROM users;"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(Migration{Version: "2", Name: "again"}); !errors.Is(err, ErrVersion) {
+		t.Fatal("expected a duplicated version error", err)
+	}
+	if len(m.migrations) != 3 || m.migrations[2].Version != "10" || !m.migrations[2].NoTx {
+		t.Fatal("expected the migrations in numeric order", m.migrations)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil || len(applied) != 3 {
+		t.Fatal(applied, err)
+	}
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 0 {
+		t.Fatal("expected nothing to apply", applied, err)
+	}
+	var count int
+	db.QueryRow("SELECT count(*) FROM users").Scan(&count)
+	if count != 1 || !tableExists(db, "posts") {
+		t.Fatal("expected the migrations to be applied")
+	}
+
+	// the last migration has no down
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrNoDown) {
+		t.Fatal("expected no down", err)
+	}
+	if _, err := db.Exec("DROP TABLE posts"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM "+Table+" WHERE version = ?", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	migration, err := m.Redo(ctx)
+	if err != nil || migration == nil || migration.Version != "2" {
+		t.Fatal(migration, err)
+	}
+	db.QueryRow("SELECT count(*) FROM users").Scan(&count)
+	if count != 1 {
+		t.Fatal("expected the seed to be applied again", count)
+	}
+
+	reverted, err := m.Down(ctx, 5)
+	if err != nil || len(reverted) != 2 || reverted[0].Version != "2" || tableExists(db, "users") {
+		t.Fatal(reverted, err)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil || len(statuses) != 3 || statuses[0].Applied || statuses[2].Applied {
+		t.Fatal(statuses, err)
+	}
+}
+
+func TestFailureRollsBack(t *testing.T) {
+	db, _ := openDB(t)
+	ctx := context.Background()
+	m := New(db, config.DialectSQLite)
+	m.Add(Migration{Version: "1", Name: "broken", Up: SQL("CREATE TABLE broken (id INTEGER);\nINSERT INTO missing VALUES (1);")})
+	if _, err := m.Up(ctx); err == nil || !strings.Contains(err.Error(), "1_broken") {
+		t.Fatal("expected the migration to fail", err)
+	}
+	if tableExists(db, "broken") {
+		t.Fatal("expected the transaction to be rolled back")
+	}
+	statuses, _ := m.Status(ctx)
+	if len(statuses) != 1 || statuses[0].Applied {
+		t.Fatal("expected the migration to stay pending", statuses)
+	}
+
+	// applied migrations that are no longer known are reported as missing
+	db.Exec("INSERT INTO "+Table+" (version, name, applied_at) VALUES ('0', 'old', ?)", time.Now())
+	if statuses, _ := m.Status(ctx); len(statuses) != 2 || !statuses[1].Missing || statuses[1].Name != "old" {
+		t.Fatal("expected a missing migration", statuses)
+	}
+}
+
+func TestLock(t *testing.T) {
+	db, _ := openDB(t)
+	ctx := context.Background()
+	timeout := LockTimeout
+	LockTimeout = 200 * time.Millisecond
+	defer func() { LockTimeout = timeout }()
+
+	m := New(db, config.DialectSQLite)
+	m.Add(Migration{Version: "1", Name: "noop"})
+	err := m.locked(ctx, func() error {
+		_, err := m.Up(ctx)
+		return err
+	})
+	if !errors.Is(err, ErrLocked) {
+		t.Fatal("expected the nested runner to wait for the lock", err)
+	}
+
+	// a crashed runner leaves the SQLite lock behind until its lease passes or Unlock
+	db.Exec("INSERT INTO "+Table+"_lock (id, owner, locked_until) VALUES (1, 'crashed', ?)", time.Now().UTC().Add(LockLease))
+	if _, err := m.Up(ctx); !errors.Is(err, ErrLocked) {
+		t.Fatal("expected the lock to be held", err)
+	}
+	if err := m.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 1 {
+		t.Fatal(applied, err)
+	}
+
+	db.Exec("INSERT INTO "+Table+"_lock (id, owner, locked_until) VALUES (1, 'crashed', ?)", time.Now().UTC().Add(-time.Second))
+	m.Add(Migration{Version: "2", Name: "noop"})
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 1 {
+		t.Fatal("expected the stale lock to be broken", applied, err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	_, dsn := openDB(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	out := &bytes.Buffer{}
+	if err := Command(ctx, []string{"-dir", dir, "create", "Add Tags"}, out); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*_add_tags.*.sql"))
+	if len(files) != 2 {
+		t.Fatal("expected the up and down files", files, out.String())
+	}
+	writeFiles(t, dir, map[string]string{
+		filepath.Base(files[1]): "CREATE TABLE tags (id INTEGER PRIMARY KEY);",
+		filepath.Base(files[0]): "DROP TABLE tags;",
+	})
+
+	args := []string{"-dsn", dsn, "-dir", dir}
+	out.Reset()
+	if err := Command(ctx, append(args, "up"), out); err != nil || !strings.HasPrefix(out.String(), "applied ") {
+		t.Fatal(out.String(), err)
+	}
+	out.Reset()
+	if err := Command(ctx, append(args, "status"), out); err != nil || !strings.Contains(out.String(), "add_tags applied") {
+		t.Fatal(out.String(), err)
+	}
+	out.Reset()
+	if err := Command(ctx, append(args, "down", "1"), out); err != nil || !strings.HasPrefix(out.String(), "reverted ") {
+		t.Fatal(out.String(), err)
+	}
+	if err := Command(ctx, append(args, "down", "x"), out); err == nil {
+		t.Fatal("expected invalid steps")
+	}
+	if err := Command(ctx, append(args, "sideways"), out); err == nil {
+		t.Fatal("expected the usage")
+	}
+}
@@ -0,0 +1,75 @@
+package notifier
+
+import (
+	"log"
+	"sync"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+// Buffer is the number of changes kept for a slow subscriber; further changes are dropped for it.
+var Buffer int = 64
+
+// Notifier fans out committed changes to the subscribers of each table.
+type Notifier struct {
+	mu          sync.RWMutex
+	next        int
+	subscribers map[int]*subscriber
+}
+
+type subscriber struct {
+	table string
+	ch    chan repositories.Change
+}
+
+func New() *Notifier {
+	return &Notifier{subscribers: map[int]*subscriber{}}
+}
+
+// Default is fed by every committed repository write once Subscribe is called.
+var Default = New()
+
+var once sync.Once
+
+// Subscribe returns the committed changes of table (every table when empty) and the function that
+// ends the subscription. The first call starts capturing the changes of the repositories.
+func Subscribe(table string) (<-chan repositories.Change, func()) {
+	once.Do(func() {
+		repositories.OnChangeCommit(Default.Publish)
+	})
+	return Default.Subscribe(table)
+}
+
+func (n *Notifier) Subscribe(table string) (<-chan repositories.Change, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	id := n.next
+	n.next++
+	s := &subscriber{table: table, ch: make(chan repositories.Change, Buffer)}
+	n.subscribers[id] = s
+	var unsubscribe sync.Once
+	return s.ch, func() {
+		unsubscribe.Do(func() {
+			n.mu.Lock()
+			delete(n.subscribers, id)
+			n.mu.Unlock()
+			close(s.ch)
+		})
+	}
+}
+
+// Publish sends change to the subscribers of its table without blocking the writer.
+func (n *Notifier) Publish(change repositories.Change) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, s := range n.subscribers {
+		if s.table != "" && s.table != change.Table {
+			continue
+		}
+		select {
+		case s.ch <- change:
+		default:
+			log.Printf("Cambio descartado para un suscriptor lento de %s[027-Notifier]", change.Table)
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/handlers"
+	"github.com/arturoeanton/go-struct2serve/validators"
+	"github.com/labstack/echo/v4"
+)
+
+const Version = "3.1.0"
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	// names are the component keys of the types, see SchemaRef
+	names map[reflect.Type]string
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+	Tags        []string             `json:"tags,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	OperationID string               `json:"operationId,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required,omitempty"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	WriteOnly            bool               `json:"writeOnly,omitempty"`
+}
+
+// Generate builds the specification of every resource registered on e with Handler.RegisterRoutes.
+func Generate(e *echo.Echo, info Info) *Document {
+	doc := &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      map[string]map[string]*Operation{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+	for _, resource := range handlers.Resources(e) {
+		modelName := SchemaRef(doc, resource.Model).Ref[len("#/components/schemas/"):]
+		for _, route := range resource.Routes {
+			suffix := strings.TrimPrefix(route.Path, resource.Path)
+			operation := newOperation(doc, route.Method, suffix, resource, modelName)
+			if operation == nil {
+				continue
+			}
+			path := toOpenAPIPath(route.Path)
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = map[string]*Operation{}
+			}
+			doc.Paths[path][strings.ToLower(route.Method)] = operation
+		}
+	}
+	return doc
+}
+
+// Register serves the specification as json at path, generated on each request so late registrations are included.
+func Register(e *echo.Echo, path string, info Info) {
+	e.GET(path, func(c echo.Context) error {
+		return c.JSON(http.StatusOK, Generate(e, info))
+	})
+}
+
+// toOpenAPIPath turns echo params (:id) into OpenAPI templates ({id}).
+func toOpenAPIPath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func jsonContent(schema *Schema) map[string]*MediaType {
+	return map[string]*MediaType{echo.MIMEApplicationJSON: {Schema: schema}}
+}
+
+func errorResponse(description string) *Response {
+	return &Response{
+		Description: description,
+		Content: jsonContent(&Schema{Type: "object", Properties: map[string]*Schema{
+			"error": {Type: "string"},
+		}}),
+	}
+}
+
+func newOperation(doc *Document, method string, suffix string, resource *handlers.Resource, modelName string) *Operation {
+	ref := &Schema{Ref: "#/components/schemas/" + modelName}
+	list := &Schema{Type: "array", Items: ref}
+	validation := &Response{
+		Description: "Validation failed",
+		Content: jsonContent(&Schema{Type: "object", Properties: map[string]*Schema{
+			"error":  {Type: "string"},
+			"fields": {Type: "array", Items: fieldErrorSchema()},
+		}}),
+	}
+	idParam := &Parameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}}
+	formatParam := &Parameter{Name: "format", In: "query", Description: "Response format, also negotiated with Accept", Schema: &Schema{Type: "string", Enum: []interface{}{"json", "ndjson", "csv", "xml"}}}
+	idempotencyParam := &Parameter{Name: "Idempotency-Key", In: "header", Description: "Retries with the same key return the stored response", Schema: &Schema{Type: "string"}}
+	ifNoneMatchParam := &Parameter{Name: "If-None-Match", In: "header", Description: "ETag of a previous response", Schema: &Schema{Type: "string"}}
+
+	op := &Operation{
+		Tags:      []string{strings.Trim(resource.Path, "/")},
+		Responses: map[string]*Response{"500": errorResponse("Unexpected error")},
+	}
+	id := strings.Trim(strings.ReplaceAll(resource.Path, "/", "_"), "_")
+	if method == http.MethodGet && suffix == "/:id/history" {
+		op.Summary = "Audit history of " + modelName
+		op.OperationID = "history_" + id
+		op.Parameters = []*Parameter{idParam}
+		op.Responses["200"] = &Response{Description: "Revisions from the oldest to the newest", Content: jsonContent(&Schema{Type: "array", Items: &Schema{Type: "object", AdditionalProperties: true}})}
+		return op
+	}
+	if strings.HasPrefix(suffix, "/:id/") {
+		return relationOperation(doc, op, method, suffix, id, modelName, resource, idParam)
+	}
+	switch method + " " + suffix {
+	case "GET ":
+		op.Summary = "List " + modelName
+		op.OperationID = "list_" + id
+		op.Parameters = append([]*Parameter{formatParam, ifNoneMatchParam}, listParameters(resource)...)
+		op.Responses["200"] = &Response{Description: "OK", Content: jsonContent(list)}
+		op.Responses["304"] = &Response{Description: "Not Modified"}
+		op.Responses["400"] = errorResponse("Invalid column, limit or offset")
+	case "POST ":
+		op.Summary = "Create " + modelName
+		op.OperationID = "create_" + id
+		op.Parameters = []*Parameter{idempotencyParam}
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(ref)}
+		op.Responses["200"] = &Response{Description: "Id of the new row", Content: jsonContent(&Schema{Type: "integer"})}
+		op.Responses["422"] = validation
+	case "PUT ", "PUT /:id":
+		op.Summary = "Update " + modelName
+		op.OperationID = "update_" + id
+		if suffix != "" {
+			op.OperationID += "_by_id"
+			op.Parameters = []*Parameter{idParam}
+		}
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(ref)}
+		op.Responses["204"] = &Response{Description: "Updated"}
+		op.Responses["422"] = validation
+	case "GET /:id":
+		op.Summary = "Get " + modelName + " by id"
+		op.OperationID = "get_" + id
+		op.Parameters = []*Parameter{idParam, formatParam, ifNoneMatchParam,
+			{Name: "If-Modified-Since", In: "header", Schema: &Schema{Type: "string"}}}
+		op.Responses["200"] = &Response{Description: "OK", Content: jsonContent(ref)}
+		op.Responses["304"] = &Response{Description: "Not Modified"}
+	case "DELETE /:id":
+		op.Summary = "Delete " + modelName + " by id"
+		op.OperationID = "delete_" + id
+		op.Parameters = []*Parameter{idParam}
+		op.Responses["200"] = &Response{Description: "Deleted", Content: jsonContent(&Schema{Type: "string"})}
+	case "POST /bulk":
+		op.Summary = "Create many " + modelName
+		op.OperationID = "create_many_" + id
+		op.Parameters = []*Parameter{idempotencyParam}
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(list)}
+		op.Responses["200"] = &Response{Description: "Ids of the new rows", Content: jsonContent(&Schema{Type: "array", Items: &Schema{Type: "integer"}})}
+		op.Responses["422"] = validation
+	case "PUT /bulk":
+		op.Summary = "Update many " + modelName
+		op.OperationID = "update_many_" + id
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(list)}
+		op.Responses["204"] = &Response{Description: "Updated"}
+		op.Responses["422"] = validation
+	case "DELETE /bulk":
+		op.Summary = "Delete many " + modelName
+		op.OperationID = "delete_many_" + id
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(&Schema{Type: "array", Items: &Schema{}})}
+		op.Responses["200"] = &Response{Description: "Deleted ids", Content: jsonContent(&Schema{Type: "array", Items: &Schema{}})}
+	case "POST /import":
+		op.Summary = "Import " + modelName + " from CSV or NDJSON"
+		op.OperationID = "import_" + id
+		op.Parameters = []*Parameter{
+			{Name: "format", In: "query", Schema: &Schema{Type: "string", Enum: []interface{}{"csv", "ndjson"}}},
+			{Name: "dry_run", In: "query", Schema: &Schema{Type: "boolean"}},
+		}
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]*MediaType{
+			"text/csv":             {Schema: &Schema{Type: "string"}},
+			"application/x-ndjson": {Schema: &Schema{Type: "string"}},
+			echo.MIMEMultipartForm: {Schema: &Schema{Type: "object", Properties: map[string]*Schema{"file": {Type: "string", Format: "binary"}}}},
+		}}
+		report := &Schema{Type: "object", AdditionalProperties: true}
+		op.Responses["200"] = &Response{Description: "Import report", Content: jsonContent(report)}
+		op.Responses["422"] = &Response{Description: "Import report with row errors", Content: jsonContent(report)}
+	case "GET /stats":
+		op.Summary = "Aggregate " + modelName
+		op.OperationID = "stats_" + id
+		op.Parameters = []*Parameter{
+			{Name: "group_by", In: "query", Description: "Comma separated columns", Schema: &Schema{Type: "string"}},
+			{Name: "agg", In: "query", Description: "Comma separated aggregates, e.g. count,sum:amount", Schema: &Schema{Type: "string"}},
+		}
+		for _, column := range readableColumns(resource.Model) {
+			op.Parameters = append(op.Parameters, &Parameter{Name: column, In: "query", Description: "Equality filter", Schema: &Schema{Type: "string"}})
+		}
+		op.Responses["200"] = &Response{Description: "OK", Content: jsonContent(&Schema{Type: "array", Items: &Schema{Type: "object", AdditionalProperties: true}})}
+		op.Responses["400"] = errorResponse("Invalid column or aggregate")
+	case "GET /events":
+		op.Summary = "Server-Sent Events of the changes of " + modelName
+		op.OperationID = "events_" + id
+		op.Parameters = []*Parameter{{Name: "action", In: "query", Description: "Comma separated actions: create, update, delete", Schema: &Schema{Type: "string"}}}
+		for _, column := range readableColumns(resource.Model) {
+			op.Parameters = append(op.Parameters, &Parameter{Name: column, In: "query", Description: "Equality filter", Schema: &Schema{Type: "string"}})
+		}
+		op.Responses["200"] = &Response{Description: "Event stream", Content: map[string]*MediaType{"text/event-stream": {Schema: &Schema{Type: "string"}}}}
+	case "GET /live":
+		op.Summary = "WebSocket of live queries on " + modelName
+		op.OperationID = "live_" + id
+		op.Responses["101"] = &Response{Description: "Switching Protocols"}
+	case "GET /stream":
+		op.Summary = "Stream " + modelName
+		op.OperationID = "stream_" + id
+		op.Parameters = append([]*Parameter{{Name: "format", In: "query", Schema: &Schema{Type: "string", Enum: []interface{}{"json", "ndjson"}}}}, listParameters(resource)...)
+		op.Responses["200"] = &Response{Description: "OK", Content: map[string]*MediaType{
+			echo.MIMEApplicationJSON: {Schema: list},
+			"application/x-ndjson":   {Schema: ref},
+		}}
+		op.Responses["400"] = errorResponse("Invalid column, limit or offset")
+	default:
+		return nil
+	}
+	return op
+}
+
+// relationOperation describes GET /:id/<relation> and POST/DELETE /:id/<relation>/:relatedId.
+func relationOperation(doc *Document, op *Operation, method string, suffix string, id string, modelName string, resource *handlers.Resource, idParam *Parameter) *Operation {
+	parts := strings.Split(strings.TrimPrefix(suffix, "/:id/"), "/")
+	name := parts[0]
+	var related *Schema
+	for i := 0; i < resource.Model.NumField(); i++ {
+		field := resource.Model.Field(i)
+		if field.Tag.Get("s2s") != "" && validators.JsonName(field) == name {
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Slice {
+				related = &Schema{Type: "array", Items: SchemaRef(doc, fieldType.Elem())}
+			} else {
+				related = SchemaRef(doc, fieldType)
+			}
+		}
+	}
+	if related == nil {
+		return nil
+	}
+
+	op.Parameters = []*Parameter{idParam}
+	switch {
+	case method == http.MethodGet && len(parts) == 1:
+		op.Summary = "Get " + name + " of " + modelName
+		op.OperationID = "get_" + id + "_" + name
+		op.Responses["200"] = &Response{Description: "OK", Content: jsonContent(related)}
+		op.Responses["404"] = errorResponse("Not found")
+	case method == http.MethodPost && len(parts) == 2:
+		op.Summary = "Link " + name + " to " + modelName
+		op.OperationID = "link_" + id + "_" + name
+		op.Parameters = append(op.Parameters, &Parameter{Name: "relatedId", In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		op.Responses["204"] = &Response{Description: "Linked"}
+	case method == http.MethodDelete && len(parts) == 2:
+		op.Summary = "Unlink " + name + " from " + modelName
+		op.OperationID = "unlink_" + id + "_" + name
+		op.Parameters = append(op.Parameters, &Parameter{Name: "relatedId", In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		op.Responses["204"] = &Response{Description: "Unlinked"}
+	default:
+		return nil
+	}
+	return op
+}
+
+// listParameters describes the filters, sort and pagination of GET / and GET /stream.
+func listParameters(resource *handlers.Resource) []*Parameter {
+	zero := 0.0
+	parameters := []*Parameter{
+		{Name: "sort", In: "query", Description: "Comma separated columns, descending with a - prefix", Schema: &Schema{Type: "string"}},
+		{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Minimum: &zero}},
+		{Name: "offset", In: "query", Description: "Requires limit", Schema: &Schema{Type: "integer", Minimum: &zero}},
+	}
+	for _, column := range readableColumns(resource.Model) {
+		parameters = append(parameters, &Parameter{Name: column, In: "query", Description: "Equality filter", Schema: &Schema{Type: "string"}})
+	}
+	return parameters
+}
+
+func fieldErrorSchema() *Schema {
+	return &Schema{Type: "object", Properties: map[string]*Schema{
+		"field":   {Type: "string"},
+		"rule":    {Type: "string"},
+		"param":   {Type: "string"},
+		"message": {Type: "string"},
+	}}
+}
+
+// readableColumns returns the db columns the handler accepts as filters, leaving out the
+// write_only and hidden ones.
+func readableColumns(model reflect.Type) []string {
+	columns := []string{}
+	for i := 0; i < model.NumField(); i++ {
+		field := model.Field(i)
+		access := field.Tag.Get(handlers.S2S_ACCESS)
+		if tag := field.Tag.Get("db"); tag != "" && access != handlers.AccessWriteOnly && access != handlers.AccessHidden {
+			columns = append(columns, tag)
+		}
+	}
+	return columns
+}
+
+// SchemaRef adds the schema of t (and of its nested structs) to the components of doc and returns a reference to it.
+// The schema is keyed by the type name; a type named like one added before, from another package, is keyed by
+// its package path and name, e.g. github.com.acme.billing.User.
+func SchemaRef(doc *Document, t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if doc.names == nil {
+		doc.names = map[reflect.Type]string{}
+	}
+	if name, ok := doc.names[t]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	name := t.Name()
+	if _, taken := doc.Components.Schemas[name]; taken {
+		name = strings.ReplaceAll(t.PkgPath(), "/", ".") + "." + name
+	}
+	// types declared in functions share the package path
+	for base, i := name, 2; doc.Components.Schemas[name] != nil; i++ {
+		name = base + "_" + strconv.Itoa(i)
+	}
+	doc.names[t] = name
+	ref := &Schema{Ref: "#/components/schemas/" + name}
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	// placeholder first so recursive relations (User -> Role -> User) terminate
+	doc.Components.Schemas[name] = schema
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		access := field.Tag.Get(handlers.S2S_ACCESS)
+		if !field.IsExported() || field.Tag.Get("json") == "-" || access == handlers.AccessHidden {
+			continue
+		}
+		name := validators.JsonName(field)
+		property := schemaOf(doc, field.Type)
+		if field.Tag.Get("s2s") != "" {
+			property = &Schema{Description: "Loaded from the s2s relation", ReadOnly: true, Type: property.Type, Items: property.Items, Ref: property.Ref}
+		}
+		property.ReadOnly = property.ReadOnly || access == handlers.AccessReadOnly
+		property.WriteOnly = access == handlers.AccessWriteOnly
+		if applyRules(property, field) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+	return ref
+}
+
+func schemaOf(doc *Document, t reflect.Type) *Schema {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+	var schema *Schema
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		schema = &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct:
+		return SchemaRef(doc, t)
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			schema = &Schema{Type: "string", Format: "byte"}
+		} else {
+			schema = &Schema{Type: "array", Items: schemaOf(doc, t.Elem())}
+		}
+	case t.Kind() == reflect.Map:
+		schema = &Schema{Type: "object", AdditionalProperties: schemaOf(doc, t.Elem())}
+	case t.Kind() == reflect.Bool:
+		schema = &Schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		schema = &Schema{Type: "integer"}
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			schema.Format = "int64"
+		}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		schema = &Schema{Type: "number"}
+	case t.Kind() == reflect.String:
+		schema = &Schema{Type: "string"}
+	default:
+		schema = &Schema{}
+	}
+	if nullable && schema.Type != nil {
+		schema.Type = []interface{}{schema.Type, "null"}
+	}
+	return schema
+}
+
+// applyRules maps the validate tag to schema keywords and reports whether the field is required.
+func applyRules(schema *Schema, field reflect.StructField) bool {
+	required := false
+	fieldType := field.Type
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	for _, rule := range validators.ParseRules(field.Tag.Get(validators.S2S_VALIDATE)) {
+		switch rule.Name {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "regex":
+			schema.Pattern = rule.Param
+		case "oneof":
+			for _, option := range strings.Fields(rule.Param) {
+				schema.Enum = append(schema.Enum, option)
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(rule.Param, 64)
+			if err != nil {
+				continue
+			}
+			i := int(n)
+			switch fieldType.Kind() {
+			case reflect.String:
+				if rule.Name != "max" {
+					schema.MinLength = &i
+				}
+				if rule.Name != "min" {
+					schema.MaxLength = &i
+				}
+			case reflect.Slice, reflect.Array, reflect.Map:
+				if rule.Name != "max" {
+					schema.MinItems = &i
+				}
+				if rule.Name != "min" {
+					schema.MaxItems = &i
+				}
+			default:
+				if rule.Name != "max" {
+					schema.Minimum = &n
+				}
+				if rule.Name != "min" {
+					schema.Maximum = &n
+				}
+			}
+		}
+	}
+	return required
+}
@@ -0,0 +1,122 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arturoeanton/go-struct2serve/handlers"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/labstack/echo/v4"
+)
+
+type Group struct {
+	ID    int     `json:"id" db:"id" s2s_table_name:"groups"`
+	Name  string  `json:"name" db:"name" validate:"required,max=20"`
+	Users *[]User `json:"users,omitempty" s2s:"group_id = ?"`
+}
+
+type User struct {
+	ID       *int   `json:"id" db:"id"`
+	Email    string `json:"email" db:"email" validate:"required,email"`
+	Kind     string `json:"kind" db:"kind" validate:"oneof=admin user"`
+	GroupID  *int   `json:"-" db:"group_id"`
+	Group    *Group `json:"group,omitempty" s2s:"id = ?" s2s_param:"GroupID"`
+	Password string `json:"password" db:"password" s2s_access:"write_only"`
+	Created  string `json:"created" db:"created" s2s_access:"read_only"`
+	Token    string `json:"token" db:"token" s2s_access:"hidden"`
+}
+
+func TestGenerate(t *testing.T) {
+	e := echo.New()
+	handlers.NewHandler[User]().RegisterRoutes(e.Group("/users"))
+	handlers.NewHandler[Group]().RegisterRoutes(e.Group("/groups"))
+
+	doc := Generate(e, Info{Title: "test", Version: "1.0"})
+	if doc.Paths["/users/{id}"]["get"] == nil || doc.Paths["/groups/bulk"]["post"] == nil {
+		t.Fatal("missing paths")
+	}
+	params := map[string]bool{}
+	for _, param := range doc.Paths["/users"]["get"].Parameters {
+		params[param.Name] = true
+	}
+	if !params["sort"] || !params["limit"] || !params["offset"] || !params["email"] {
+		t.Error("missing list parameters", params)
+	}
+	if params["password"] || params["token"] || !params["created"] {
+		t.Error("only readable columns are filters", params)
+	}
+	params = map[string]bool{}
+	for _, param := range doc.Paths["/users/stream"]["get"].Parameters {
+		params[param.Name] = true
+	}
+	if !params["format"] || !params["sort"] || !params["limit"] || !params["email"] {
+		t.Error("missing stream parameters", params)
+	}
+
+	// resources of other instances are left out
+	other := echo.New()
+	handlers.NewHandler[Group]().RegisterRoutes(other.Group("/teams"))
+	if doc := Generate(other, Info{}); len(doc.Paths) == 0 || doc.Paths["/users"] != nil || doc.Paths["/teams"] == nil {
+		t.Error("expected only the resources of the instance", doc.Paths)
+	}
+	if doc := Generate(e, Info{}); doc.Paths["/teams"] != nil {
+		t.Error("expected no resources of other instances")
+	}
+	if doc.Paths["/groups/{id}/users"]["get"] == nil || doc.Paths["/users/{id}/group"]["get"] == nil {
+		t.Error("missing relation paths")
+	}
+	user := doc.Components.Schemas["User"]
+	if user == nil || doc.Components.Schemas["Group"] == nil {
+		t.Fatal("missing schemas")
+	}
+	if _, ok := user.Properties["group_id"]; ok {
+		t.Error("json:\"-\" fields must be skipped")
+	}
+	if user.Properties["email"].Format != "email" || len(user.Properties["kind"].Enum) != 2 {
+		t.Error("validate rules not applied")
+	}
+	if !user.Properties["group"].ReadOnly {
+		t.Error("s2s relations must be read only")
+	}
+	if !user.Properties["password"].WriteOnly || user.Properties["password"].ReadOnly || !user.Properties["created"].ReadOnly {
+		t.Error("s2s_access must map to writeOnly and readOnly", user.Properties["password"], user.Properties["created"])
+	}
+	if _, ok := user.Properties["token"]; ok {
+		t.Error("hidden fields must be skipped")
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"required":["email"]`) {
+		t.Error("email must be required", string(data))
+	}
+}
+
+type Revision struct {
+	ID   int    `json:"id" db:"id"`
+	Note string `json:"note" db:"note"`
+}
+
+func TestSchemaNames(t *testing.T) {
+	e := echo.New()
+	handlers.NewHandler[Revision]().RegisterRoutes(e.Group("/revisions"))
+	doc := Generate(e, Info{})
+
+	// a type of another package with the same name must not replace the schema of the model
+	other := SchemaRef(doc, reflect.TypeOf(repositories.Revision{}))
+	if other.Ref != "#/components/schemas/github.com.arturoeanton.go-struct2serve.repositories.Revision" {
+		t.Error("expected a qualified name", other.Ref)
+	}
+	if doc.Components.Schemas["Revision"].Properties["note"] == nil {
+		t.Error("the schema of the model was replaced", doc.Components.Schemas["Revision"])
+	}
+	if again := SchemaRef(doc, reflect.TypeOf(&repositories.Revision{})); again.Ref != other.Ref {
+		t.Error("expected the same name for the same type", again.Ref)
+	}
+	if ref := SchemaRef(doc, reflect.TypeOf(Revision{})); ref.Ref != "#/components/schemas/Revision" {
+		t.Error("expected the plain name for the model", ref.Ref)
+	}
+}
@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// Dispatcher reads the pending events of Table and delivers them to every sink. An event is marked as
+// delivered only after all sinks accepted it; otherwise it is retried with backoff until MaxAttempts.
+// The events of a record are delivered in order: while one is pending the later ones wait, unless it
+// reached MaxAttempts. Only one dispatcher should run per database.
+type Dispatcher struct {
+	Sinks       []Sink
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	// Backoff returns the wait before the next attempt, exponential from one second by default.
+	Backoff func(attempts int) time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewDispatcher(sinks ...Sink) *Dispatcher {
+	return &Dispatcher{
+		Sinks:       sinks,
+		Interval:    time.Second,
+		BatchSize:   100,
+		MaxAttempts: 10,
+	}
+}
+
+// Start runs the dispatcher in a goroutine until Stop is called or ctx is done.
+func (d *Dispatcher) Start(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cancel != nil {
+		return
+	}
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+		for {
+			if _, err := d.DispatchOnce(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error al despachar los eventos[025-Dispatcher]: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the goroutine started by Start and waits for it.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	cancel, done := d.cancel, d.done
+	d.cancel, d.done = nil, nil
+	d.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// DispatchOnce delivers one batch of pending events and returns how many were delivered.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	events, err := d.pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+	delivered := 0
+	// records with a failed event in this batch, their later events wait for the retry
+	blocked := map[string]bool{}
+	for _, event := range events {
+		record := event.Table + "\x00" + event.RecordID
+		if blocked[record] {
+			continue
+		}
+		if err := d.send(ctx, event); err != nil {
+			if ctx.Err() != nil {
+				return delivered, ctx.Err()
+			}
+			if err := d.retry(ctx, event, err); err != nil {
+				return delivered, err
+			}
+			blocked[record] = true
+			continue
+		}
+		now := time.Now().UTC()
+		_, err := config.DB.ExecContext(ctx, "UPDATE "+Table+" SET delivered_at = ?, attempts = ? WHERE id = ?", now, event.Attempts+1, event.ID)
+		if err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func (d *Dispatcher) pending(ctx context.Context) ([]Event, error) {
+	batchSize := d.BatchSize
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+	// an event waits while an earlier event of the same record is pending and not due; earlier due
+	// events come first in the batch, where DispatchOnce skips the record once one fails
+	now := time.Now().UTC()
+	earlier := "SELECT 1 FROM " + Table + " p WHERE p.table_name = e.table_name AND p.record_id = e.record_id" +
+		" AND p.delivered_at IS NULL AND p.id < e.id AND p.next_attempt_at > ?"
+	earlierArgs := []interface{}{now}
+	query := "SELECT id, table_name, action, record_id, payload, created_at, attempts FROM " + Table +
+		" e WHERE delivered_at IS NULL AND next_attempt_at <= ?"
+	args := []interface{}{now}
+	if d.MaxAttempts > 0 {
+		query += " AND attempts < ?"
+		earlier += " AND p.attempts < ?"
+		args = append(args, d.MaxAttempts)
+		earlierArgs = append(earlierArgs, d.MaxAttempts)
+	}
+	query += " AND NOT EXISTS (" + earlier + ")"
+	args = append(args, earlierArgs...)
+	query += " ORDER BY id LIMIT ?"
+	args = append(args, batchSize)
+
+	rows, err := config.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanEvents(rows)
+}
+
+func (d *Dispatcher) send(ctx context.Context, event Event) error {
+	for _, sink := range d.Sinks {
+		if err := sink.Send(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) retry(ctx context.Context, event Event, cause error) error {
+	attempts := event.Attempts + 1
+	backoff := d.Backoff
+	if backoff == nil {
+		backoff = exponentialBackoff
+	}
+	next := time.Now().UTC().Add(backoff(attempts))
+	_, err := config.DB.ExecContext(ctx, "UPDATE "+Table+" SET attempts = ?, next_attempt_at = ?, last_error = ? WHERE id = ?",
+		attempts, next, cause.Error(), event.ID)
+	if err == nil && d.MaxAttempts > 0 && attempts >= d.MaxAttempts {
+		log.Printf("Evento %d descartado tras %d intentos[026-Dispatcher]: %v", event.ID, attempts, cause)
+	}
+	return err
+}
+
+func exponentialBackoff(attempts int) time.Duration {
+	if attempts > 10 {
+		attempts = 10
+	}
+	return time.Second << (attempts - 1)
+}
+
+// Failed returns the events that reached MaxAttempts without being delivered.
+func Failed(maxAttempts int) ([]Event, error) {
+	rows, err := config.DB.Query("SELECT id, table_name, action, record_id, payload, created_at, attempts FROM "+Table+
+		" WHERE delivered_at IS NULL AND attempts >= ? ORDER BY id", maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanEvents(rows)
+}
+
+func scanEvents(rows *sql.Rows) ([]Event, error) {
+	events := []Event{}
+	for rows.Next() {
+		var event Event
+		var payload string
+		if err := rows.Scan(&event.ID, &event.Table, &event.Action, &event.RecordID, &payload, &event.CreatedAt, &event.Attempts); err != nil {
+			return nil, err
+		}
+		event.Payload = []byte(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,108 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+// Table holds the events written with the changes, see CreateTable.
+var Table string = "s2s_outbox"
+
+// Event is a change of a row, stored in Table in the same transaction as the change.
+type Event struct {
+	ID        int64           `json:"id"`
+	Table     string          `json:"table"`
+	Action    string          `json:"action"`
+	RecordID  string          `json:"record_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	Attempts  int             `json:"attempts"`
+}
+
+// Payload is the json stored in Event.Payload.
+type Payload struct {
+	Table  string                 `json:"table"`
+	Action string                 `json:"action"`
+	ID     interface{}            `json:"id"`
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+	Actor  interface{}            `json:"actor,omitempty"`
+	Tenant interface{}            `json:"tenant,omitempty"`
+	Time   time.Time              `json:"time"`
+}
+
+var (
+	mu     sync.RWMutex
+	once   sync.Once
+	tables map[string]bool
+)
+
+// CreateTable creates Table if it does not exist.
+func CreateTable() error {
+	id := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	switch config.Dialect {
+	case config.DialectPostgres:
+		id = "id BIGSERIAL PRIMARY KEY"
+	case config.DialectMySQL:
+		id = "id BIGINT AUTO_INCREMENT PRIMARY KEY"
+	}
+	_, err := config.DB.Exec("CREATE TABLE IF NOT EXISTS " + Table + " (" + id + ", table_name VARCHAR(255) NOT NULL, " +
+		"action VARCHAR(16) NOT NULL, record_id VARCHAR(255) NOT NULL, payload TEXT NOT NULL, created_at TIMESTAMP NOT NULL, " +
+		"attempts INTEGER NOT NULL DEFAULT 0, next_attempt_at TIMESTAMP NOT NULL, delivered_at TIMESTAMP NULL, last_error TEXT)")
+	return err
+}
+
+// Enable appends an event to Table for every write on the tables names (every table when none is given),
+// inside the transaction of the write, so the event exists if and only if the change was committed.
+func Enable(names ...string) {
+	mu.Lock()
+	if len(names) == 0 {
+		tables = nil
+	} else {
+		if tables == nil {
+			tables = map[string]bool{}
+		}
+		for _, table := range names {
+			tables[table] = true
+		}
+	}
+	mu.Unlock()
+	once.Do(func() {
+		repositories.OnChangeTx(appendEvent)
+	})
+}
+
+func enabled(table string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return table != Table && table != repositories.AuditTable && (tables == nil || tables[table])
+}
+
+func appendEvent(ctx context.Context, tx *sql.Tx, change repositories.Change) error {
+	if !enabled(change.Table) {
+		return nil
+	}
+	payload, err := json.Marshal(Payload{
+		Table:  change.Table,
+		Action: change.Action,
+		ID:     change.ID,
+		Before: change.Before,
+		After:  change.After,
+		Actor:  change.Actor,
+		Tenant: change.Tenant,
+		Time:   change.Time,
+	})
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO " + Table + " (table_name, action, record_id, payload, created_at, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?)"
+	_, err = tx.ExecContext(ctx, query, change.Table, change.Action, fmt.Sprint(change.ID), string(payload), change.Time, change.Time)
+	return err
+}
@@ -0,0 +1,117 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Item struct {
+	ID   int    `json:"id" db:"id" s2s_table_name:"items"`
+	Name string `json:"name" db:"name"`
+}
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "outbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	Enable("items")
+
+	repo := repositories.NewRepository[Item]()
+	id, err := repo.Create(&Item{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Update(&Item{ID: int(*id), Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create(&Item{ID: 5, Name: "z"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a rolled back write leaves no event
+	repositories.CreateTxAndSet(repo)
+	repo.Create(&Item{Name: "c"})
+	repo.Rollback()
+
+	bus := NewBus()
+	received := []Event{}
+	failures := 1
+	bus.Subscribe(func(event Event) error {
+		if failures > 0 {
+			failures--
+			return errors.New("temporary error")
+		}
+		received = append(received, event)
+		return nil
+	})
+	file := &File{Path: filepath.Join(dir, "events.ndjson")}
+	dispatcher := NewDispatcher(bus, file)
+	dispatcher.Backoff = func(int) time.Duration { return 0 }
+
+	// the update of the first item waits for its failed create, item 5 is not blocked
+	if n, err := dispatcher.DispatchOnce(context.Background()); err != nil || n != 1 {
+		t.Fatal("expected only the event of item 5 delivered after the first failure, got", n, err)
+	}
+	if n, _ := dispatcher.DispatchOnce(context.Background()); n != 2 {
+		t.Fatal("the failed event must be retried before the update, got", n)
+	}
+	if n, _ := dispatcher.DispatchOnce(context.Background()); n != 0 {
+		t.Error("delivered events must not be sent again, got", n)
+	}
+
+	if len(received) != 3 || received[0].RecordID != "5" || received[1].Action != repositories.ChangeCreate || received[2].Action != repositories.ChangeUpdate {
+		t.Fatalf("unexpected events %+v", received)
+	}
+	payload := Payload{}
+	json.Unmarshal(received[2].Payload, &payload)
+	if payload.Table != "items" || payload.After["name"] != "b" || payload.Before["name"] != "a" {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+	// while the failed event waits for its backoff, the later events of the record wait too
+	repo = repositories.NewRepository[Item]()
+	if _, err := repo.Create(&Item{ID: 7, Name: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Update(&Item{ID: 7, Name: "y"}); err != nil {
+		t.Fatal(err)
+	}
+	failures = 1
+	dispatcher.Backoff = func(int) time.Duration { return time.Hour }
+	for i := 0; i < 2; i++ {
+		if n, _ := dispatcher.DispatchOnce(context.Background()); n != 0 {
+			t.Fatal("the update must wait for the create, got", n)
+		}
+	}
+	if _, err := db.Exec("UPDATE "+Table+" SET next_attempt_at = ?", time.Now().UTC().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := dispatcher.DispatchOnce(context.Background()); n != 2 || received[4].Action != repositories.ChangeUpdate {
+		t.Fatal("expected the create and then the update, got", n)
+	}
+
+	lines, _ := os.ReadFile(file.Path)
+	if strings.Count(string(lines), "\n") != 5 {
+		t.Error("expected 5 lines in the file sink", string(lines))
+	}
+}
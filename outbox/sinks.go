@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Sink delivers events. Send may be called more than once for the same event (at-least-once),
+// so consumers should use Event.ID to discard duplicates.
+type Sink interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(ctx context.Context, event Event) error
+
+func (f SinkFunc) Send(ctx context.Context, event Event) error {
+	return f(ctx, event)
+}
+
+// Bus is an in-process sink that calls its subscribers in order; the first error stops the delivery.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []func(event Event) error
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe adds fn to the subscribers of the bus.
+func (b *Bus) Subscribe(fn func(event Event) error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers = append(b.subscribers, fn)
+}
+
+func (b *Bus) Send(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	subscribers := append([]func(event Event) error{}, b.subscribers...)
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Webhook posts every event as json to URL; any status other than 2xx is an error.
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func (w Webhook) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", fmt.Sprint(event.ID))
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", w.URL, res.Status)
+	}
+	return nil
+}
+
+// File appends every event as a json line to Path.
+type File struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (f *File) Send(ctx context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,56 @@
+package policies
+
+import "errors"
+
+// ErrForbidden is the usual error returned by Authorize to deny an action.
+var ErrForbidden = errors.New("forbidden")
+
+type Action string
+
+const (
+	ActionList   Action = "list"
+	ActionGet    Action = "get"
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Policy decides what a principal may do on a resource. The principal is whatever the application
+// stores in the request (see handlers.PrincipalKey), nil for anonymous requests.
+type Policy[T any] interface {
+	// Authorize returns a non nil error to deny action. item is nil for list and bulk delete.
+	Authorize(principal interface{}, action Action, item *T) error
+	// Scope returns criteria (as in GetByCriteria) that limits the rows visible for action; "" means every row.
+	Scope(principal interface{}, action Action) (string, []interface{})
+}
+
+// Funcs builds a Policy from functions; a nil function allows everything.
+type Funcs[T any] struct {
+	AuthorizeFunc func(principal interface{}, action Action, item *T) error
+	ScopeFunc     func(principal interface{}, action Action) (string, []interface{})
+}
+
+func (p Funcs[T]) Authorize(principal interface{}, action Action, item *T) error {
+	if p.AuthorizeFunc == nil {
+		return nil
+	}
+	return p.AuthorizeFunc(principal, action, item)
+}
+
+func (p Funcs[T]) Scope(principal interface{}, action Action) (string, []interface{}) {
+	if p.ScopeFunc == nil {
+		return "", nil
+	}
+	return p.ScopeFunc(principal, action)
+}
+
+// Combine joins criteria and scope with AND, either of them may be empty.
+func Combine(criteria string, args []interface{}, scope string, scopeArgs []interface{}) (string, []interface{}) {
+	if scope == "" {
+		return criteria, args
+	}
+	if criteria == "" {
+		return scope, scopeArgs
+	}
+	return "(" + criteria + ") AND (" + scope + ")", append(append([]interface{}{}, args...), scopeArgs...)
+}
@@ -0,0 +1,269 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+var ErrUnknownAggregate = errors.New("unknown aggregate function")
+
+// Aggregate describes one aggregated column of a GroupBy query, e.g. {Func: "sum", Column: "amount"}.
+// Alias defaults to func_column ("count" for count(*)).
+type Aggregate struct {
+	Func   string `json:"func"`
+	Column string `json:"column"`
+	Alias  string `json:"alias"`
+}
+
+func (a Aggregate) name() string {
+	if a.Alias != "" {
+		return a.Alias
+	}
+	if a.Column == "" || a.Column == "*" {
+		return strings.ToLower(a.Func)
+	}
+	return strings.ToLower(a.Func) + "_" + a.Column
+}
+
+var aggregateFuncs = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// Count returns the number of rows matching criteria (every row when criteria is empty).
+func (r *Repository[T]) Count(criteria string, args ...interface{}) (int64, error) {
+	var count int64
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return 0, err
+	}
+	err = r.queryScalar("SELECT COUNT(*) FROM "+r.table+where, args, &count)
+	return count, err
+}
+
+// Exists reports whether at least one row matches criteria. EXISTS is a boolean on PostgreSQL and 0 or 1
+// on SQLite and MySQL, database/sql converts both.
+func (r *Repository[T]) Exists(criteria string, args ...interface{}) (bool, error) {
+	var exists bool
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return false, err
+	}
+	err = r.queryScalar("SELECT EXISTS (SELECT 1 FROM "+r.table+where+")", args, &exists)
+	return exists, err
+}
+
+func (r *Repository[T]) Sum(column string, criteria string, args ...interface{}) (float64, error) {
+	return r.aggregate("SUM", column, criteria, args...)
+}
+
+func (r *Repository[T]) Avg(column string, criteria string, args ...interface{}) (float64, error) {
+	return r.aggregate("AVG", column, criteria, args...)
+}
+
+func (r *Repository[T]) Min(column string, criteria string, args ...interface{}) (float64, error) {
+	return r.aggregate("MIN", column, criteria, args...)
+}
+
+func (r *Repository[T]) Max(column string, criteria string, args ...interface{}) (float64, error) {
+	return r.aggregate("MAX", column, criteria, args...)
+}
+
+func (r *Repository[T]) aggregate(fn string, column string, criteria string, args ...interface{}) (float64, error) {
+	if _, ok := r.tagName[column]; !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+	}
+	var value sql.NullFloat64
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return 0, err
+	}
+	err = r.queryScalar("SELECT "+fn+"("+column+") FROM "+r.table+where, args, &value)
+	return value.Float64, err
+}
+
+func (r *Repository[T]) queryScalar(query string, args []interface{}, dest interface{}) error {
+	conn, _, err := r.getInternalTxOrConn()
+	if err != nil {
+		return err
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+	if config.FlagLog {
+		log.Println(query, args)
+	}
+	var row *sql.Row
+	if r.tx != nil {
+		row = r.tx.QueryRowContext(r.ctx, query, args...)
+	} else {
+		row = conn.QueryRowContext(r.ctx, query, args...)
+	}
+	err = row.Scan(dest)
+	if err != nil && config.FlagLog {
+		log.Printf("Error al ejecutar la consulta[016-Aggregate]: %v", err)
+	}
+	return err
+}
+
+// GroupBy runs the aggregates grouped by the groupBy columns and returns one map per group,
+// keyed by column name and aggregate alias.
+func (r *Repository[T]) GroupBy(groupBy []string, aggregates []Aggregate, criteria string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, conn, err := r.queryGroupBy(groupBy, aggregates, criteria, args)
+	if err != nil {
+		return nil, err
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			m[column] = values[i]
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// GroupByAs works like GroupBy but scans each group into R, matching result columns with the db tags of R.
+func GroupByAs[R any, T any](r *Repository[T], groupBy []string, aggregates []Aggregate, criteria string, args ...interface{}) ([]*R, error) {
+	rows, conn, err := r.queryGroupBy(groupBy, aggregates, criteria, args)
+	if err != nil {
+		return nil, err
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	rowType := reflect.TypeOf((*R)(nil)).Elem()
+	fieldByColumn := map[string]string{}
+	for i := 0; i < rowType.NumField(); i++ {
+		if tag := rowType.Field(i).Tag.Get("db"); tag != "" {
+			fieldByColumn[tag] = rowType.Field(i).Name
+		}
+	}
+
+	result := []*R{}
+	for rows.Next() {
+		item := CreateNewElement[R]()
+		itemValue := reflect.ValueOf(item).Elem()
+		pointers := make([]interface{}, len(columns))
+		for i, column := range columns {
+			if name, ok := fieldByColumn[column]; ok {
+				pointers[i] = itemValue.FieldByName(name).Addr().Interface()
+				continue
+			}
+			var discard interface{}
+			pointers[i] = &discard
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, rows.Err()
+}
+
+func (r *Repository[T]) queryGroupBy(groupBy []string, aggregates []Aggregate, criteria string, args []interface{}) (*sql.Rows, *sql.Conn, error) {
+	selects := []string{}
+	for _, column := range groupBy {
+		if _, ok := r.tagName[column]; !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+		}
+		selects = append(selects, column)
+	}
+	for _, a := range aggregates {
+		fn := strings.ToLower(a.Func)
+		if !aggregateFuncs[fn] {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownAggregate, a.Func)
+		}
+		column := a.Column
+		if column == "" || column == "*" {
+			if fn != "count" {
+				return nil, nil, fmt.Errorf("%w: %s requires a column", ErrUnknownColumn, fn)
+			}
+			column = "*"
+		} else if _, ok := r.tagName[column]; !ok {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+		}
+		alias := a.name()
+		if !isIdentifier(alias) {
+			return nil, nil, fmt.Errorf("%w: invalid alias %s", ErrUnknownColumn, alias)
+		}
+		selects = append(selects, strings.ToUpper(fn)+"("+column+") AS "+alias)
+	}
+	if len(selects) == 0 {
+		return nil, nil, ErrEmptySet
+	}
+
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := "SELECT " + strings.Join(selects, ", ") + " FROM " + r.table + where
+	if len(groupBy) > 0 {
+		query += " GROUP BY " + strings.Join(groupBy, ", ")
+	}
+	if config.FlagLog {
+		log.Println(query, args)
+	}
+
+	conn, _, err := r.getInternalTxOrConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows *sql.Rows
+	if r.tx != nil {
+		rows, err = r.tx.QueryContext(r.ctx, query, args...)
+	} else {
+		rows, err = conn.QueryContext(r.ctx, query, args...)
+	}
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		log.Printf("Error al ejecutar la consulta[017-GroupBy]: %v", err)
+		return nil, nil, err
+	}
+	return rows, conn, nil
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// AuditTable is the table written by the audit log, see CreateAuditTable.
+var AuditTable string = "s2s_audit"
+
+// Revision is a row of the audit log. Before and After hold the changed columns only.
+type Revision struct {
+	ID        int64                  `json:"id"`
+	Table     string                 `json:"table"`
+	RecordID  string                 `json:"record_id"`
+	Action    string                 `json:"action"`
+	Actor     string                 `json:"actor"`
+	Tenant    string                 `json:"tenant,omitempty"`
+	ChangedAt time.Time              `json:"changed_at"`
+	Before    map[string]interface{} `json:"before"`
+	After     map[string]interface{} `json:"after"`
+}
+
+var (
+	auditMu     sync.RWMutex
+	auditOnce   sync.Once
+	auditTables map[string]bool
+)
+
+// CreateAuditTable creates AuditTable if it does not exist.
+func CreateAuditTable() error {
+	id := "id INTEGER PRIMARY KEY AUTOINCREMENT"
+	switch config.Dialect {
+	case config.DialectPostgres:
+		id = "id BIGSERIAL PRIMARY KEY"
+	case config.DialectMySQL:
+		id = "id BIGINT AUTO_INCREMENT PRIMARY KEY"
+	}
+	_, err := config.DB.Exec("CREATE TABLE IF NOT EXISTS " + AuditTable + " (" + id + ", table_name VARCHAR(255) NOT NULL, " +
+		"record_id VARCHAR(255) NOT NULL, action VARCHAR(16) NOT NULL, actor VARCHAR(255), tenant VARCHAR(255), " +
+		"changed_at TIMESTAMP NOT NULL, before_value TEXT, after_value TEXT)")
+	return err
+}
+
+// EnableAudit records every create, update and delete on tables (every table when none is given)
+// in AuditTable, inside the transaction of the write.
+func EnableAudit(tables ...string) {
+	auditMu.Lock()
+	if len(tables) == 0 {
+		auditTables = nil
+	} else {
+		if auditTables == nil {
+			auditTables = map[string]bool{}
+		}
+		for _, table := range tables {
+			auditTables[table] = true
+		}
+	}
+	auditMu.Unlock()
+	auditOnce.Do(func() {
+		OnChangeTx(writeRevision)
+	})
+}
+
+func audited(table string) bool {
+	auditMu.RLock()
+	defer auditMu.RUnlock()
+	return table != AuditTable && (auditTables == nil || auditTables[table])
+}
+
+func writeRevision(ctx context.Context, tx *sql.Tx, change Change) error {
+	if !audited(change.Table) {
+		return nil
+	}
+	before, after := diff(change.Before, change.After)
+	beforeJSON, err := jsonOrNull(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := jsonOrNull(after)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO " + AuditTable + " (table_name, record_id, action, actor, tenant, changed_at, before_value, after_value) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	_, err = tx.ExecContext(ctx, query, change.Table, fmt.Sprint(change.ID), change.Action, stringOrNull(change.Actor),
+		stringOrNull(change.Tenant), change.Time, beforeJSON, afterJSON)
+	if err != nil {
+		log.Printf("Error al registrar la auditoria[024-Audit]: %v", err)
+	}
+	return err
+}
+
+// diff keeps only the columns that changed; on create and delete the whole row is kept.
+func diff(before map[string]interface{}, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	if before == nil || after == nil {
+		return before, after
+	}
+	b := map[string]interface{}{}
+	a := map[string]interface{}{}
+	for column, value := range after {
+		if !reflect.DeepEqual(before[column], value) {
+			b[column] = before[column]
+			a[column] = value
+		}
+	}
+	return b, a
+}
+
+func jsonOrNull(v map[string]interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func stringOrNull(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	return fmt.Sprint(v)
+}
+
+// Revisions returns the audit rows matching criteria (as in GetByCriteria, over the columns of AuditTable)
+// ordered from the oldest to the newest.
+func Revisions(criteria string, args ...interface{}) ([]Revision, error) {
+	return revisions(context.Background(), nil, criteria, args...)
+}
+
+// History returns the revisions of the row with id, limited to the tenant of the repository.
+func (r *Repository[T]) History(id interface{}) ([]Revision, error) {
+	criteria := "table_name = ? AND record_id = ?"
+	args := []interface{}{r.table, fmt.Sprint(id)}
+	tenant, active, err := r.tenant()
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		criteria += " AND tenant = ?"
+		args = append(args, fmt.Sprint(tenant))
+	}
+	return revisions(r.ctx, r.tx, criteria, args...)
+}
+
+func revisions(ctx context.Context, tx *sql.Tx, criteria string, args ...interface{}) ([]Revision, error) {
+	query := "SELECT id, table_name, record_id, action, actor, tenant, changed_at, before_value, after_value FROM " +
+		AuditTable + whereClause(criteria) + " ORDER BY id"
+	var rows *sql.Rows
+	var err error
+	if tx != nil {
+		rows, err = tx.QueryContext(ctx, query, args...)
+	} else {
+		rows, err = config.DB.QueryContext(ctx, query, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Revision{}
+	for rows.Next() {
+		var revision Revision
+		var actor, tenant, before, after sql.NullString
+		err := rows.Scan(&revision.ID, &revision.Table, &revision.RecordID, &revision.Action, &actor, &tenant,
+			&revision.ChangedAt, &before, &after)
+		if err != nil {
+			return nil, err
+		}
+		revision.Actor = actor.String
+		revision.Tenant = tenant.String
+		if before.Valid {
+			if err := json.Unmarshal([]byte(before.String), &revision.Before); err != nil {
+				return nil, err
+			}
+		}
+		if after.Valid {
+			if err := json.Unmarshal([]byte(after.String), &revision.After); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, revision)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,278 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// ErrMixedIDs is returned by CreateMany on MySQL when only some items carry an id, as their generated ids
+// can not be told apart.
+var ErrMixedIDs = errors.New("items mix explicit and generated ids")
+
+// CreateMany inserts items with multi-row INSERT statements sized by config.MaxParams and returns the generated ids.
+// All batches run in r.tx or, when there is none, in a new transaction.
+func (r *Repository[T]) CreateMany(items []*T) ([]int64, error) {
+	if r.tracks() {
+		var ids []int64
+		err := r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackIDs(nil, func() ([]interface{}, error) {
+				var err error
+				ids, err = repo.CreateMany(items)
+				created := make([]interface{}, len(ids))
+				for i, id := range ids {
+					created[i] = id
+				}
+				return created, err
+			})
+		})
+		return ids, err
+	}
+	ids := make([]int64, 0, len(items))
+	if len(items) == 0 || len(r.tags) == 0 {
+		return ids, nil
+	}
+
+	for _, item := range items {
+		if err := r.fillTenant(item); err != nil {
+			return nil, err
+		}
+	}
+	batchSize := r.batchSize()
+	err := r.runInTx(func(tx *sql.Tx) error {
+		for start := 0; start < len(items); start += batchSize {
+			end := start + batchSize
+			if end > len(items) {
+				end = len(items)
+			}
+			batch := items[start:end]
+			query, fieldsValues, err := r.insertBatchSQL(batch)
+			if err != nil {
+				return err
+			}
+			if config.FlagLog {
+				log.Println(query, fieldsValues)
+			}
+
+			batchIDs, err := r.insertBatch(tx, query, batch, fieldsValues)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, batchIDs...)
+		}
+		return nil
+	})
+	if err != nil {
+		if config.FlagLog {
+			log.Printf("Error al insertar los items[010-CreateMany]: %v", err)
+		}
+		return nil, err
+	}
+	return ids, nil
+}
+
+// insertBatchSQL builds a multi-row INSERT for items and returns it with its arguments.
+func (r *Repository[T]) insertBatchSQL(items []*T) (string, []interface{}, error) {
+	rowPlaceholder := "(" + placeholders(len(r.tags)) + ")"
+	rows := make([]string, 0, len(items))
+	fieldsValues := make([]interface{}, 0, len(items)*len(r.tags))
+	for _, item := range items {
+		values, err := r.fieldsValues(item)
+		if err != nil {
+			return "", nil, err
+		}
+		rows = append(rows, rowPlaceholder)
+		fieldsValues = append(fieldsValues, values...)
+	}
+	return "INSERT INTO " + r.table + " (" + strings.Join(r.tags, ", ") + ") VALUES " + strings.Join(rows, ", "), fieldsValues, nil
+}
+
+// batchSize returns how many rows fit in one statement for the current dialect.
+func (r *Repository[T]) batchSize() int {
+	if len(r.tags) == 0 {
+		return 1
+	}
+	size := config.MaxParams() / len(r.tags)
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// insertBatch runs a multi-row INSERT and returns the ids of its rows. PostgreSQL and SQLite return them with
+// RETURNING. MySQL has no RETURNING: the ids are the ones carried by the items or, when no item has one, the
+// consecutive ids from LastInsertId, which holds for innodb_autoinc_lock_mode 0 and 1 but not for 2.
+func (r *Repository[T]) insertBatch(tx *sql.Tx, query string, items []*T, fieldsValues []interface{}) ([]int64, error) {
+	ids := make([]int64, 0, len(items))
+	if config.Dialect != config.DialectMySQL {
+		rows, err := tx.QueryContext(r.ctx, query+" RETURNING id", fieldsValues...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, rows.Err()
+	}
+
+	explicit := 0
+	for _, item := range items {
+		if id, ok := r.explicitID(item); ok {
+			ids = append(ids, id)
+			explicit++
+		}
+	}
+	if explicit > 0 && explicit < len(items) {
+		return nil, ErrMixedIDs
+	}
+	result, err := tx.ExecContext(r.ctx, query, fieldsValues...)
+	if err != nil || explicit > 0 {
+		return ids, err
+	}
+	// MySQL reports the first id of a multi-row insert
+	first, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		ids = append(ids, first+int64(i))
+	}
+	return ids, nil
+}
+
+// explicitID returns the id set on item, if it is a non zero integer.
+func (r *Repository[T]) explicitID(item *T) (int64, bool) {
+	value := reflect.ValueOf(r.idValue(item))
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), value.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint()), value.Uint() != 0
+	}
+	return 0, false
+}
+
+// UpdateMany updates every item by id inside a single transaction.
+func (r *Repository[T]) UpdateMany(items []*T) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if r.tracks() {
+		ids := make([]interface{}, len(items))
+		for i, item := range items {
+			ids[i] = r.idValue(item)
+		}
+		return r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackIDs(ids, func() ([]interface{}, error) {
+				return nil, repo.UpdateMany(items)
+			})
+		})
+	}
+	query, tenantArgs, err := r.byTenant(r.updateSQL())
+	if err != nil {
+		return err
+	}
+	err = r.runInTx(func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(r.ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, item := range items {
+			if err := r.fillTenant(item); err != nil {
+				return err
+			}
+			fieldsValues, err := r.columnsValues(item, r.updateColumns())
+			if err != nil {
+				return err
+			}
+			fieldsValues = append(fieldsValues, r.idValue(item))
+			fieldsValues = append(fieldsValues, tenantArgs...)
+			if _, err := stmt.ExecContext(r.ctx, fieldsValues...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error al actualizar los items[011-UpdateMany]: %v", err)
+	}
+	return err
+}
+
+// DeleteMany deletes the rows with the given ids using batched IN clauses inside a single transaction.
+func (r *Repository[T]) DeleteMany(ids []interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if r.tracks() {
+		return r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackIDs(ids, func() ([]interface{}, error) {
+				return nil, repo.DeleteMany(ids)
+			})
+		})
+	}
+	_, tenantArgs, err := r.byTenant("")
+	if err != nil {
+		return err
+	}
+	batchSize := config.MaxParams() - len(tenantArgs)
+	err = r.runInTx(func(tx *sql.Tx) error {
+		for start := 0; start < len(ids); start += batchSize {
+			end := start + batchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			batch := ids[start:end]
+			query, args, _ := r.byTenant("DELETE FROM "+r.table+" WHERE id IN ("+placeholders(len(batch))+")", batch...)
+			if _, err := tx.ExecContext(r.ctx, query, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error al eliminar los items[012-DeleteMany]: %v", err)
+	}
+	return err
+}
+
+// runInTx runs fn in r.tx, or in a new transaction that is committed when fn succeeds.
+func (r *Repository[T]) runInTx(fn func(tx *sql.Tx) error) error {
+	if r.tx != nil {
+		if err := fn(r.tx); err != nil {
+			err1 := r.Rollback()
+			if err1 != nil {
+				return err1
+			}
+			return err
+		}
+		return nil
+	}
+
+	tx, err := config.DB.BeginTx(r.ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
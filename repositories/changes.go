@@ -0,0 +1,336 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Change is a row written through a repository. Before and After hold the stored columns
+// (encrypted columns stay encrypted); Before is nil on create and After is nil on delete.
+type Change struct {
+	Table  string
+	Action string
+	ID     interface{}
+	Before map[string]interface{}
+	After  map[string]interface{}
+	Actor  interface{}
+	Tenant interface{}
+	Time   time.Time
+}
+
+// TxHook runs inside the transaction of the write; returning an error rolls the write back.
+type TxHook func(ctx context.Context, tx *sql.Tx, change Change) error
+
+// CommitHook runs once the transaction of the write has been committed.
+type CommitHook func(change Change)
+
+var (
+	hooksMu     sync.RWMutex
+	txHooks     []TxHook
+	commitHooks []CommitHook
+
+	pendingMu sync.Mutex
+	pending   = map[*sql.Tx][]Change{}
+)
+
+// OnChangeTx registers hook for every write of every repository.
+func OnChangeTx(hook TxHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	txHooks = append(txHooks, hook)
+}
+
+// OnChangeCommit registers hook for every committed write. Writes made in a transaction set with SetTx
+// are notified when the transaction is committed with CommitTx or the Commit method of a repository.
+func OnChangeCommit(hook CommitHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	commitHooks = append(commitHooks, hook)
+}
+
+func hasHooks() bool {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return len(txHooks) > 0 || len(commitHooks) > 0
+}
+
+type actorKey struct{}
+
+// WithActor returns a context whose writes are recorded as made by actor.
+func WithActor(ctx context.Context, actor interface{}) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor.
+func ActorFromContext(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(actorKey{})
+}
+
+// tracks reports whether writes must capture their changes for the hooks.
+func (r *Repository[T]) tracks() bool {
+	return !r.capturing && hasHooks()
+}
+
+// withChanges runs fn in r.tx or in a new transaction, passes the changes it returns to the tx hooks
+// and, once committed, to the commit hooks.
+func (r *Repository[T]) withChanges(fn func(repo *Repository[T]) ([]Change, error)) error {
+	repo := *r
+	repo.capturing = true
+	if r.tx == nil {
+		tx, err := config.DB.BeginTx(r.ctx, nil)
+		if err != nil {
+			return err
+		}
+		repo.tx = tx
+	}
+	changes, err := fn(&repo)
+	if err == nil {
+		err = runTxHooks(repo.ctx, repo.tx, changes)
+	}
+	if err != nil {
+		// the write may have already rolled back the transaction
+		repo.tx.Rollback()
+		if r.tx != nil {
+			discardPending(r.tx)
+		}
+		return err
+	}
+	if r.tx != nil {
+		pendingMu.Lock()
+		pending[r.tx] = append(pending[r.tx], changes...)
+		pendingMu.Unlock()
+		return nil
+	}
+	if err := repo.tx.Commit(); err != nil {
+		return err
+	}
+	runCommitHooks(changes)
+	return nil
+}
+
+func runTxHooks(ctx context.Context, tx *sql.Tx, changes []Change) error {
+	hooksMu.RLock()
+	hooks := append([]TxHook{}, txHooks...)
+	hooksMu.RUnlock()
+	for _, change := range changes {
+		for _, hook := range hooks {
+			if err := hook(ctx, tx, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runCommitHooks(changes []Change) {
+	hooksMu.RLock()
+	hooks := append([]CommitHook{}, commitHooks...)
+	hooksMu.RUnlock()
+	for _, change := range changes {
+		for _, hook := range hooks {
+			hook(change)
+		}
+	}
+}
+
+// CommitTx commits tx and runs the commit hooks of the writes made in it through repositories.
+// A transaction set with SetTx must be ended with CommitTx or RollbackTx (or the Commit and Rollback
+// methods of a repository): the changes of its writes are held until then, and calling tx.Commit
+// directly skips the hooks and keeps them in memory.
+func CommitTx(tx *sql.Tx) error {
+	if err := tx.Commit(); err != nil {
+		discardPending(tx)
+		return err
+	}
+	committed(tx)
+	return nil
+}
+
+// RollbackTx rolls tx back and drops the changes held for the commit hooks.
+func RollbackTx(tx *sql.Tx) error {
+	discardPending(tx)
+	return tx.Rollback()
+}
+
+// committed runs the commit hooks of the writes made in tx.
+func committed(tx *sql.Tx) {
+	pendingMu.Lock()
+	changes := pending[tx]
+	delete(pending, tx)
+	pendingMu.Unlock()
+	runCommitHooks(changes)
+}
+
+func discardPending(tx *sql.Tx) {
+	pendingMu.Lock()
+	delete(pending, tx)
+	pendingMu.Unlock()
+}
+
+// snapshot returns the stored columns of the rows matching criteria, keyed by id.
+func (r *Repository[T]) snapshot(criteria string, args ...interface{}) (map[string]map[string]interface{}, []interface{}, error) {
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := r.tx.QueryContext(r.ctx, "SELECT "+strings.Join(r.tags, ", ")+" FROM "+r.table+where, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	idColumn := r.idColumn()
+	result := map[string]map[string]interface{}{}
+	ids := []interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(r.tags))
+		pointers := make([]interface{}, len(r.tags))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		row := make(map[string]interface{}, len(r.tags))
+		for i, tag := range r.tags {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[tag] = values[i]
+		}
+		ids = append(ids, row[idColumn])
+		result[fmt.Sprint(row[idColumn])] = row
+	}
+	return result, ids, rows.Err()
+}
+
+// snapshotIDs works like snapshot for the rows with ids, in batches sized by config.MaxParams.
+func (r *Repository[T]) snapshotIDs(ids []interface{}) (map[string]map[string]interface{}, error) {
+	result := map[string]map[string]interface{}{}
+	batchSize := config.MaxParams() - 1
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		rows, _, err := r.snapshot(r.idColumn()+" IN ("+placeholders(end-start)+")", ids[start:end]...)
+		if err != nil {
+			return nil, err
+		}
+		for id, row := range rows {
+			result[id] = row
+		}
+	}
+	return result, nil
+}
+
+// changesOf compares the rows before and after a write; rows only in before are deleted,
+// rows only in after are created and rows in both are updated when a column changed.
+func (r *Repository[T]) changesOf(before map[string]map[string]interface{}, after map[string]map[string]interface{}) []Change {
+	tenant, _, _ := r.tenant()
+	now := time.Now().UTC()
+	change := func(action string, before, after map[string]interface{}) Change {
+		row := after
+		if row == nil {
+			row = before
+		}
+		return Change{
+			Table:  r.table,
+			Action: action,
+			ID:     row[r.idColumn()],
+			Before: before,
+			After:  after,
+			Actor:  ActorFromContext(r.ctx),
+			Tenant: tenant,
+			Time:   now,
+		}
+	}
+
+	ids := make([]string, 0, len(before)+len(after))
+	for id := range after {
+		ids = append(ids, id)
+	}
+	for id := range before {
+		if _, ok := after[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	// numeric ids in ascending order, so the changes follow the order of the writes
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+
+	changes := []Change{}
+	for _, id := range ids {
+		old, hadRow := before[id]
+		row, hasRow := after[id]
+		switch {
+		case !hadRow:
+			changes = append(changes, change(ChangeCreate, nil, row))
+		case !hasRow:
+			changes = append(changes, change(ChangeDelete, old, nil))
+		case !reflect.DeepEqual(old, row):
+			changes = append(changes, change(ChangeUpdate, old, row))
+		}
+	}
+	return changes
+}
+
+// trackIDs runs write between two snapshots of the rows with ids.
+func (r *Repository[T]) trackIDs(ids []interface{}, write func() ([]interface{}, error)) ([]Change, error) {
+	before, err := r.snapshotIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	created, err := write()
+	if err != nil {
+		return nil, err
+	}
+	after, err := r.snapshotIDs(append(append([]interface{}{}, ids...), created...))
+	if err != nil {
+		return nil, err
+	}
+	return r.changesOf(before, after), nil
+}
+
+// trackWhere runs write between two snapshots of the rows matching criteria. With reselect the second
+// snapshot runs criteria again, so rows created by write are included.
+func (r *Repository[T]) trackWhere(criteria string, args []interface{}, reselect bool, write func() error) ([]Change, error) {
+	before, ids, err := r.snapshot(criteria, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := write(); err != nil {
+		return nil, err
+	}
+	var after map[string]map[string]interface{}
+	if reselect {
+		after, _, err = r.snapshot(criteria, args...)
+	} else {
+		after, err = r.snapshotIDs(ids)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.changesOf(before, after), nil
+}
@@ -0,0 +1,166 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+var (
+	ErrMissingCriteria = errors.New("criteria is required, use UpdateAll or DeleteAll to affect every row")
+	ErrEmptySet        = errors.New("set has no columns")
+	ErrUnknownColumn   = errors.New("unknown column")
+)
+
+// whereClause turns criteria into " WHERE criteria", keeping a leading WHERE if present.
+func whereClause(criteria string) string {
+	criteria = strings.TrimSpace(criteria)
+	if criteria == "" {
+		return ""
+	}
+	if strings.HasPrefix(strings.ToLower(criteria), "where") {
+		return " " + criteria
+	}
+	return " WHERE " + criteria
+}
+
+// UpdateWhere sets the columns in set on every row matching criteria and returns the affected rows.
+// Criteria is required, see UpdateAll.
+func (r *Repository[T]) UpdateWhere(set map[string]interface{}, criteria string, args ...interface{}) (int64, error) {
+	if err := requireCriteria(criteria); err != nil {
+		return 0, err
+	}
+	return r.updateWhere(set, criteria, args...)
+}
+
+// UpdateAll sets the columns in set on every row of the table and returns the affected rows.
+func (r *Repository[T]) UpdateAll(set map[string]interface{}) (int64, error) {
+	return r.updateWhere(set, "")
+}
+
+func (r *Repository[T]) updateWhere(set map[string]interface{}, criteria string, args ...interface{}) (int64, error) {
+	if len(set) == 0 {
+		return 0, ErrEmptySet
+	}
+	if r.tracks() {
+		var n int64
+		err := r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackWhere(criteria, args, false, func() (err error) {
+				n, err = repo.updateWhere(set, criteria, args...)
+				return err
+			})
+		})
+		return n, err
+	}
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	columns := make([]string, 0, len(set))
+	for column := range set {
+		if _, ok := r.tagName[column]; !ok {
+			return 0, fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+		}
+		if column == r.tenantColumn && !IsTenantBypassed(r.ctx) {
+			return 0, ErrTenantColumn
+		}
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	sets := make([]string, 0, len(columns))
+	values := make([]interface{}, 0, len(columns)+len(args))
+	for _, column := range columns {
+		value, err := r.encryptValue(column, set[column])
+		if err != nil {
+			return 0, err
+		}
+		sets = append(sets, column+" = ?")
+		values = append(values, value)
+	}
+	values = append(values, args...)
+
+	query := "UPDATE " + r.table + " SET " + strings.Join(sets, ", ") + where
+	n, err := r.execAffected(query, values...)
+	if err != nil {
+		log.Printf("Error al actualizar los items[014-UpdateWhere]: %v", err)
+	}
+	return n, err
+}
+
+// DeleteWhere deletes every row matching criteria and returns the affected rows. Criteria is required, see DeleteAll.
+func (r *Repository[T]) DeleteWhere(criteria string, args ...interface{}) (int64, error) {
+	if err := requireCriteria(criteria); err != nil {
+		return 0, err
+	}
+	return r.deleteWhere(criteria, args...)
+}
+
+// DeleteAll deletes every row of the table and returns the affected rows.
+func (r *Repository[T]) DeleteAll() (int64, error) {
+	return r.deleteWhere("")
+}
+
+func (r *Repository[T]) deleteWhere(criteria string, args ...interface{}) (int64, error) {
+	if r.tracks() {
+		var n int64
+		err := r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackWhere(criteria, args, false, func() (err error) {
+				n, err = repo.deleteWhere(criteria, args...)
+				return err
+			})
+		})
+		return n, err
+	}
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := r.execAffected("DELETE FROM "+r.table+where, args...)
+	if err != nil {
+		log.Printf("Error al eliminar los items[015-DeleteWhere]: %v", err)
+	}
+	return n, err
+}
+
+// requireCriteria rejects a blank criteria, or a bare WHERE, which would affect every row.
+func requireCriteria(criteria string) error {
+	if strings.TrimSpace(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(criteria)), "where")) == "" {
+		return ErrMissingCriteria
+	}
+	return nil
+}
+
+func (r *Repository[T]) execAffected(query string, args ...interface{}) (int64, error) {
+	conn, _, err := r.getInternalTxOrConn()
+	if err != nil {
+		return 0, err
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+	if config.FlagLog {
+		log.Println(query, args)
+	}
+
+	var result sql.Result
+	if r.tx != nil {
+		result, err = r.tx.ExecContext(r.ctx, query, args...)
+	} else {
+		result, err = conn.ExecContext(r.ctx, query, args...)
+	}
+	if err != nil {
+		err1 := r.Rollback()
+		if err1 != nil {
+			return 0, err1
+		}
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,148 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+
+	"github.com/arturoeanton/go-struct2serve/encryption"
+)
+
+// S2S_ENCRYPT marks db fields stored encrypted: s2s_encrypt:"true" uses a random nonce,
+// s2s_encrypt:"deterministic" gives equal ciphertexts for equal values so "column = ?" criteria still work.
+var S2S_ENCRYPT string = "s2s_encrypt"
+
+var ErrEncryptType = errors.New("only string and *string fields can be encrypted")
+
+// encryptedColumns returns the encrypted columns of itemType and whether they are deterministic.
+func encryptedColumns(itemType reflect.Type) map[string]bool {
+	columns := map[string]bool{}
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		mode := field.Tag.Get(S2S_ENCRYPT)
+		if mode == "" || field.Tag.Get("db") == "" {
+			continue
+		}
+		columns[field.Tag.Get("db")] = mode == "deterministic"
+	}
+	return columns
+}
+
+// encryptValue encrypts the value of column when it is an encrypted column.
+func (r *Repository[T]) encryptValue(column string, value interface{}) (interface{}, error) {
+	deterministic, ok := r.encrypted[column]
+	if !ok {
+		return value, nil
+	}
+	switch v := value.(type) {
+	case string:
+		return encryption.Encrypt(encryption.Provider, r.table+"."+column, v, deterministic)
+	case *string:
+		if v == nil {
+			return nil, nil
+		}
+		return encryption.Encrypt(encryption.Provider, r.table+"."+column, *v, deterministic)
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: %s is %T", ErrEncryptType, column, value)
+}
+
+// matches the "column = ?" before a placeholder
+var equalityRegex = regexp.MustCompile(`(\w+)\s*(=|<>|!=)\s*$`)
+
+// encryptArgs encrypts the arguments compared with "=" or "<>" to a deterministic column.
+func (r *Repository[T]) encryptArgs(criteria string, args []interface{}) ([]interface{}, error) {
+	if len(r.encrypted) == 0 || len(args) == 0 {
+		return args, nil
+	}
+	encrypted := append([]interface{}{}, args...)
+	n := 0
+	inQuote := false
+	for i, ch := range criteria {
+		if ch == '\'' {
+			inQuote = !inQuote
+		}
+		if ch != '?' || inQuote {
+			continue
+		}
+		if n >= len(encrypted) {
+			break
+		}
+		if m := equalityRegex.FindStringSubmatch(criteria[:i]); m != nil && r.encrypted[m[1]] {
+			value, err := r.encryptValue(m[1], encrypted[n])
+			if err != nil {
+				return nil, err
+			}
+			encrypted[n] = value
+		}
+		n++
+	}
+	return encrypted, nil
+}
+
+// decryptFields decrypts in place the encrypted fields of item selected by p.
+func decryptFields(item reflect.Value, p projection) error {
+	itemType := item.Type()
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Tag.Get(S2S_ENCRYPT) == "" || field.Tag.Get("db") == "" || !p.visible(field) {
+			continue
+		}
+		value := item.Field(i)
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+		if value.Kind() != reflect.String {
+			return fmt.Errorf("%w: %s", ErrEncryptType, field.Name)
+		}
+		binding := TableName(itemType) + "." + field.Tag.Get("db")
+		plaintext, err := encryption.Decrypt(encryption.Provider, binding, value.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.Name, err)
+		}
+		value.SetString(plaintext)
+	}
+	return nil
+}
+
+// reencryptBatch is the number of rows read and written at a time by Reencrypt.
+var reencryptBatch = 500
+
+// Reencrypt rewrites every row with the current key of encryption.Provider, e.g. after a key rotation
+// so deterministic columns can be searched again. The rows are read by id in batches, without their
+// relations, and each batch is written in its own transaction. It returns the number of rows written.
+func (r *Repository[T]) Reencrypt() (int64, error) {
+	if len(r.encrypted) == 0 {
+		return 0, nil
+	}
+	batch := *r
+	// depth 1 scans the rows without loading any relation
+	batch.defaultDepth = 1
+	column := r.idColumn()
+	criteria := "1 = 1"
+	args := []interface{}{}
+	var written int64
+	for {
+		items, err := batch.GetByCriteria(criteria+" ORDER BY "+column+" LIMIT "+strconv.Itoa(reencryptBatch), args...)
+		if err != nil {
+			return written, err
+		}
+		if len(items) == 0 {
+			return written, nil
+		}
+		if err := batch.UpdateMany(items); err != nil {
+			return written, err
+		}
+		written += int64(len(items))
+		if len(items) < reencryptBatch {
+			return written, nil
+		}
+		criteria, args = column+" > ?", []interface{}{r.idValue(items[len(items)-1])}
+	}
+}
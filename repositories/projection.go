@@ -0,0 +1,123 @@
+package repositories
+
+import (
+	"context"
+	"reflect"
+	"strings"
+)
+
+var S2S_ROLES string = "s2s_roles"
+
+type rolesKey struct{}
+
+// WithRoles returns a context whose queries only select the columns visible to roles. Fields tagged
+// with s2s_roles:"admin,support" are visible only to those roles, untagged fields to everyone.
+// Without WithRoles every column is selected.
+func WithRoles(ctx context.Context, roles ...string) context.Context {
+	return context.WithValue(ctx, rolesKey{}, append([]string{}, roles...))
+}
+
+// RolesFromContext returns the roles stored by WithRoles.
+func RolesFromContext(ctx context.Context) ([]string, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	roles, ok := ctx.Value(rolesKey{}).([]string)
+	return roles, ok
+}
+
+// CanSee reports whether a principal with roles may see field.
+func CanSee(field reflect.StructField, roles []string) bool {
+	tag := field.Tag.Get(S2S_ROLES)
+	if tag == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(tag, ",") {
+		for _, role := range roles {
+			if strings.TrimSpace(allowed) == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// projection decides which db fields are selected and scanned; the zero value selects every field.
+type projection struct {
+	roles  []string
+	active bool
+}
+
+func projectionOf(ctx context.Context) projection {
+	roles, ok := RolesFromContext(ctx)
+	return projection{roles: roles, active: ok}
+}
+
+func (p projection) visible(field reflect.StructField) bool {
+	return !p.active || CanSee(field, p.roles)
+}
+
+func (r *Repository[T]) projection() projection {
+	return projectionOf(r.ctx)
+}
+
+// selectAll returns sqlAll limited to the columns visible in r.ctx.
+func (r *Repository[T]) selectAll() string {
+	p := r.projection()
+	if !p.active {
+		return r.sqlAll
+	}
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	return createSelectSection(itemType, p) + createFromSection(itemType)
+}
+
+// selectByID returns sqlGetByID limited to the columns visible in r.ctx.
+func (r *Repository[T]) selectByID() string {
+	if !r.projection().active {
+		return r.sqlGetByID
+	}
+	return r.selectAll() + " WHERE id = ?"
+}
+
+// updateColumns returns the db columns written by Update, leaving out the ones hidden in r.ctx so a
+// row read with WithRoles can be written back without clearing them.
+func (r *Repository[T]) updateColumns() []string {
+	p := r.projection()
+	if !p.active {
+		return r.tags
+	}
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	columns := []string{}
+	for _, tag := range r.tags {
+		if field, ok := itemType.FieldByName(r.tagName[tag]); ok && p.visible(field) {
+			columns = append(columns, tag)
+		}
+	}
+	return columns
+}
+
+// updateSQL returns sqlUpdate limited to updateColumns.
+func (r *Repository[T]) updateSQL() string {
+	if !r.projection().active {
+		return r.sqlUpdate
+	}
+	sets := []string{}
+	for _, column := range r.updateColumns() {
+		sets = append(sets, column+" = ?")
+	}
+	return "UPDATE " + r.table + " SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+}
+
+// clearHidden zeroes the fields of item not visible under p, used when the query was written by hand.
+func clearHidden(item reflect.Value, p projection) {
+	if !p.active {
+		return
+	}
+	itemType := item.Type()
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Tag.Get("db") != "" && !p.visible(field) {
+			item.Field(i).Set(reflect.Zero(field.Type))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"reflect"
+	"sync"
+)
+
+var (
+	modelsMutex sync.RWMutex
+	models      []reflect.Type
+	modelSet    = map[reflect.Type]bool{}
+)
+
+// Register adds models, given as values like User{} or &User{}, to Models without creating their
+// repositories, e.g. for tables that are only written by migrations.
+func Register(values ...interface{}) {
+	for _, value := range values {
+		model := reflect.TypeOf(value)
+		for model.Kind() == reflect.Ptr {
+			model = model.Elem()
+		}
+		register(model)
+	}
+}
+
+// register records the model of a repository, see Models.
+func register(model reflect.Type) {
+	modelsMutex.RLock()
+	known := modelSet[model]
+	modelsMutex.RUnlock()
+	if known {
+		return
+	}
+	modelsMutex.Lock()
+	defer modelsMutex.Unlock()
+	if !modelSet[model] {
+		modelSet[model] = true
+		models = append(models, model)
+	}
+}
+
+// Models returns the models of the repositories created so far and of Register, in registration order.
+// It is the only registry: schema.Tables, schema.Generate and schema.Validate all read it.
+func Models() []reflect.Type {
+	modelsMutex.RLock()
+	defer modelsMutex.RUnlock()
+	return append([]reflect.Type{}, models...)
+}
@@ -0,0 +1,227 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+var (
+	ErrUnknownRelation = errors.New("unknown relation")
+	ErrNotLinkTable    = errors.New("relation has no link table")
+)
+
+// matches s2s tags like "id in (select role_id from user_roles where user_id = ?)"
+var linkTableRegex = regexp.MustCompile(`(?i)^\s*(\w+)\s+in\s*\(\s*select\s+(\w+)\s+from\s+(\w+)\s+where\s+(\w+)\s*=\s*\?\s*\)\s*$`)
+
+// Relation describes a field loaded with an s2s tag. LinkTable, OwnerColumn, TargetColumn and TargetKey
+// are set for many-to-many relations written as "key in (select target from link where owner = ?)".
+type Relation struct {
+	Field        string
+	Name         string
+	Type         reflect.Type
+	Many         bool
+	LinkTable    string
+	OwnerColumn  string
+	TargetColumn string
+	TargetKey    string
+}
+
+// Relations returns the s2s relations of T, named by their json name.
+func (r *Repository[T]) Relations() []Relation {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	relations := []Relation{}
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		tag := field.Tag.Get(S2S)
+		if tag == "" {
+			continue
+		}
+		name := field.Name
+		if jsonTag := strings.Split(field.Tag.Get("json"), ",")[0]; jsonTag != "" && jsonTag != "-" {
+			name = jsonTag
+		}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		relation := Relation{
+			Field: field.Name,
+			Name:  name,
+			Type:  fieldType,
+			Many:  fieldType.Kind() == reflect.Slice,
+		}
+		if m := linkTableRegex.FindStringSubmatch(tag); m != nil && field.Tag.Get(S2S_PARAM) == "" {
+			relation.TargetKey = m[1]
+			relation.TargetColumn = m[2]
+			relation.LinkTable = m[3]
+			relation.OwnerColumn = m[4]
+		}
+		relations = append(relations, relation)
+	}
+	return relations
+}
+
+func (r *Repository[T]) relation(name string) (Relation, error) {
+	for _, relation := range r.Relations() {
+		if relation.Name == name || relation.Field == name {
+			return relation, nil
+		}
+	}
+	return Relation{}, fmt.Errorf("%w: %s", ErrUnknownRelation, name)
+}
+
+// GetRelation loads only the relation name (json name or field name) of the row with id.
+// It returns nil when the row does not exist.
+func (r *Repository[T]) GetRelation(id interface{}, name string) (interface{}, error) {
+	relation, err := r.relation(name)
+	if err != nil {
+		return nil, err
+	}
+	query, args, err := r.byTenant(r.selectByID(), id)
+	if err != nil {
+		return nil, err
+	}
+	conn, _, err := r.getInternalTxOrConn()
+	if err != nil {
+		return nil, err
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+	var row *sql.Row
+	if r.tx != nil {
+		row = r.tx.QueryRowContext(r.ctx, query, args...)
+	} else {
+		row = conn.QueryRowContext(r.ctx, query, args...)
+	}
+
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	// depth 1 scans the row without loading any relation
+	itemValue, err := r.scan2(itemType, row, 1)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	depth := r.defaultDepth - 1
+	if depth < 1 {
+		depth = 1
+	}
+	field, _ := itemType.FieldByName(relation.Field)
+	r.loadRelation(itemValue, field, idFieldName(itemType), depth)
+	value := itemValue.FieldByName(relation.Field)
+	if value.Kind() == reflect.Ptr && value.IsNil() && relation.Many {
+		return reflect.MakeSlice(relation.Type, 0, 0).Interface(), nil
+	}
+	return value.Interface(), nil
+}
+
+// Link inserts the (id, targetID) pair in the link table of a many-to-many relation, unless it is
+// already there. It fails with sql.ErrNoRows when either row does not exist, or belongs to another tenant.
+func (r *Repository[T]) Link(id interface{}, name string, targetID interface{}) error {
+	relation, err := r.linkRelation(name)
+	if err != nil {
+		return err
+	}
+	exists, err := r.Exists(r.idColumn()+" = ?", id)
+	if err == nil && exists {
+		exists, err = r.targetExists(relation, targetID)
+	}
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	from := ""
+	if config.Dialect == config.DialectMySQL {
+		from = " FROM DUAL"
+	}
+	pair := relation.OwnerColumn + " = ? AND " + relation.TargetColumn + " = ?"
+	query := "INSERT INTO " + relation.LinkTable + " (" + relation.OwnerColumn + ", " + relation.TargetColumn + ") SELECT ?, ?" + from +
+		" WHERE NOT EXISTS (SELECT 1 FROM " + relation.LinkTable + " WHERE " + pair + ")"
+	_, err = r.execAffected(query, id, targetID, id, targetID)
+	if err != nil {
+		log.Printf("Error al vincular[021-Link]: %v", err)
+	}
+	return err
+}
+
+// Unlink deletes the (id, targetID) pair from the link table of a many-to-many relation.
+func (r *Repository[T]) Unlink(id interface{}, name string, targetID interface{}) error {
+	relation, err := r.linkRelation(name)
+	if err != nil {
+		return err
+	}
+	if err := r.ownedByTenant(id); err != nil {
+		return err
+	}
+	query := "DELETE FROM " + relation.LinkTable + " WHERE " + relation.OwnerColumn + " = ? AND " + relation.TargetColumn + " = ?"
+	_, err = r.execAffected(query, id, targetID)
+	if err != nil {
+		log.Printf("Error al desvincular[022-Unlink]: %v", err)
+	}
+	return err
+}
+
+func (r *Repository[T]) linkRelation(name string) (Relation, error) {
+	relation, err := r.relation(name)
+	if err != nil {
+		return relation, err
+	}
+	if relation.LinkTable == "" {
+		return relation, fmt.Errorf("%w: %s", ErrNotLinkTable, name)
+	}
+	if config.FlagLog {
+		log.Println("link table", relation.LinkTable, relation.OwnerColumn, relation.TargetColumn)
+	}
+	return relation, nil
+}
+
+// targetExists reports whether the related row with targetID exists, in the tenant of ctx when the
+// related model is tenant scoped.
+func (r *Repository[T]) targetExists(relation Relation, targetID interface{}) (bool, error) {
+	targetType := relation.Type
+	for targetType.Kind() == reflect.Ptr || targetType.Kind() == reflect.Slice {
+		targetType = targetType.Elem()
+	}
+	query := "SELECT EXISTS (SELECT 1 FROM " + TableName(targetType) + " WHERE " + relation.TargetKey + " = ?"
+	args := []interface{}{targetID}
+	if _, column, ok := tenantField(targetType); ok {
+		tenant, active, err := tenantOf(r.ctx, column)
+		if err != nil {
+			return false, err
+		}
+		if active {
+			query += " AND " + column + " = ?"
+			args = append(args, tenant)
+		}
+	}
+	var exists bool
+	err := r.queryScalar(query+")", args, &exists)
+	return exists, err
+}
+
+// ownedByTenant fails with sql.ErrNoRows when the row with id belongs to another tenant,
+// so the link table of a tenant scoped model can not be changed across tenants.
+func (r *Repository[T]) ownedByTenant(id interface{}) error {
+	if _, active, err := r.tenant(); err != nil || !active {
+		return err
+	}
+	exists, err := r.Exists("id = ?", id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -23,10 +23,37 @@ type IRepository[T any] interface {
 	GetAll() ([]*T, error)
 	GetByID(id interface{}) (*T, error)
 	GetByCriteria(criteria string, args ...interface{}) ([]*T, error)
+	ForEach(criteria string, fn func(item *T) error, args ...interface{}) error
 	Create(item *T) (*int64, error)
 	Update(item *T) error
 	Delete(id interface{}) error
 
+	CreateMany(items []*T) ([]int64, error)
+	UpdateMany(items []*T) error
+	DeleteMany(ids []interface{}) error
+
+	UpdateWhere(set map[string]interface{}, criteria string, args ...interface{}) (int64, error)
+	DeleteWhere(criteria string, args ...interface{}) (int64, error)
+	UpdateAll(set map[string]interface{}) (int64, error)
+	DeleteAll() (int64, error)
+
+	Count(criteria string, args ...interface{}) (int64, error)
+	Exists(criteria string, args ...interface{}) (bool, error)
+	Sum(column string, criteria string, args ...interface{}) (float64, error)
+	Avg(column string, criteria string, args ...interface{}) (float64, error)
+	Min(column string, criteria string, args ...interface{}) (float64, error)
+	Max(column string, criteria string, args ...interface{}) (float64, error)
+	GroupBy(groupBy []string, aggregates []Aggregate, criteria string, args ...interface{}) ([]map[string]interface{}, error)
+
+	Upsert(item *T, conflictColumns ...string) error
+	UpsertColumns(item *T, conflictColumns []string, updateColumns []string) error
+	UpsertMany(items []*T, conflictColumns []string, updateColumns []string) error
+
+	Relations() []Relation
+	GetRelation(id interface{}, name string) (interface{}, error)
+	Link(id interface{}, name string, targetID interface{}) error
+	Unlink(id interface{}, name string, targetID interface{}) error
+
 	GetTableName() string
 	GetTags() []string
 	GetTagsName() map[string]string
@@ -41,6 +68,9 @@ type IRepository[T any] interface {
 	GetDepth() int
 
 	SetContext(ctx context.Context)
+	WithContext(ctx context.Context) IRepository[T]
+
+	History(id interface{}) ([]Revision, error)
 }
 
 type Repository[T any] struct {
@@ -55,6 +85,9 @@ type Repository[T any] struct {
 	defaultDepth int
 	tx           *sql.Tx
 	ctx          context.Context
+	tenantColumn string
+	encrypted    map[string]bool
+	capturing    bool
 }
 
 func NewRepository[T any]() *Repository[T] {
@@ -113,16 +146,24 @@ func NewRepositoryWithContext[T any](ctx context.Context) *Repository[T] {
 	}
 	sqlCreate += ")"
 	itemType = reflect.TypeOf(*item)
-	r.sqlAll = createSelectSection(itemType) + createFromSection(itemType)
-	r.sqlGetByID = createSelectSection(itemType) + createFromSection(itemType) + " WHERE id = ?"
+	r.sqlAll = createSelectSection(itemType, projection{}) + createFromSection(itemType)
+	r.sqlGetByID = createSelectSection(itemType, projection{}) + createFromSection(itemType) + " WHERE id = ?"
 	r.sqlCreate = sqlCreate
 	r.sqlUpdate = sqlUpdate
 	r.sqlDelete = "DELETE FROM " + table + " WHERE id = ?"
+	_, r.tenantColumn, _ = tenantField(itemType)
+	r.encrypted = encryptedColumns(itemType)
+	register(itemType)
 
 	return r
 }
 
 func createFromSection(itemType reflect.Type) string {
+	return " FROM " + TableName(itemType) + "  "
+}
+
+// TableName returns the table of itemType, the s2s_table_name tag or the snake case name of the struct.
+func TableName(itemType reflect.Type) string {
 	tableName := utils.ToSnakeCase(itemType.Name())
 	for i := 0; i < itemType.NumField(); i++ {
 		field := itemType.Field(i)
@@ -132,17 +173,16 @@ func createFromSection(itemType reflect.Type) string {
 			break
 		}
 	}
-
-	return " FROM " + tableName + "  "
+	return tableName
 }
 
-func createSelectSection(itemType reflect.Type) string {
+func createSelectSection(itemType reflect.Type, p projection) string {
 
 	fieldList := ""
 	for i := 0; i < itemType.NumField(); i++ {
 		field := itemType.Field(i)
 		tag := field.Tag.Get("db")
-		if tag == "" {
+		if tag == "" || !p.visible(field) {
 			continue
 		}
 		if fieldList != "" {
@@ -177,11 +217,16 @@ func (r *Repository[T]) GetAll() ([]*T, error) {
 		defer conn.Close()
 	}
 
+	where, args, err := r.scopedWhere("")
+	if err != nil {
+		return nil, err
+	}
+
 	var rows *sql.Rows
 	if r.tx != nil {
-		rows, err = r.tx.QueryContext(r.ctx, r.sqlAll)
+		rows, err = r.tx.QueryContext(r.ctx, r.selectAll()+where, args...)
 	} else {
-		rows, err = conn.QueryContext(r.ctx, r.sqlAll)
+		rows, err = conn.QueryContext(r.ctx, r.selectAll()+where, args...)
 	}
 	if err != nil {
 		log.Printf("Error al ejecutar la consulta[009-GetAll]: %v", err)
@@ -204,7 +249,11 @@ func (r *Repository[T]) GetAll() ([]*T, error) {
 	return items, nil
 }
 
+// GetByCriteria returns the rows matching criteria, which is required: use GetAll for every row.
 func (r *Repository[T]) GetByCriteria(criteria string, args ...interface{}) ([]*T, error) {
+	if err := requireCriteria(criteria); err != nil {
+		return nil, err
+	}
 	conn, _, err := r.getInternalTxOrConn()
 	if err != nil {
 		return nil, err
@@ -213,15 +262,16 @@ func (r *Repository[T]) GetByCriteria(criteria string, args ...interface{}) ([]*
 		defer conn.Close()
 	}
 
-	if !strings.HasPrefix(strings.ToLower(criteria), "where") {
-		criteria = " WHERE " + criteria
+	criteria, args, err = r.scopedWhere(criteria, args...)
+	if err != nil {
+		return nil, err
 	}
 
 	var rows *sql.Rows
 	if r.tx != nil {
-		rows, err = r.tx.QueryContext(r.ctx, r.sqlAll+" "+criteria, args...)
+		rows, err = r.tx.QueryContext(r.ctx, r.selectAll()+criteria, args...)
 	} else {
-		rows, err = conn.QueryContext(r.ctx, r.sqlAll+" "+criteria, args...)
+		rows, err = conn.QueryContext(r.ctx, r.selectAll()+criteria, args...)
 	}
 
 	if err != nil {
@@ -256,11 +306,15 @@ func (r *Repository[T]) GetByID(id interface{}) (*T, error) {
 	if conn != nil {
 		defer conn.Close()
 	}
+	query, args, err := r.byTenant(r.selectByID(), id)
+	if err != nil {
+		return nil, err
+	}
 	var row *sql.Row
 	if r.tx != nil {
-		row = r.tx.QueryRowContext(r.ctx, r.sqlGetByID, id)
+		row = r.tx.QueryRowContext(r.ctx, query, args...)
 	} else {
-		row = conn.QueryRowContext(r.ctx, r.sqlGetByID, id)
+		row = conn.QueryRowContext(r.ctx, query, args...)
 	}
 	item := CreateNewElement[T]()
 	v, err := r.scan2(reflect.TypeOf(*item), row, r.defaultDepth)
@@ -277,6 +331,20 @@ func (r *Repository[T]) GetByID(id interface{}) (*T, error) {
 }
 
 func (r *Repository[T]) Create(item *T) (*int64, error) {
+	if r.tracks() {
+		var id *int64
+		err := r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackIDs(nil, func() ([]interface{}, error) {
+				var err error
+				id, err = repo.Create(item)
+				if err != nil {
+					return nil, err
+				}
+				return []interface{}{*id}, nil
+			})
+		})
+		return id, err
+	}
 	conn, _, err := r.getInternalTxOrConn()
 	if err != nil {
 		return nil, err
@@ -285,26 +353,12 @@ func (r *Repository[T]) Create(item *T) (*int64, error) {
 		defer conn.Close()
 	}
 
-	fieldsValues := []interface{}{}
-	for _, tag := range r.tags {
-		value := reflect.ValueOf(*item).FieldByName(r.tagName[tag])
-		field, b := reflect.TypeOf(*item).FieldByName(r.tagName[tag])
-		if b {
-			tagSqlUpdateValue := field.Tag.Get(S2S_REF_VALUE)
-			if tagSqlUpdateValue != "" {
-				tagSqlUpdateValueArray := strings.Split(tagSqlUpdateValue, ".")
-				if len(tagSqlUpdateValueArray) == 2 {
-					v := reflect.ValueOf(*item).FieldByName(tagSqlUpdateValueArray[0])
-					if v.Kind() == reflect.Ptr {
-						v = v.Elem()
-					}
-
-					value = v.FieldByName(tagSqlUpdateValueArray[1])
-				}
-			}
-		}
-
-		fieldsValues = append(fieldsValues, value.Interface())
+	if err := r.fillTenant(item); err != nil {
+		return nil, err
+	}
+	fieldsValues, err := r.fieldsValues(item)
+	if err != nil {
+		return nil, err
 	}
 
 	if config.FlagLog {
@@ -347,6 +401,13 @@ func (r *Repository[T]) Create(item *T) (*int64, error) {
 }
 
 func (r *Repository[T]) Update(item *T) error {
+	if r.tracks() {
+		return r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackIDs([]interface{}{r.idValue(item)}, func() ([]interface{}, error) {
+				return nil, repo.Update(item)
+			})
+		})
+	}
 	conn, _, err := r.getInternalTxOrConn()
 	if err != nil {
 		return err
@@ -354,43 +415,22 @@ func (r *Repository[T]) Update(item *T) error {
 	if conn != nil {
 		defer conn.Close()
 	}
-	fieldsValues := []interface{}{}
-	itemValue := reflect.ValueOf(item).Elem()
-	itemType := itemValue.Type()
-	fieldIdName := "ID"
-	for i := 0; i < itemType.NumField(); i++ {
-		tagID := itemType.Field(i).Tag.Get(S2S_ID)
-		if tagID == "true" {
-			fieldIdName = itemType.Field(i).Name
-			break
-		}
+	if err := r.fillTenant(item); err != nil {
+		return err
 	}
-	for _, tag := range r.tags {
-		value := reflect.ValueOf(*item).FieldByName(r.tagName[tag])
-		field, b := reflect.TypeOf(*item).FieldByName(r.tagName[tag])
-		if b {
-			tagSqlUpdateValue := field.Tag.Get(S2S_REF_VALUE)
-			if tagSqlUpdateValue != "" {
-				tagSqlUpdateValueArray := strings.Split(tagSqlUpdateValue, ".")
-				if len(tagSqlUpdateValueArray) == 2 {
-					v := reflect.ValueOf(*item).FieldByName(tagSqlUpdateValueArray[0])
-					if v.Kind() == reflect.Ptr {
-						v = v.Elem()
-					}
-
-					value = v.FieldByName(tagSqlUpdateValueArray[1])
-				}
-			}
-		}
-
-		fieldsValues = append(fieldsValues, value.Interface())
+	fieldsValues, err := r.columnsValues(item, r.updateColumns())
+	if err != nil {
+		return err
+	}
+	query, fieldsValues, err := r.byTenant(r.updateSQL(), append(fieldsValues, r.idValue(item))...)
+	if err != nil {
+		return err
 	}
-	fieldsValues = append(fieldsValues, reflect.ValueOf(*item).FieldByName(fieldIdName).Interface())
 
 	if r.tx != nil {
-		_, err = r.tx.ExecContext(r.ctx, r.sqlUpdate, fieldsValues...)
+		_, err = r.tx.ExecContext(r.ctx, query, fieldsValues...)
 	} else {
-		_, err = conn.ExecContext(r.ctx, r.sqlUpdate, fieldsValues...)
+		_, err = conn.ExecContext(r.ctx, query, fieldsValues...)
 	}
 	if err != nil {
 		err1 := r.Rollback()
@@ -407,6 +447,13 @@ func (r *Repository[T]) Update(item *T) error {
 }
 
 func (r *Repository[T]) Delete(id interface{}) error {
+	if r.tracks() {
+		return r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			return repo.trackIDs([]interface{}{id}, func() ([]interface{}, error) {
+				return nil, repo.Delete(id)
+			})
+		})
+	}
 	conn, _, err := r.getInternalTxOrConn()
 	if err != nil {
 		return err
@@ -414,10 +461,14 @@ func (r *Repository[T]) Delete(id interface{}) error {
 	if conn != nil {
 		defer conn.Close()
 	}
+	query, args, err := r.byTenant(r.sqlDelete, id)
+	if err != nil {
+		return err
+	}
 	if r.tx != nil {
-		_, err = r.tx.ExecContext(r.ctx, r.sqlDelete, id)
+		_, err = r.tx.ExecContext(r.ctx, query, args...)
 	} else {
-		_, err = conn.ExecContext(r.ctx, r.sqlDelete, id)
+		_, err = conn.ExecContext(r.ctx, query, args...)
 	}
 
 	if err != nil {
@@ -434,6 +485,59 @@ func (r *Repository[T]) Delete(id interface{}) error {
 	return nil
 }
 
+// fieldsValues returns the values of the db columns in r.tags order, resolving s2s_ref_value.
+func (r *Repository[T]) fieldsValues(item *T) ([]interface{}, error) {
+	return r.columnsValues(item, r.tags)
+}
+
+// columnsValues returns the values of columns in item, following s2s_ref_value and encrypting.
+func (r *Repository[T]) columnsValues(item *T, columns []string) ([]interface{}, error) {
+	fieldsValues := []interface{}{}
+	for _, tag := range columns {
+		value := reflect.ValueOf(*item).FieldByName(r.tagName[tag])
+		field, b := reflect.TypeOf(*item).FieldByName(r.tagName[tag])
+		if b {
+			tagSqlUpdateValue := field.Tag.Get(S2S_REF_VALUE)
+			if tagSqlUpdateValue != "" {
+				tagSqlUpdateValueArray := strings.Split(tagSqlUpdateValue, ".")
+				if len(tagSqlUpdateValueArray) == 2 {
+					v := reflect.ValueOf(*item).FieldByName(tagSqlUpdateValueArray[0])
+					if v.Kind() == reflect.Ptr {
+						v = v.Elem()
+					}
+
+					// keep the field value when the referenced struct was not sent or is the zero value
+					if v.IsValid() && !v.IsZero() {
+						value = v.FieldByName(tagSqlUpdateValueArray[1])
+					}
+				}
+			}
+		}
+
+		fieldValue, err := r.encryptValue(tag, value.Interface())
+		if err != nil {
+			return nil, err
+		}
+		fieldsValues = append(fieldsValues, fieldValue)
+	}
+	return fieldsValues, nil
+}
+
+func (r *Repository[T]) idValue(item *T) interface{} {
+	return reflect.ValueOf(*item).FieldByName(idFieldName(reflect.TypeOf(*item))).Interface()
+}
+
+// idFieldName returns the name of the field marked with s2s_id:"true", "ID" by default.
+func idFieldName(itemType reflect.Type) string {
+	for i := 0; i < itemType.NumField(); i++ {
+		tagID := itemType.Field(i).Tag.Get(S2S_ID)
+		if tagID == "true" {
+			return itemType.Field(i).Name
+		}
+	}
+	return "ID"
+}
+
 func (r *Repository[T]) GetTableName() string {
 	return r.table
 }
@@ -468,31 +572,33 @@ func (r *Repository[T]) GetTx() *sql.Tx {
 }
 
 func (r *Repository[T]) Rollback() error {
-	if r.tx != nil {
-		err := r.tx.Rollback()
-		if config.FlagLog && err != nil {
-			log.Println(err)
-			return err
-		}
-		if err != nil {
-			return err
-		}
+	if r.tx == nil {
+		return nil
 	}
-	return nil
+	err := RollbackTx(r.tx)
+	if config.FlagLog && err != nil {
+		log.Println(err)
+	}
+	return err
 }
 
 func (r *Repository[T]) Commit() error {
-	if r.tx != nil {
-		err := r.tx.Commit()
-		if config.FlagLog && err != nil {
-			log.Println(err)
-			return err
-		}
-		if err != nil {
-			return err
-		}
+	if r.tx == nil {
+		return nil
 	}
-	return nil
+	err := CommitTx(r.tx)
+	if config.FlagLog && err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// WithContext returns a copy of the repository that runs its queries with ctx, e.g. the request context
+// carrying the tenant; the original repository is not modified.
+func (r *Repository[T]) WithContext(ctx context.Context) IRepository[T] {
+	clone := *r
+	clone.SetContext(ctx)
+	return &clone
 }
 
 func (r *Repository[T]) SetContext(ctx context.Context) {
@@ -513,154 +619,170 @@ func (r *Repository[T]) processTagSql(item interface{}, depth int) {
 	itemValue := reflect.ValueOf(item).Elem()
 	itemType := itemValue.Type()
 
-	fieldIdName := "ID"
+	fieldIdName := idFieldName(itemType)
 	for i := 0; i < itemType.NumField(); i++ {
-		tagID := itemType.Field(i).Tag.Get(S2S_ID)
-		if tagID == "true" {
-			fieldIdName = itemType.Field(i).Name
-			break
+		r.loadRelation(itemValue, itemType.Field(i), fieldIdName, depth)
+	}
+}
+
+// loadRelation runs the s2s query of field and sets the result on itemValue.
+func (r *Repository[T]) loadRelation(itemValue reflect.Value, field reflect.StructField, fieldIdName string, depth int) {
+	tag := field.Tag.Get("s2s")
+	if tag == "" {
+		return
+	}
+
+	if config.FlagLog {
+		log.Println(tag, itemValue.FieldByName(fieldIdName).Interface())
+	}
+	tagParam := field.Tag.Get(S2S_PARAM)
+	arrayParam := []interface{}{}
+	if tagParam != "" {
+		arrayTagParam := strings.Split(tagParam, ",")
+		for _, param := range arrayTagParam {
+			arrayParam = append(arrayParam, itemValue.FieldByName(param).Interface())
 		}
+	} else {
+		arrayParam = append(arrayParam, itemValue.FieldByName(fieldIdName).Interface())
 	}
-	for i := 0; i < itemType.NumField(); i++ {
-		func(i int) {
-			field := itemType.Field(i)
-			tag := field.Tag.Get("s2s")
-			if tag == "" {
-				return
-			}
 
-			if config.FlagLog {
-				log.Println(tag, itemValue.FieldByName(fieldIdName).Interface())
-			}
-			tagParam := field.Tag.Get(S2S_PARAM)
-			arrayParam := []interface{}{}
-			if tagParam != "" {
-				arrayTagParam := strings.Split(tagParam, ",")
-				for _, param := range arrayTagParam {
-					arrayParam = append(arrayParam, itemValue.FieldByName(param).Interface())
-				}
-			} else {
-				arrayParam = append(arrayParam, itemValue.FieldByName(fieldIdName).Interface())
-			}
+	fieldType := field.Type
+	tag, custom := relationQuery(field, r.projection())
+	tag, arrayParam, err := scopeRelation(r.ctx, fieldType, tag, arrayParam)
+	if err != nil {
+		log.Printf("Error al filtrar por tenant[023-loadRelation]: %v", err)
+		return
+	}
+	conn, _, err := r.getInternalTxOrConn()
+	if err != nil {
+		log.Printf("Error al obtener la conexion: %v", err)
+		return
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+	var rows *sql.Rows
+	if r.tx != nil {
+		rows, err = r.tx.QueryContext(r.ctx, tag, arrayParam...)
+	} else {
+		rows, err = conn.QueryContext(r.ctx, tag, arrayParam...)
+	}
 
-			fieldType := field.Type
-			lowTag := strings.ToLower(tag)
-			if !strings.HasPrefix(lowTag, "select") {
-				var subItemType reflect.Type
-				if fieldType.Kind() == reflect.Ptr {
-					ptrType := fieldType.Elem()
-					if ptrType.Kind() == reflect.Struct {
-						subItemType = reflect.New(ptrType).Elem().Type()
-					} else if ptrType.Kind() == reflect.Slice {
-						subItemType = ptrType.Elem()
-					}
-				} else {
-					if fieldType.Kind() == reflect.Struct {
-						subItemType = fieldType
-					} else if fieldType.Kind() == reflect.Slice {
-						subItemType = fieldType.Elem()
-					}
-				}
+	if err != nil {
+		log.Printf("Error al ejecutar la consulta[004]: %v", err)
+		return
+	}
+	defer rows.Close()
 
-				if !strings.HasPrefix(lowTag, "from") {
-					if !strings.HasPrefix(lowTag, "where") {
-						if !strings.ContainsAny(lowTag, " =><?-!") {
-							tag = tag + " = ? "
-						}
-						tag = " WHERE " + tag
-					}
+	// Obtiene el tipo del campo y crea una nueva instancia
 
-					tag = createFromSection(subItemType) + tag
-				}
+	if fieldType.Kind() == reflect.Slice {
+		sliceType := fieldType.Elem()
+		sliceVal := reflect.MakeSlice(fieldType, 0, 0)
 
-				//fmt.Println("55>>", subItemType)
-				tag = createSelectSection(subItemType) + tag
-			}
-			conn, _, err := r.getInternalTxOrConn()
-			if err != nil {
-				log.Printf("Error al obtener la conexion: %v", err)
+		// Itera sobre los resultados de la consulta
+		for rows.Next() {
+			newElem, _ := r.scanRelation(sliceType, rows, depth, custom)
+			sliceVal = reflect.Append(sliceVal, newElem)
+		}
+
+		// Establece el valor del campo en la estructura
+		itemValue.FieldByName(field.Name).Set(sliceVal)
+		return
+	}
+	if fieldType.Kind() == reflect.Ptr {
+		ptrType := fieldType.Elem()
+		if ptrType.Kind() == reflect.Struct {
+			if rows.Next() {
+				elemVal, err := r.scanRelation(ptrType, rows, depth, custom)
+				if err != nil {
+					if config.FlagLog {
+						log.Printf("Error al escanear la fila[003]: %v", err)
+					}
+					return
+				}
+				ptrVal := elemVal.Addr()
+				itemValue.FieldByName(field.Name).Set(ptrVal)
 				return
 			}
-			if conn != nil {
-				defer conn.Close()
-			}
-			var rows *sql.Rows
-			if r.tx != nil {
-				rows, err = r.tx.QueryContext(r.ctx, tag, arrayParam...)
-			} else {
-				rows, err = conn.QueryContext(r.ctx, tag, arrayParam...)
+		}
+		if ptrType.Kind() == reflect.Slice {
+			sliceType := ptrType.Elem()
+			sliceVal := reflect.MakeSlice(ptrType, 0, 0)
+
+			// Itera sobre los resultados de la consulta
+			for rows.Next() {
+				newElem, _ := r.scanRelation(sliceType, rows, depth, custom)
+				sliceVal = reflect.Append(sliceVal, newElem)
 			}
+			ptr := reflect.New(sliceVal.Type())
+			ptr.Elem().Set(sliceVal)
+			// Establece el valor del campo en la estructura
+			itemValue.FieldByName(field.Name).Set(ptr)
+			return
+		}
+	}
 
+	if fieldType.Kind() == reflect.Struct {
+		if rows.Next() {
+			elemVal, err := r.scanRelation(fieldType, rows, depth, custom)
 			if err != nil {
-				log.Printf("Error al ejecutar la consulta[004]: %v", err)
+				if config.FlagLog {
+					log.Printf("Error al escanear la fila[002]: %v", err)
+				}
 				return
 			}
-			defer rows.Close()
-
-			// Obtiene el tipo del campo y crea una nueva instancia
-
-			if fieldType.Kind() == reflect.Slice {
-				sliceType := fieldType.Elem()
-				sliceVal := reflect.MakeSlice(fieldType, 0, 0)
+			itemValue.FieldByName(field.Name).Set(elemVal)
+		}
+		return
+	}
+}
 
-				// Itera sobre los resultados de la consulta
-				for rows.Next() {
-					newElem, _ := r.scan2(sliceType, rows, depth)
-					sliceVal = reflect.Append(sliceVal, newElem)
-				}
+// RelationQuery returns the query run to load the s2s relation of field, with a "?" per s2s_param.
+func RelationQuery(field reflect.StructField) string {
+	query, _ := relationQuery(field, projection{})
+	return query
+}
 
-				// Establece el valor del campo en la estructura
-				itemValue.FieldByName(field.Name).Set(sliceVal)
-				return
+// relationQuery completes the s2s tag of field into a select of the visible columns of the related type;
+// custom is set for tags that are a whole "select ..." query.
+func relationQuery(field reflect.StructField, p projection) (tag string, custom bool) {
+	tag = field.Tag.Get(S2S)
+	fieldType := field.Type
+	lowTag := strings.ToLower(tag)
+	custom = strings.HasPrefix(lowTag, "select")
+	if !custom {
+		var subItemType reflect.Type
+		if fieldType.Kind() == reflect.Ptr {
+			ptrType := fieldType.Elem()
+			if ptrType.Kind() == reflect.Struct {
+				subItemType = reflect.New(ptrType).Elem().Type()
+			} else if ptrType.Kind() == reflect.Slice {
+				subItemType = ptrType.Elem()
 			}
-			if fieldType.Kind() == reflect.Ptr {
-				ptrType := fieldType.Elem()
-				if ptrType.Kind() == reflect.Struct {
-					if rows.Next() {
-						elemVal, err := r.scan2(ptrType, rows, depth)
-						if err != nil {
-							if config.FlagLog {
-								log.Printf("Error al escanear la fila[003]: %v", err)
-							}
-							return
-						}
-						ptrVal := elemVal.Addr()
-						itemValue.FieldByName(field.Name).Set(ptrVal)
-						return
-					}
-				}
-				if ptrType.Kind() == reflect.Slice {
-					sliceType := ptrType.Elem()
-					sliceVal := reflect.MakeSlice(ptrType, 0, 0)
-
-					// Itera sobre los resultados de la consulta
-					for rows.Next() {
-						newElem, _ := r.scan2(sliceType, rows, depth)
-						sliceVal = reflect.Append(sliceVal, newElem)
-					}
-					ptr := reflect.New(sliceVal.Type())
-					ptr.Elem().Set(sliceVal)
-					// Establece el valor del campo en la estructura
-					itemValue.FieldByName(field.Name).Set(ptr)
-					return
-				}
+		} else {
+			if fieldType.Kind() == reflect.Struct {
+				subItemType = fieldType
+			} else if fieldType.Kind() == reflect.Slice {
+				subItemType = fieldType.Elem()
 			}
+		}
 
-			if fieldType.Kind() == reflect.Struct {
-				if rows.Next() {
-					elemVal, err := r.scan2(fieldType, rows, depth)
-					if err != nil {
-						if config.FlagLog {
-							log.Printf("Error al escanear la fila[002]: %v", err)
-						}
-						return
-					}
-					itemValue.FieldByName(field.Name).Set(elemVal)
+		if !strings.HasPrefix(lowTag, "from") {
+			if !strings.HasPrefix(lowTag, "where") {
+				if !strings.ContainsAny(lowTag, " =><?-!") {
+					tag = tag + " = ? "
 				}
-				return
+				tag = " WHERE " + tag
 			}
-		}(i)
+
+			tag = createFromSection(subItemType) + tag
+		}
+
+		//fmt.Println("55>>", subItemType)
+		tag = createSelectSection(subItemType, p) + tag
 	}
+	return tag, custom
 }
 
 type iRow interface {
@@ -668,6 +790,21 @@ type iRow interface {
 }
 
 func (r *Repository[T]) scan2(itemType reflect.Type, row iRow, depth int) (reflect.Value, error) {
+	return r.scan(itemType, row, depth, r.projection())
+}
+
+// scanRelation scans a relation row; custom "select ..." queries return every column, so the
+// hidden fields are cleared after the scan instead of being skipped.
+func (r *Repository[T]) scanRelation(itemType reflect.Type, row iRow, depth int, custom bool) (reflect.Value, error) {
+	if !custom {
+		return r.scan2(itemType, row, depth)
+	}
+	item, err := r.scan(itemType, row, depth, projection{})
+	clearHidden(item, r.projection())
+	return item, err
+}
+
+func (r *Repository[T]) scan(itemType reflect.Type, row iRow, depth int, p projection) (reflect.Value, error) {
 	item := reflect.New(itemType).Elem()
 	l := itemType.NumField()
 	values := make([]interface{}, 0)
@@ -676,7 +813,7 @@ func (r *Repository[T]) scan2(itemType reflect.Type, row iRow, depth int) (refle
 		field := itemType.Field(i)
 
 		tag := field.Tag.Get("db")
-		if tag == "" {
+		if tag == "" || !p.visible(field) {
 			continue
 		}
 		//fmt.Println(tag, field.Name, item.FieldByName(field.Name).Type())
@@ -688,6 +825,8 @@ func (r *Repository[T]) scan2(itemType reflect.Type, row iRow, depth int) (refle
 		if config.FlagLog {
 			log.Printf("Error al escanear la fila[001]: %v", err)
 		}
+	} else {
+		err = decryptFields(item, p)
 	}
 	depth = depth - 1
 	if depth > 0 {
@@ -700,6 +839,8 @@ type RepositoryTx interface {
 	SetTx(tx *sql.Tx)
 }
 
+// CreateTxAndSet begins a transaction and sets it on every repository. End it with the Commit or Rollback
+// method of one of them, or with CommitTx and RollbackTx, so the commit hooks see its writes.
 func CreateTxAndSet(rr ...RepositoryTx) (*sql.Tx, error) {
 	tx, err := config.DB.Begin()
 	if err != nil {
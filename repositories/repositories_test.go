@@ -1,12 +1,17 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"os"
+	"strconv"
+	"sync"
 	"testing"
 
 	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/encryption"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -263,3 +268,607 @@ func TestCreate(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCreateMany(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+
+	repoUser := NewRepository[User]()
+	groupID := 2
+	users := []*User{}
+	for i := 0; i < 600; i++ {
+		users = append(users, &User{FirstName: "bulk", Email: "bulk@bulk.com", GroupId: &groupID})
+	}
+	ids, err := repoUser.CreateMany(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 600 {
+		t.Fatal("expected 600 ids, got", len(ids))
+	}
+	last, _ := repoUser.GetByID(ids[599])
+	if last == nil || last.FirstName != "bulk" {
+		t.Error("last id does not match a created user")
+	}
+
+	for _, u := range users[:2] {
+		u.FirstName = "bulk2"
+	}
+	users[0].UserID = new(int)
+	*users[0].UserID = int(ids[0])
+	users[1].UserID = new(int)
+	*users[1].UserID = int(ids[1])
+	err = repoUser.UpdateMany(users[:2])
+	if err != nil {
+		t.Error(err)
+	}
+	updated, _ := repoUser.GetByCriteria("first_name = ?", "bulk2")
+	if len(updated) != 2 {
+		t.Error("expected 2 updated users, got", len(updated))
+	}
+
+	idsToDelete := []interface{}{}
+	for _, id := range ids {
+		idsToDelete = append(idsToDelete, id)
+	}
+	err = repoUser.DeleteMany(idsToDelete)
+	if err != nil {
+		t.Error(err)
+	}
+	left, _ := repoUser.GetByCriteria("first_name like ?", "bulk%")
+	if len(left) != 0 {
+		t.Error("expected 0 users, got", len(left))
+	}
+
+	// explicit ids are returned as inserted, not derived from the last id
+	explicitID := 9000
+	ids, err = repoUser.CreateMany([]*User{{FirstName: "bulk", GroupId: &groupID}, {UserID: &explicitID, FirstName: "bulk", GroupId: &groupID}})
+	if err != nil || len(ids) != 2 || ids[1] != 9000 || ids[0] == 8999 {
+		t.Fatal("unexpected ids", ids, err)
+	}
+	if first, _ := repoUser.GetByID(ids[0]); first == nil {
+		t.Error("first id does not match a created user")
+	}
+	repoUser.DeleteMany([]interface{}{ids[0], ids[1]})
+}
+
+func TestUpsert(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+
+	repoRole := NewRepository[Role]()
+	err := repoRole.Upsert(&Role{ID: 1, Name: "superadmin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	role, _ := repoRole.GetByID(1)
+	if role.Name != "superadmin" {
+		t.Error("role is not updated")
+	}
+
+	err = repoRole.UpsertMany([]*Role{{ID: 2, Name: "other"}, {ID: 10, Name: "guest"}}, []string{"id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, _ := repoRole.GetAll()
+	if len(roles) != 3 {
+		t.Error("expected 3 roles, got", len(roles))
+	}
+	role, _ = repoRole.GetByID(2)
+	if role.Name != "other" {
+		t.Error("role 2 is not updated")
+	}
+
+	// columns are written into the SQL, so unknown ones are rejected
+	err = repoRole.UpsertMany([]*Role{{ID: 2, Name: "x"}}, []string{"name); DROP TABLE roles; --"}, nil)
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Error("expected an unknown conflict column, got", err)
+	}
+	if err := repoRole.UpsertColumns(&Role{ID: 2, Name: "x"}, nil, []string{"nope"}); !errors.Is(err, ErrUnknownColumn) {
+		t.Error("expected an unknown update column, got", err)
+	}
+	if roles, _ := repoRole.GetAll(); len(roles) != 3 {
+		t.Error("expected the roles to be kept, got", len(roles))
+	}
+}
+
+func TestUpsertTracked(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+	defer resetHooks()
+	changes := 0
+	OnChangeCommit(func(change Change) {
+		changes++
+	})
+	if _, err := config.DB.Exec("CREATE UNIQUE INDEX user_name_email ON user (first_name, email)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a composite key over more rows than bind parameters, even the 32766 of this SQLite build,
+	// is snapshotted in batches
+	users := make([]*User, 17000)
+	for i := range users {
+		users[i] = &User{FirstName: "user" + strconv.Itoa(i), Email: "user@example.com"}
+	}
+	repoUser := NewRepository[User]()
+	if err := repoUser.UpsertMany(users, []string{"first_name", "email"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if changes != len(users) {
+		t.Error("expected a created user per item, got", changes)
+	}
+	if err := repoUser.UpsertMany(users, []string{"first_name", "email"}, []string{"group_id"}); err != nil {
+		t.Fatal(err)
+	}
+	if changes != len(users) {
+		t.Error("expected no change for the same rows, got", changes)
+	}
+}
+
+func TestUpdateAndDeleteWhere(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+
+	repoUser := NewRepository[User]()
+	n, err := repoUser.UpdateWhere(map[string]interface{}{"email": "changed@admin.com"}, "first_name = ?", "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Error("expected 1 row updated, got", n)
+	}
+	if _, err := repoUser.UpdateWhere(map[string]interface{}{"password": "x"}, "id = ?", 1); !errors.Is(err, ErrUnknownColumn) {
+		t.Error("expected ErrUnknownColumn, got", err)
+	}
+
+	if _, err := repoUser.DeleteWhere(""); err != ErrMissingCriteria {
+		t.Error("expected ErrMissingCriteria, got", err)
+	}
+	n, err = repoUser.DeleteWhere("email like ?", "%@user.com")
+	if err != nil || n != 1 {
+		t.Error("expected 1 row deleted", n, err)
+	}
+	// a SQL comment is criteria like any other, not a way to reach every row
+	if _, err := repoUser.DeleteWhere("--all"); err == nil {
+		t.Error("expected the comment to fail as criteria")
+	}
+	if _, err := repoUser.GetByCriteria(" "); err != ErrMissingCriteria {
+		t.Error("expected GetByCriteria to require criteria, got", err)
+	}
+	if n, err := repoUser.UpdateAll(map[string]interface{}{"first_name": "all"}); err != nil || n != 1 {
+		t.Error("expected 1 row updated", n, err)
+	}
+	n, err = repoUser.DeleteAll()
+	if err != nil || n != 1 {
+		t.Error("expected 1 row deleted", n, err)
+	}
+}
+
+type UsersByGroup struct {
+	GroupID int   `db:"group_id"`
+	Total   int64 `db:"count"`
+	MaxID   int64 `db:"max_id"`
+}
+
+func TestAggregates(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+
+	repoUser := NewRepository[User]()
+	count, err := repoUser.Count("")
+	if err != nil || count != 2 {
+		t.Error("expected 2 users", count, err)
+	}
+	exists, err := repoUser.Exists("email = ?", "admin@admin.com")
+	if err != nil || !exists {
+		t.Error("expected admin to exist", err)
+	}
+	if exists, err := repoUser.Exists("email = ?", "nobody@admin.com"); err != nil || exists {
+		t.Error("expected nobody not to exist", err)
+	}
+	max, _ := repoUser.Max("id", "")
+	if max != 2 {
+		t.Error("expected max id 2, got", max)
+	}
+	if _, err := repoUser.Sum("password", ""); !errors.Is(err, ErrUnknownColumn) {
+		t.Error("expected ErrUnknownColumn, got", err)
+	}
+
+	groups, err := GroupByAs[UsersByGroup](repoUser, []string{"group_id"}, []Aggregate{{Func: "count"}, {Func: "max", Column: "id"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].GroupID != 1 || groups[0].Total != 2 || groups[0].MaxID != 2 {
+		t.Error("unexpected groups", groups)
+	}
+	if _, err := repoUser.GroupBy([]string{"group_id"}, []Aggregate{{Func: "drop"}}, ""); !errors.Is(err, ErrUnknownAggregate) {
+		t.Error("expected ErrUnknownAggregate, got", err)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+
+	repoUser := NewRepository[User]()
+	names := []string{}
+	err := repoUser.ForEach("", func(u *User) error {
+		names = append(names, u.FirstName)
+		return nil
+	})
+	if err != nil || len(names) != 2 {
+		t.Error("expected 2 users", names, err)
+	}
+
+	count := 0
+	err = repoUser.ForEach("group_id = ?", func(u *User) error {
+		count++
+		return ErrStopIteration
+	}, 1)
+	if err != nil || count != 1 {
+		t.Error("expected to stop after 1 user", count, err)
+	}
+}
+
+func TestRelations(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+
+	repoUser := NewRepository[User]()
+	relations := repoUser.Relations()
+	if len(relations) != 2 || relations[0].LinkTable != "user_roles" || relations[0].OwnerColumn != "user_id" || relations[0].TargetColumn != "role_id" {
+		t.Fatalf("unexpected relations %+v", relations)
+	}
+	if relations[1].LinkTable != "" {
+		t.Error("group is not a many-to-many relation")
+	}
+
+	err := repoUser.Link(1, "roles", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, err := repoUser.GetRelation(1, "roles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*roles.(*[]Role)) != 2 {
+		t.Error("expected 2 roles", roles)
+	}
+	// linking again keeps a single pair
+	if err := repoUser.Link(1, "roles", 2); err != nil {
+		t.Fatal(err)
+	}
+	var pairs int
+	if err := config.DB.QueryRow("SELECT COUNT(*) FROM user_roles WHERE user_id = 1 AND role_id = 2").Scan(&pairs); err != nil || pairs != 1 {
+		t.Error("expected one pair", pairs, err)
+	}
+	if err := repoUser.Link(1, "roles", 99); !errors.Is(err, sql.ErrNoRows) {
+		t.Error("expected sql.ErrNoRows for a missing role, got", err)
+	}
+	if err := repoUser.Link(99, "roles", 1); !errors.Is(err, sql.ErrNoRows) {
+		t.Error("expected sql.ErrNoRows for a missing user, got", err)
+	}
+	err = repoUser.Unlink(1, "roles", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, _ = repoUser.GetRelation(1, "roles")
+	if len(*roles.(*[]Role)) != 1 || (*roles.(*[]Role))[0].Name != "user" {
+		t.Error("expected only role user", roles)
+	}
+
+	group, _ := repoUser.GetRelation(1, "group")
+	if group.(*Group).Name != "group1" {
+		t.Error("expected group1", group)
+	}
+	if err := repoUser.Link(1, "group", 2); !errors.Is(err, ErrNotLinkTable) {
+		t.Error("expected ErrNotLinkTable, got", err)
+	}
+	missing, err := repoUser.GetRelation(99, "roles")
+	if err != nil || missing != nil {
+		t.Error("expected nil for a missing user", missing, err)
+	}
+}
+
+type Project struct {
+	ID     *int64  `json:"id" db:"id" s2s_id:"true"`
+	Name   *string `json:"name" db:"name"`
+	Tenant *string `json:"tenant" db:"tenant" s2s_tenant:"true"`
+}
+
+func TestTenant(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+	_, err := config.DB.Exec("CREATE TABLE project (id INTEGER PRIMARY KEY, name TEXT, tenant TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewRepository[Project]()
+	if _, err := repo.GetAll(); !errors.Is(err, ErrMissingTenant) {
+		t.Fatal("expected ErrMissingTenant, got", err)
+	}
+
+	acme := repo.WithContext(WithTenant(context.Background(), "acme"))
+	globex := repo.WithContext(WithTenant(context.Background(), "globex"))
+	name := "p1"
+	id, err := acme.Create(&Project{Name: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := "p2"
+	if _, err := globex.Create(&Project{Name: &other, Tenant: &name}); err != nil {
+		t.Fatal(err)
+	}
+
+	items, _ := acme.GetAll()
+	if len(items) != 1 || *items[0].Tenant != "acme" {
+		t.Fatal("expected only the acme project", items)
+	}
+	if item, _ := globex.GetByID(id); item != nil {
+		t.Error("globex must not read acme projects")
+	}
+	if n, _ := globex.DeleteWhere("name = ? OR 1 = 1", "p1"); n != 1 {
+		t.Error("expected DeleteWhere to delete only the globex project, got", n)
+	}
+	if _, err := acme.UpdateAll(map[string]interface{}{"tenant": "globex"}); !errors.Is(err, ErrTenantColumn) {
+		t.Error("expected ErrTenantColumn, got", err)
+	}
+
+	all := repo.WithContext(WithoutTenant(context.Background()))
+	if count, _ := all.Count(""); count != 1 {
+		t.Error("expected 1 project without tenant scope, got", count)
+	}
+}
+
+type Employee struct {
+	ID     *int64 `json:"id" db:"id" s2s_id:"true"`
+	Name   string `json:"name" db:"name"`
+	Salary int    `json:"salary" db:"salary" s2s_roles:"hr"`
+}
+
+func TestProjectionUpdate(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+	_, err := config.DB.Exec("CREATE TABLE employee (id INTEGER PRIMARY KEY, name TEXT, salary INTEGER)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewRepository[Employee]()
+	id, err := repo.Create(&Employee{Name: "ann", Salary: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staff := repo.WithContext(WithRoles(context.Background(), "staff"))
+	item, err := staff.GetByID(id)
+	if err != nil || item == nil || item.Salary != 0 {
+		t.Fatal("salary must be hidden to staff", item, err)
+	}
+	item.Name = "ann2"
+	if err := staff.Update(item); err != nil {
+		t.Fatal(err)
+	}
+	item.Name = "ann3"
+	if err := staff.UpdateMany([]*Employee{item}); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := repo.GetByID(id)
+	if stored.Name != "ann3" || stored.Salary != 100 {
+		t.Error("hidden columns must keep their stored values", stored)
+	}
+
+	stored.Salary = 200
+	if err := repo.WithContext(WithRoles(context.Background(), "hr")).Update(stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := repo.GetByID(id); stored.Salary != 200 {
+		t.Error("hr can update the salary", stored)
+	}
+}
+
+type Customer struct {
+	ID         *int64  `json:"id" db:"id" s2s_id:"true"`
+	NationalID *string `json:"national_id" db:"national_id" s2s_encrypt:"deterministic"`
+	Phone      string  `json:"phone" db:"phone" s2s_encrypt:"true"`
+}
+
+func TestEncryption(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+	_, err := config.DB.Exec("CREATE TABLE customer (id INTEGER PRIMARY KEY, national_id TEXT, phone TEXT)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryption.Provider = encryption.StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": []byte("0123456789abcdef")}}
+	defer func() { encryption.Provider = nil }()
+
+	repo := NewRepository[Customer]()
+	nationalID := "12345678"
+	id, err := repo.Create(&Customer{NationalID: &nationalID, Phone: "555-1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var storedID, storedPhone string
+	config.DB.QueryRow("SELECT national_id, phone FROM customer WHERE id = ?", id).Scan(&storedID, &storedPhone)
+	if !encryption.IsEncrypted(storedID) || !encryption.IsEncrypted(storedPhone) {
+		t.Fatal("values must be stored encrypted", storedID, storedPhone)
+	}
+
+	items, err := repo.GetByCriteria("national_id = ?", "12345678")
+	if err != nil || len(items) != 1 {
+		t.Fatal("deterministic columns must be searchable", items, err)
+	}
+	if *items[0].NationalID != "12345678" || items[0].Phone != "555-1234" {
+		t.Error("values must be decrypted on read", *items[0].NationalID, items[0].Phone)
+	}
+
+	for _, phone := range []string{"1", "2", "3", "4"} {
+		if _, err := repo.Create(&Customer{Phone: phone}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encryption.Provider = encryption.StaticKeys{Current: "k2", Keys: map[string][]byte{
+		"k1": []byte("0123456789abcdef"), "k2": []byte("fedcba9876543210")}}
+	defer func(batch int) { reencryptBatch = batch }(reencryptBatch)
+	reencryptBatch = 2
+	if n, err := repo.Reencrypt(); err != nil || n != 5 {
+		t.Fatal("expected 5 rows reencrypted", n, err)
+	}
+	var k1 int
+	config.DB.QueryRow("SELECT COUNT(*) FROM customer WHERE phone LIKE 's2s:k1:%'").Scan(&k1)
+	if k1 != 0 {
+		t.Error("expected every row with the current key", k1)
+	}
+	if items, _ := repo.GetByCriteria("national_id = ?", "12345678"); len(items) != 1 {
+		t.Error("deterministic columns must be searchable after the rotation", items)
+	}
+}
+
+func resetHooks() {
+	hooksMu.Lock()
+	txHooks, commitHooks = nil, nil
+	hooksMu.Unlock()
+	auditOnce = sync.Once{}
+	auditTables = nil
+}
+
+func TestAudit(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+	defer resetHooks()
+	if err := CreateAuditTable(); err != nil {
+		t.Fatal(err)
+	}
+	EnableAudit("user")
+	committedChanges := []Change{}
+	OnChangeCommit(func(change Change) {
+		committedChanges = append(committedChanges, change)
+	})
+
+	repoUser := NewRepository[User]().WithContext(WithActor(context.Background(), "ann"))
+	id, err := repoUser.Create(&User{FirstName: "carl", Email: "carl@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := int(*id)
+	if err := repoUser.Update(&User{UserID: &userID, FirstName: "carl", Email: "carl@mail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repoUser.Delete(userID); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := repoUser.History(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[0].Action != ChangeCreate || revisions[1].Action != ChangeUpdate || revisions[2].Action != ChangeDelete {
+		t.Fatalf("expected create, update and delete revisions, got %+v", revisions)
+	}
+	if revisions[1].Actor != "ann" || len(revisions[1].After) != 1 || revisions[1].After["email"] != "carl@mail.com" || revisions[1].Before["email"] != "carl@example.com" {
+		t.Errorf("update revision must hold only the changed column, got %+v", revisions[1])
+	}
+	if len(committedChanges) != 3 {
+		t.Error("expected 3 committed changes, got", len(committedChanges))
+	}
+
+	// writes in a transaction are notified on commit and discarded on rollback
+	repoRole := NewRepository[Role]()
+	CreateTxAndSet(repoRole)
+	repoRole.Create(&Role{Name: "guest"})
+	if len(committedChanges) != 3 {
+		t.Error("changes must wait for the commit")
+	}
+	repoRole.Rollback()
+	CreateTxAndSet(repoRole)
+	repoRole.Create(&Role{Name: "guest"})
+	repoRole.Commit()
+	if len(committedChanges) != 4 || committedChanges[3].Table != "roles" {
+		t.Error("expected the committed role change", committedChanges)
+	}
+	if all, _ := Revisions("table_name = ?", "roles"); len(all) != 0 {
+		t.Error("roles is not audited", all)
+	}
+
+	// failed commits, even with logging, and CommitTx release the held changes
+	repoTx := NewRepository[User]()
+	tx, _ := CreateTxAndSet(repoTx)
+	repoTx.Create(&User{FirstName: "failed"})
+	tx.Rollback()
+	config.FlagLog = true
+	err = repoTx.Commit()
+	config.FlagLog = false
+	if err == nil || len(committedChanges) != 4 {
+		t.Error("expected the commit to fail without notifying", err)
+	}
+	tx, _ = CreateTxAndSet(repoTx)
+	if _, err := repoTx.Create(&User{FirstName: "direct"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CommitTx(tx); err != nil || len(committedChanges) != 5 {
+		t.Error("expected CommitTx to notify the change", err)
+	}
+	pendingMu.Lock()
+	held := len(pending)
+	pendingMu.Unlock()
+	if held != 0 {
+		t.Error("expected no held changes", held)
+	}
+
+	if err := repoUser.UpsertMany([]*User{{FirstName: "x"}}, []string{"missing"}, nil); !errors.Is(err, ErrUnknownColumn) {
+		t.Error("expected ErrUnknownColumn with hooks registered, got", err)
+	}
+	if _, _, err := repoUser.(*Repository[User]).conflictCriteria([]*User{{FirstName: "x"}}, []string{"missing"}); !errors.Is(err, ErrUnknownColumn) {
+		t.Error("expected conflictCriteria to reject unknown columns, got", err)
+	}
+}
+
+type Member struct {
+	ID        *int   `json:"id" db:"id" s2s_table_name:"user"`
+	FirstName string `json:"first_name" db:"first_name"`
+	GroupID   int    `json:"-" db:"group_id" s2s_ref_value:"Group.ID"`
+	Group     Group  `json:"group" s2s:"id = ?" s2s_param:"GroupID"`
+}
+
+func TestRefValueZero(t *testing.T) {
+	config.DB, _ = MockSqlite()
+	defer config.DB.Close()
+	repo := NewRepository[Member]()
+
+	groupID := func(id int64) int {
+		var group int
+		if err := config.DB.QueryRow("SELECT group_id FROM user WHERE id = ?", id).Scan(&group); err != nil {
+			t.Fatal(err)
+		}
+		return group
+	}
+	// a relation left at its zero value keeps the foreign key of the field
+	created, err := repo.Create(&Member{FirstName: "zero", GroupID: 2})
+	if err != nil || groupID(*created) != 2 {
+		t.Fatal("expected group 2 on Create", err)
+	}
+	ids, err := repo.CreateMany([]*Member{{FirstName: "zero", GroupID: 2}})
+	if err != nil || groupID(ids[0]) != 2 {
+		t.Fatal("expected group 2 on CreateMany", err)
+	}
+	id := *created
+	member := &Member{ID: new(int), FirstName: "zero", GroupID: 1}
+	*member.ID = int(id)
+	if err := repo.Update(member); err != nil || groupID(id) != 1 {
+		t.Fatal("expected group 1 on Update", err)
+	}
+	// a sent relation wins over the field
+	member.Group = Group{ID: 2}
+	if err := repo.Update(member); err != nil || groupID(id) != 2 {
+		t.Fatal("expected the group of the relation", err)
+	}
+
+	// the same for a pointer to a zero struct
+	user := &User{FirstName: "zero", GroupId: new(int), MyGroup: &Group{}}
+	*user.GroupId = 2
+	if created, err := NewRepository[User]().Create(user); err != nil || groupID(*created) != 2 {
+		t.Fatal("expected group 2 with a pointer relation", err)
+	}
+}
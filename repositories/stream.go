@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"reflect"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// ErrStopIteration can be returned by a ForEach callback to stop without error.
+var ErrStopIteration = errors.New("stop iteration")
+
+// ForEach scans the rows matching criteria one at a time and calls fn for each of them,
+// so memory does not grow with the size of the result. An empty criteria iterates every row.
+func (r *Repository[T]) ForEach(criteria string, fn func(item *T) error, args ...interface{}) error {
+	where, args, err := r.scopedWhere(criteria, args...)
+	if err != nil {
+		return err
+	}
+	conn, _, err := r.getInternalTxOrConn()
+	if err != nil {
+		return err
+	}
+	if conn != nil {
+		defer conn.Close()
+	}
+
+	query := r.selectAll() + where
+	var rows *sql.Rows
+	if r.tx != nil {
+		rows, err = r.tx.QueryContext(r.ctx, query, args...)
+	} else {
+		rows, err = conn.QueryContext(r.ctx, query, args...)
+	}
+	if err != nil {
+		log.Printf("Error al ejecutar la consulta[018-ForEach]: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	for rows.Next() {
+		v, err := r.scan2(itemType, rows, r.defaultDepth)
+		if err != nil {
+			if config.FlagLog {
+				log.Printf("Error al escanear la fila[019-ForEach]: %v", err)
+			}
+			return err
+		}
+		if err := fn(v.Addr().Interface().(*T)); err != nil {
+			if err == ErrStopIteration {
+				return nil
+			}
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,173 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/utils"
+)
+
+var S2S_TENANT string = "s2s_tenant"
+
+var (
+	ErrMissingTenant = errors.New("tenant is required in the context, use repositories.WithTenant or repositories.WithoutTenant")
+	ErrTenantColumn  = errors.New("tenant column can not be changed")
+)
+
+type tenantKey struct{}
+type bypassTenantKey struct{}
+
+// WithTenant returns a context whose queries are limited to tenant on models with an s2s_tenant:"true" field.
+func WithTenant(ctx context.Context, tenant interface{}) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant stored by WithTenant.
+func TenantFromContext(ctx context.Context) (interface{}, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	tenant := ctx.Value(tenantKey{})
+	return tenant, tenant != nil
+}
+
+// WithoutTenant returns a context that explicitly skips tenant scoping, e.g. for admin tasks.
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassTenantKey{}, true)
+}
+
+// IsTenantBypassed reports whether ctx was returned by WithoutTenant.
+func IsTenantBypassed(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	bypass, _ := ctx.Value(bypassTenantKey{}).(bool)
+	return bypass
+}
+
+// tenantField returns the field and column marked with s2s_tenant:"true".
+func tenantField(itemType reflect.Type) (reflect.StructField, string, bool) {
+	for itemType.Kind() == reflect.Ptr {
+		itemType = itemType.Elem()
+	}
+	if itemType.Kind() != reflect.Struct {
+		return reflect.StructField{}, "", false
+	}
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if field.Tag.Get(S2S_TENANT) == "true" && field.Tag.Get("db") != "" {
+			return field, field.Tag.Get("db"), true
+		}
+	}
+	return reflect.StructField{}, "", false
+}
+
+// tenant returns the tenant of r.ctx; active is false when T is not tenant scoped or the scope is bypassed.
+func (r *Repository[T]) tenant() (tenant interface{}, active bool, err error) {
+	return tenantOf(r.ctx, r.tenantColumn)
+}
+
+func tenantOf(ctx context.Context, column string) (interface{}, bool, error) {
+	if column == "" || IsTenantBypassed(ctx) {
+		return nil, false, nil
+	}
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, false, ErrMissingTenant
+	}
+	return tenant, true, nil
+}
+
+// byTenant appends "AND tenant = ?" to a query ending in a WHERE condition.
+func (r *Repository[T]) byTenant(query string, args ...interface{}) (string, []interface{}, error) {
+	tenant, active, err := r.tenant()
+	if err != nil || !active {
+		return query, args, err
+	}
+	return query + " AND " + r.tenantColumn + " = ?", append(args, tenant), nil
+}
+
+var criteriaTailRegex = regexp.MustCompile(`(?i)\s*\b(order\s+by|group\s+by|limit)\b`)
+
+// scopedWhere works like whereClause but adds the tenant condition and encrypts the arguments
+// compared with deterministic encrypted columns.
+func (r *Repository[T]) scopedWhere(criteria string, args ...interface{}) (string, []interface{}, error) {
+	args, err := r.encryptArgs(criteria, args)
+	if err != nil {
+		return "", nil, err
+	}
+	tenant, active, err := r.tenant()
+	if err != nil || !active {
+		return whereClause(criteria), args, err
+	}
+
+	condition := strings.TrimSpace(criteria)
+	if strings.HasPrefix(strings.ToLower(condition), "where") {
+		condition = strings.TrimSpace(condition[len("where"):])
+	}
+	// ORDER BY, GROUP BY and LIMIT must stay after the whole condition
+	tail := ""
+	if loc := criteriaTailRegex.FindStringIndex(condition); loc != nil {
+		condition, tail = strings.TrimSpace(condition[:loc[0]]), " "+strings.TrimSpace(condition[loc[0]:])
+	}
+	tenantCondition := r.tenantColumn + " = ?"
+	args = append(append([]interface{}{}, args...), tenant)
+	if condition == "" {
+		return " WHERE " + tenantCondition + tail, args, nil
+	}
+	return " WHERE (" + condition + ") AND " + tenantCondition + tail, args, nil
+}
+
+// scopeRelation limits the s2s query of a relation to the tenant of ctx when the related model is tenant scoped.
+// The query must select the tenant column.
+func scopeRelation(ctx context.Context, fieldType reflect.Type, query string, args []interface{}) (string, []interface{}, error) {
+	for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+	_, column, ok := tenantField(fieldType)
+	if !ok {
+		return query, args, nil
+	}
+	tenant, active, err := tenantOf(ctx, column)
+	if err != nil || !active {
+		return query, args, err
+	}
+	return "SELECT * FROM (" + query + ") s2s_tenant WHERE " + column + " = ?", append(args, tenant), nil
+}
+
+// fillTenant sets the tenant field of item from r.ctx so rows are always written to the current tenant.
+func (r *Repository[T]) fillTenant(item *T) error {
+	tenant, active, err := r.tenant()
+	if err != nil || !active {
+		return err
+	}
+	field := reflect.ValueOf(item).Elem().FieldByName(r.tagName[r.tenantColumn])
+	return setValue(field, tenant)
+}
+
+func setValue(field reflect.Value, value interface{}) error {
+	if field.Kind() == reflect.Ptr {
+		ptr := reflect.New(field.Type().Elem())
+		if err := setValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(ptr)
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Type().AssignableTo(field.Type()):
+		field.Set(v)
+	case v.Kind() == reflect.String:
+		return utils.SetFromString(field, v.String())
+	case v.Type().ConvertibleTo(field.Type()):
+		field.Set(v.Convert(field.Type()))
+	default:
+		return fmt.Errorf("tenant of type %s can not be set on %s", v.Type(), field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// Upsert inserts item or, when a row with the same conflictColumns exists ("id" by default),
+// updates every other column.
+func (r *Repository[T]) Upsert(item *T, conflictColumns ...string) error {
+	return r.UpsertMany([]*T{item}, conflictColumns, nil)
+}
+
+// UpsertColumns works like Upsert but only updates updateColumns on conflict.
+func (r *Repository[T]) UpsertColumns(item *T, conflictColumns []string, updateColumns []string) error {
+	return r.UpsertMany([]*T{item}, conflictColumns, updateColumns)
+}
+
+// UpsertMany upserts items in batches inside a single transaction. When updateColumns is empty
+// every column except the conflict columns and the id column is updated.
+func (r *Repository[T]) UpsertMany(items []*T, conflictColumns []string, updateColumns []string) error {
+	if len(items) == 0 {
+		return nil
+	}
+	if len(conflictColumns) == 0 {
+		conflictColumns = []string{r.idColumn()}
+	}
+	if err := r.checkColumns(conflictColumns); err != nil {
+		return err
+	}
+	if err := r.checkColumns(updateColumns); err != nil {
+		return err
+	}
+	if len(updateColumns) == 0 {
+		updateColumns = r.upsertDefaultColumns(conflictColumns)
+	}
+	if r.tracks() {
+		return r.withChanges(func(repo *Repository[T]) ([]Change, error) {
+			before, err := repo.snapshotConflicts(items, conflictColumns)
+			if err != nil {
+				return nil, err
+			}
+			if err := repo.UpsertMany(items, conflictColumns, updateColumns); err != nil {
+				return nil, err
+			}
+			after, err := repo.snapshotConflicts(items, conflictColumns)
+			if err != nil {
+				return nil, err
+			}
+			return repo.changesOf(before, after), nil
+		})
+	}
+	_, active, err := r.tenant()
+	if err != nil {
+		return err
+	}
+	tenantColumn := ""
+	if active {
+		tenantColumn = r.tenantColumn
+		for _, item := range items {
+			if err := r.fillTenant(item); err != nil {
+				return err
+			}
+		}
+	}
+	suffix := upsertSuffix(r.table, conflictColumns, updateColumns, tenantColumn)
+
+	batchSize := r.batchSize()
+	err = r.runInTx(func(tx *sql.Tx) error {
+		for start := 0; start < len(items); start += batchSize {
+			end := start + batchSize
+			if end > len(items) {
+				end = len(items)
+			}
+			query, fieldsValues, err := r.insertBatchSQL(items[start:end])
+			if err != nil {
+				return err
+			}
+			query += suffix
+			if config.FlagLog {
+				log.Println(query, fieldsValues)
+			}
+			if _, err := tx.ExecContext(r.ctx, query, fieldsValues...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error al insertar o actualizar los items[013-UpsertMany]: %v", err)
+	}
+	return err
+}
+
+// conflictCriteria matches the stored rows that conflict with items, "(a = ? AND b = ?) OR ...".
+func (r *Repository[T]) conflictCriteria(items []*T, conflictColumns []string) (string, []interface{}, error) {
+	if err := r.checkColumns(conflictColumns); err != nil {
+		return "", nil, err
+	}
+	conditions := make([]string, 0, len(items))
+	args := make([]interface{}, 0, len(items)*len(conflictColumns))
+	condition := "(" + strings.Join(conflictColumns, " = ? AND ") + " = ?)"
+	for _, item := range items {
+		itemValue := reflect.ValueOf(item).Elem()
+		for _, column := range conflictColumns {
+			args = append(args, itemValue.FieldByName(r.tagName[column]).Interface())
+		}
+		conditions = append(conditions, condition)
+	}
+	return strings.Join(conditions, " OR "), args, nil
+}
+
+// snapshotConflicts snapshots the rows that conflict with items, in batches that fit in
+// config.MaxParams() with the tenant argument.
+func (r *Repository[T]) snapshotConflicts(items []*T, conflictColumns []string) (map[string]map[string]interface{}, error) {
+	result := map[string]map[string]interface{}{}
+	batchSize := (config.MaxParams() - 1) / len(conflictColumns)
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		criteria, args, err := r.conflictCriteria(items[start:end], conflictColumns)
+		if err != nil {
+			return nil, err
+		}
+		rows, _, err := r.snapshot(criteria, args...)
+		if err != nil {
+			return nil, err
+		}
+		for id, row := range rows {
+			result[id] = row
+		}
+	}
+	return result, nil
+}
+
+// checkColumns rejects the columns that are not db columns of T, as they are written into the SQL.
+func (r *Repository[T]) checkColumns(columns []string) error {
+	for _, column := range columns {
+		if _, ok := r.tagName[column]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownColumn, column)
+		}
+	}
+	return nil
+}
+
+func (r *Repository[T]) upsertDefaultColumns(conflictColumns []string) []string {
+	skip := map[string]bool{r.idColumn(): true}
+	for _, c := range conflictColumns {
+		skip[c] = true
+	}
+	columns := []string{}
+	for _, tag := range r.tags {
+		if !skip[tag] {
+			columns = append(columns, tag)
+		}
+	}
+	return columns
+}
+
+// upsertSuffix returns the ON CONFLICT / ON DUPLICATE KEY clause for the current dialect.
+// When tenantColumn is set, rows of other tenants are never overwritten.
+func upsertSuffix(table string, conflictColumns []string, updateColumns []string, tenantColumn string) string {
+	if tenantColumn != "" {
+		columns := make([]string, 0, len(updateColumns))
+		for _, c := range updateColumns {
+			if c != tenantColumn {
+				columns = append(columns, c)
+			}
+		}
+		updateColumns = columns
+	}
+	sets := make([]string, 0, len(updateColumns))
+	if config.Dialect == config.DialectMySQL {
+		for _, c := range updateColumns {
+			if tenantColumn != "" {
+				sets = append(sets, c+" = IF("+tenantColumn+" = VALUES("+tenantColumn+"), VALUES("+c+"), "+c+")")
+				continue
+			}
+			sets = append(sets, c+" = VALUES("+c+")")
+		}
+		if len(sets) == 0 {
+			// MySQL has no DO NOTHING, a no-op assignment keeps the existing row
+			sets = append(sets, conflictColumns[0]+" = "+conflictColumns[0])
+		}
+		return " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ", ")
+	}
+
+	suffix := " ON CONFLICT (" + strings.Join(conflictColumns, ", ") + ")"
+	if len(updateColumns) == 0 {
+		return suffix + " DO NOTHING"
+	}
+	for _, c := range updateColumns {
+		sets = append(sets, c+" = excluded."+c)
+	}
+	suffix += " DO UPDATE SET " + strings.Join(sets, ", ")
+	if tenantColumn != "" {
+		suffix += " WHERE " + table + "." + tenantColumn + " = excluded." + tenantColumn
+	}
+	return suffix
+}
+
+// idColumn returns the db column of the id field, "id" by default.
+func (r *Repository[T]) idColumn() string {
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	field, ok := itemType.FieldByName(idFieldName(itemType))
+	if !ok {
+		return "id"
+	}
+	if tag := field.Tag.Get("db"); tag != "" {
+		return tag
+	}
+	return "id"
+}
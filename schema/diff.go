@@ -0,0 +1,284 @@
+package schema
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// Options changes what Diff generates.
+type Options struct {
+	// DropColumns drops the columns not mapped by the models; by default they are listed as comments.
+	DropColumns bool
+}
+
+// Migration holds the statements that take the database to the models (Up) and back (Down).
+// Changes the dialect can not make, like altering a column type in SQLite, are written as comments.
+type Migration struct {
+	Version string
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Empty reports whether the migration has no statement to run.
+func (m *Migration) Empty() bool {
+	for _, statement := range m.Up {
+		if !strings.HasPrefix(statement, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateSQL returns the statements that create tables and their indexes in dialect.
+func CreateSQL(dialect string, tables ...Table) []string {
+	statements := []string{}
+	for _, table := range sortByReferences(append([]Table{}, tables...)) {
+		statements = append(statements, createTable(table, dialect))
+		for _, index := range table.Indexes {
+			statements = append(statements, createIndex(table.Name, index))
+		}
+	}
+	return statements
+}
+
+// Diff compares the tables wanted by the models with the live ones. Live tables that no model maps
+// are kept, as are their indexes not declared in the models.
+func Diff(wanted []Table, live map[string]Table, dialect string, options Options) *Migration {
+	m := &Migration{}
+	down := [][]string{}
+	add := func(up string, revert ...string) {
+		m.Up = append(m.Up, up)
+		down = append(down, revert)
+	}
+
+	for _, table := range sortByReferences(append([]Table{}, wanted...)) {
+		current, exists := live[table.Name]
+		if !exists {
+			add(createTable(table, dialect), "DROP TABLE "+table.Name)
+			for _, index := range table.Indexes {
+				add(createIndex(table.Name, index))
+			}
+			continue
+		}
+
+		for _, column := range table.Columns {
+			liveColumn, ok := current.Column(column.Name)
+			if !ok {
+				add("ALTER TABLE "+table.Name+" ADD COLUMN "+columnDefinition(addedColumn(column, dialect), dialect),
+					"ALTER TABLE "+table.Name+" DROP COLUMN "+column.Name)
+				continue
+			}
+			if column.PrimaryKey {
+				continue
+			}
+			if !Compatible(column.Type, liveColumn.Type) || column.Nullable != liveColumn.Nullable {
+				up, revert := alterColumn(table.Name, column, liveColumn, dialect)
+				add(up, revert...)
+			}
+		}
+		for _, liveColumn := range current.Columns {
+			if _, ok := table.Column(liveColumn.Name); ok {
+				continue
+			}
+			drop := "ALTER TABLE " + table.Name + " DROP COLUMN " + liveColumn.Name
+			if !options.DropColumns {
+				m.Up = append(m.Up, "-- "+table.Name+"."+liveColumn.Name+" is not mapped by the model: "+drop)
+				down = append(down, nil)
+				continue
+			}
+			liveColumn.PrimaryKey = false
+			add(drop, "ALTER TABLE "+table.Name+" ADD COLUMN "+columnDefinition(liveColumn, dialect))
+		}
+
+		for _, fk := range table.ForeignKeys {
+			if hasForeignKey(current, fk) {
+				continue
+			}
+			name := "fk_" + table.Name + "_" + fk.Column
+			up := "ALTER TABLE " + table.Name + " ADD CONSTRAINT " + name + " FOREIGN KEY (" + fk.Column + ") REFERENCES " + fk.RefTable + " (" + fk.RefColumn + ")"
+			switch dialect {
+			case config.DialectSQLite:
+				m.Up = append(m.Up, "-- SQLite can not add a foreign key to an existing table, rebuild "+table.Name+": "+up)
+				down = append(down, nil)
+			case config.DialectMySQL:
+				add(up, "ALTER TABLE "+table.Name+" DROP FOREIGN KEY "+name)
+			default:
+				add(up, "ALTER TABLE "+table.Name+" DROP CONSTRAINT "+name)
+			}
+		}
+
+		for _, index := range table.Indexes {
+			if hasIndex(current, index) {
+				continue
+			}
+			add(createIndex(table.Name, index), dropIndex(table.Name, index.Name, dialect))
+		}
+	}
+
+	for i := len(down) - 1; i >= 0; i-- {
+		m.Down = append(m.Down, down[i]...)
+	}
+	return m
+}
+
+func createTable(table Table, dialect string) string {
+	definitions := []string{}
+	for _, column := range table.Columns {
+		definitions = append(definitions, columnDefinition(column, dialect))
+	}
+	for _, fk := range table.ForeignKeys {
+		definitions = append(definitions, "FOREIGN KEY ("+fk.Column+") REFERENCES "+fk.RefTable+" ("+fk.RefColumn+")")
+	}
+	return "CREATE TABLE " + table.Name + " (\n\t" + strings.Join(definitions, ",\n\t") + "\n)"
+}
+
+func columnDefinition(column Column, dialect string) string {
+	if column.PrimaryKey && column.AutoIncrement {
+		switch dialect {
+		case config.DialectPostgres:
+			if Family(column.Type) == "integer" && strings.Contains(strings.ToUpper(column.Type), "BIG") {
+				return column.Name + " BIGSERIAL PRIMARY KEY"
+			}
+			return column.Name + " SERIAL PRIMARY KEY"
+		case config.DialectMySQL:
+			return column.Name + " " + column.Type + " AUTO_INCREMENT PRIMARY KEY"
+		default:
+			return column.Name + " INTEGER PRIMARY KEY"
+		}
+	}
+	definition := column.Name + " " + column.Type
+	if column.PrimaryKey {
+		return definition + " PRIMARY KEY"
+	}
+	if !column.Nullable {
+		definition += " NOT NULL"
+	}
+	return definition
+}
+
+// addedColumn gives a default to the NOT NULL columns added to tables that may have rows.
+func addedColumn(column Column, dialect string) Column {
+	if column.Nullable {
+		return column
+	}
+	switch Family(column.Type) {
+	case "integer", "real":
+		column.Type += " DEFAULT 0"
+	case "boolean":
+		if dialect == config.DialectPostgres {
+			column.Type += " DEFAULT FALSE"
+		} else {
+			column.Type += " DEFAULT 0"
+		}
+	case "text":
+		column.Type += " DEFAULT ''"
+	case "time":
+		column.Type += " DEFAULT '1970-01-01 00:00:00'"
+	default:
+		column.Nullable = true
+	}
+	return column
+}
+
+func alterColumn(table string, column Column, live Column, dialect string) (string, []string) {
+	switch dialect {
+	case config.DialectPostgres:
+		alter := func(c Column) string {
+			nullability := "SET NOT NULL"
+			if c.Nullable {
+				nullability = "DROP NOT NULL"
+			}
+			return "ALTER TABLE " + table + " ALTER COLUMN " + c.Name + " TYPE " + c.Type + " USING " + c.Name + "::" + c.Type +
+				", ALTER COLUMN " + c.Name + " " + nullability
+		}
+		return alter(column), []string{alter(live)}
+	case config.DialectMySQL:
+		return "ALTER TABLE " + table + " MODIFY COLUMN " + columnDefinition(column, dialect),
+			[]string{"ALTER TABLE " + table + " MODIFY COLUMN " + columnDefinition(live, dialect)}
+	}
+	return fmt.Sprintf("-- SQLite can not alter %s.%s from %s to %s, rebuild the table", table, column.Name,
+		columnDefinition(live, dialect), columnDefinition(column, dialect)), nil
+}
+
+func createIndex(table string, index Index) string {
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+	return "CREATE " + unique + "INDEX " + index.Name + " ON " + table + " (" + strings.Join(index.Columns, ", ") + ")"
+}
+
+func dropIndex(table string, name string, dialect string) string {
+	if dialect == config.DialectMySQL {
+		return "DROP INDEX " + name + " ON " + table
+	}
+	return "DROP INDEX " + name
+}
+
+func hasForeignKey(table Table, fk ForeignKey) bool {
+	for _, current := range table.ForeignKeys {
+		if current.Column == fk.Column && current.RefTable == fk.RefTable && current.RefColumn == fk.RefColumn {
+			return true
+		}
+	}
+	return false
+}
+
+// hasIndex matches indexes by columns and uniqueness, whatever their name.
+func hasIndex(table Table, index Index) bool {
+	for _, current := range table.Indexes {
+		if current.Unique == index.Unique && strings.Join(current.Columns, ",") == strings.Join(index.Columns, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+var migrationNameRegex = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// Generate diffs the registered models against config.DB and writes the migration to dir as
+// <version>_<name>.up.sql and <version>_<name>.down.sql. It returns nil when there is nothing to change.
+func Generate(dir string, name string, options Options) (*Migration, error) {
+	live, err := Introspect(config.DB, config.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	m := Diff(Tables(config.Dialect), live, config.Dialect, options)
+	if m.Empty() {
+		return nil, nil
+	}
+	m.Version = time.Now().UTC().Format("20060102150405")
+	m.Name = strings.Trim(migrationNameRegex.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	return m, m.Write(dir)
+}
+
+// Write writes the up and down files of m to dir.
+func (m *Migration) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	base := filepath.Join(dir, m.Version+"_"+m.Name)
+	if err := os.WriteFile(base+".up.sql", []byte(joinStatements(m.Up)), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(base+".down.sql", []byte(joinStatements(m.Down)), 0o644)
+}
+
+func joinStatements(statements []string) string {
+	var b strings.Builder
+	for _, statement := range statements {
+		b.WriteString(statement)
+		if !strings.HasPrefix(statement, "--") {
+			b.WriteString(";")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
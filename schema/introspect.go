@@ -0,0 +1,272 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+)
+
+// Introspect reads the tables of db for dialect, keyed by name.
+func Introspect(db *sql.DB, dialect string) (map[string]Table, error) {
+	switch dialect {
+	case config.DialectSQLite:
+		return introspectSQLite(db)
+	case config.DialectPostgres:
+		return introspectPostgres(db)
+	case config.DialectMySQL:
+		return introspectMySQL(db)
+	}
+	return nil, fmt.Errorf("unknown dialect %s", dialect)
+}
+
+func queryStrings(db *sql.DB, query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, rows.Err()
+}
+
+func introspectSQLite(db *sql.DB) (map[string]Table, error) {
+	names, err := queryStrings(db, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	tables := map[string]Table{}
+	for _, name := range names {
+		table := Table{Name: name}
+
+		rows, err := db.Query("PRAGMA table_info(" + name + ")")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var cid, notNull, pk int
+			var column, columnType string
+			var defaultValue sql.NullString
+			if err := rows.Scan(&cid, &column, &columnType, &notNull, &defaultValue, &pk); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			integerKey := pk > 0 && strings.EqualFold(columnType, "INTEGER")
+			table.Columns = append(table.Columns, Column{
+				Name:          column,
+				Type:          columnType,
+				Nullable:      notNull == 0 && pk == 0,
+				PrimaryKey:    pk > 0,
+				AutoIncrement: integerKey,
+			})
+		}
+		rows.Close()
+
+		rows, err = db.Query("PRAGMA foreign_key_list(" + name + ")")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, seq int
+			var refTable, from string
+			var to, onUpdate, onDelete, match sql.NullString
+			if err := rows.Scan(&id, &seq, &refTable, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			refColumn := to.String
+			if refColumn == "" {
+				refColumn = "id"
+			}
+			table.ForeignKeys = append(table.ForeignKeys, ForeignKey{Column: from, RefTable: refTable, RefColumn: refColumn})
+		}
+		rows.Close()
+
+		rows, err = db.Query("PRAGMA index_list(" + name + ")")
+		if err != nil {
+			return nil, err
+		}
+		indexes := []Index{}
+		for rows.Next() {
+			var seq, unique, partial int
+			var index, origin string
+			if err := rows.Scan(&seq, &index, &unique, &origin, &partial); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if origin != "pk" {
+				indexes = append(indexes, Index{Name: index, Unique: unique == 1})
+			}
+		}
+		rows.Close()
+		for _, index := range indexes {
+			rows, err := db.Query("PRAGMA index_info(" + index.Name + ")")
+			if err != nil {
+				return nil, err
+			}
+			for rows.Next() {
+				var seqno, cid int
+				var column sql.NullString
+				if err := rows.Scan(&seqno, &cid, &column); err != nil {
+					rows.Close()
+					return nil, err
+				}
+				index.Columns = append(index.Columns, column.String)
+			}
+			rows.Close()
+			table.Indexes = append(table.Indexes, index)
+		}
+		sort.Slice(table.Indexes, func(i, j int) bool { return table.Indexes[i].Name < table.Indexes[j].Name })
+		tables[name] = table
+	}
+	return tables, nil
+}
+
+// introspectColumns fills tables with rows of table, column, type, nullable ("YES"/"NO"), primary key and
+// auto increment flags.
+func introspectColumns(db *sql.DB, query string, tables map[string]Table) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, column, columnType, nullable string
+		var pk, auto bool
+		if err := rows.Scan(&name, &column, &columnType, &nullable, &pk, &auto); err != nil {
+			return err
+		}
+		table := tables[name]
+		table.Name = name
+		table.Columns = append(table.Columns, Column{
+			Name:          column,
+			Type:          columnType,
+			Nullable:      nullable == "YES",
+			PrimaryKey:    pk,
+			AutoIncrement: auto,
+		})
+		tables[name] = table
+	}
+	return rows.Err()
+}
+
+// introspectForeignKeys reads rows of table, column, referenced table and referenced column.
+func introspectForeignKeys(db *sql.DB, query string, tables map[string]Table) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var fk ForeignKey
+		if err := rows.Scan(&name, &fk.Column, &fk.RefTable, &fk.RefColumn); err != nil {
+			return err
+		}
+		if table, ok := tables[name]; ok {
+			table.ForeignKeys = append(table.ForeignKeys, fk)
+			tables[name] = table
+		}
+	}
+	return rows.Err()
+}
+
+// introspectIndexes reads rows of table, index, unique and column, ordered by table, index and position.
+func introspectIndexes(db *sql.DB, query string, tables map[string]Table) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, index, column string
+		var unique bool
+		if err := rows.Scan(&name, &index, &unique, &column); err != nil {
+			return err
+		}
+		table, ok := tables[name]
+		if !ok {
+			continue
+		}
+		if n := len(table.Indexes); n > 0 && table.Indexes[n-1].Name == index {
+			table.Indexes[n-1].Columns = append(table.Indexes[n-1].Columns, column)
+		} else {
+			table.Indexes = append(table.Indexes, Index{Name: index, Unique: unique, Columns: []string{column}})
+		}
+		tables[name] = table
+	}
+	return rows.Err()
+}
+
+func introspectPostgres(db *sql.DB) (map[string]Table, error) {
+	tables := map[string]Table{}
+	err := introspectColumns(db, `SELECT c.table_name, c.column_name, c.data_type, c.is_nullable,
+		EXISTS (SELECT 1 FROM information_schema.table_constraints tc
+			JOIN information_schema.key_column_usage k ON k.constraint_name = tc.constraint_name AND k.table_schema = tc.table_schema
+			WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema AND tc.table_name = c.table_name AND k.column_name = c.column_name),
+		COALESCE(c.column_default LIKE 'nextval(%', false) OR c.is_identity = 'YES'
+		FROM information_schema.columns c JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = current_schema() AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name, c.ordinal_position`, tables)
+	if err != nil {
+		return nil, err
+	}
+	err = introspectForeignKeys(db, `SELECT k.table_name, k.column_name, u.table_name, u.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage k ON k.constraint_name = tc.constraint_name AND k.table_schema = tc.table_schema
+		JOIN information_schema.constraint_column_usage u ON u.constraint_name = tc.constraint_name AND u.table_schema = tc.table_schema
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema()
+		ORDER BY k.table_name, k.column_name`, tables)
+	if err != nil {
+		return nil, err
+	}
+	err = introspectIndexes(db, `SELECT t.relname, i.relname, ix.indisunique, a.attname
+		FROM pg_class t
+		JOIN pg_index ix ON ix.indrelid = t.oid
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, position) ON true
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		WHERE n.nspname = current_schema() AND NOT ix.indisprimary
+		ORDER BY t.relname, i.relname, k.position`, tables)
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+func introspectMySQL(db *sql.DB) (map[string]Table, error) {
+	tables := map[string]Table{}
+	err := introspectColumns(db, `SELECT c.table_name, c.column_name, c.column_type, c.is_nullable,
+		c.column_key = 'PRI', c.extra LIKE '%auto_increment%'
+		FROM information_schema.columns c JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = DATABASE() AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name, c.ordinal_position`, tables)
+	if err != nil {
+		return nil, err
+	}
+	err = introspectForeignKeys(db, `SELECT table_name, column_name, referenced_table_name, referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND referenced_table_name IS NOT NULL
+		ORDER BY table_name, column_name`, tables)
+	if err != nil {
+		return nil, err
+	}
+	err = introspectIndexes(db, `SELECT table_name, index_name, non_unique = 0, column_name
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND index_name <> 'PRIMARY'
+		ORDER BY table_name, index_name, seq_in_index`, tables)
+	if err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
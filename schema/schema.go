@@ -0,0 +1,318 @@
+package schema
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+var (
+	// S2S_TYPE overrides the column type, e.g. s2s_type:"VARCHAR(120)".
+	S2S_TYPE string = "s2s_type"
+	// S2S_FK declares a foreign key, e.g. s2s_fk:"groups(id)"; fields with s2s_ref_value get one from the relation.
+	S2S_FK string = "s2s_fk"
+	// S2S_INDEX adds the column to an index, "true" or the index name to share it with other columns.
+	S2S_INDEX string = "s2s_index"
+	// S2S_UNIQUE works like S2S_INDEX for unique indexes.
+	S2S_UNIQUE string = "s2s_unique"
+)
+
+type Column struct {
+	Name          string
+	Type          string
+	Nullable      bool
+	PrimaryKey    bool
+	AutoIncrement bool
+}
+
+type ForeignKey struct {
+	Column    string
+	RefTable  string
+	RefColumn string
+}
+
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+}
+
+// Table is the definition of a table, derived from a model or read from the database.
+type Table struct {
+	Name        string
+	Columns     []Column
+	ForeignKeys []ForeignKey
+	Indexes     []Index
+}
+
+// Column returns the column name of t.
+func (t Table) Column(name string) (Column, bool) {
+	for _, column := range t.Columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
+
+// Register adds models, given as values like User{} or &User{}, to repositories.Models, the models
+// used by Tables, Generate and Validate; creating the repository of a model registers it too.
+func Register(values ...interface{}) {
+	repositories.Register(values...)
+}
+
+// Models returns the registered models, see repositories.Models.
+func Models() []reflect.Type {
+	return repositories.Models()
+}
+
+// Tables returns the tables of the registered models for dialect, referenced tables first.
+func Tables(dialect string) []Table {
+	tables := []Table{}
+	for _, model := range Models() {
+		tables = append(tables, TableOf(model, dialect))
+	}
+	return sortByReferences(tables)
+}
+
+// TableOf derives the table of model: the columns of the db fields, NOT NULL unless the field is a pointer
+// or a sql.Null type, the primary key of the s2s_id field (ID by default), the foreign keys and the indexes.
+func TableOf(model reflect.Type, dialect string) Table {
+	table := Table{Name: repositories.TableName(model)}
+	idField := "ID"
+	for i := 0; i < model.NumField(); i++ {
+		if model.Field(i).Tag.Get(repositories.S2S_ID) == "true" {
+			idField = model.Field(i).Name
+		}
+	}
+	indexes := map[string]*Index{}
+	indexNames := []string{}
+	addIndex := func(name string, column string, unique bool) {
+		if name == "true" {
+			prefix := "idx_"
+			if unique {
+				prefix = "uq_"
+			}
+			name = prefix + table.Name + "_" + column
+		}
+		index, ok := indexes[name]
+		if !ok {
+			index = &Index{Name: name, Unique: unique}
+			indexes[name] = index
+			indexNames = append(indexNames, name)
+		}
+		index.Columns = append(index.Columns, column)
+	}
+
+	for i := 0; i < model.NumField(); i++ {
+		field := model.Field(i)
+		name := field.Tag.Get("db")
+		if name == "" || name == "-" || field.Tag.Get(repositories.S2S) != "" {
+			continue
+		}
+		fieldType, nullable := baseType(field.Type)
+		column := Column{Name: name, Nullable: nullable, Type: field.Tag.Get(S2S_TYPE)}
+		if field.Name == idField {
+			column.PrimaryKey = true
+			column.Nullable = false
+			column.AutoIncrement = column.Type == "" && isInteger(fieldType)
+		}
+		if column.Type == "" {
+			column.Type = ColumnType(fieldType, dialect)
+		}
+		table.Columns = append(table.Columns, column)
+
+		if fk, ok := foreignKey(model, field); ok {
+			fk.Column = name
+			table.ForeignKeys = append(table.ForeignKeys, fk)
+		}
+		if index := field.Tag.Get(S2S_INDEX); index != "" {
+			addIndex(index, name, false)
+		}
+		if index := field.Tag.Get(S2S_UNIQUE); index != "" {
+			addIndex(index, name, true)
+		}
+	}
+	for _, name := range indexNames {
+		table.Indexes = append(table.Indexes, *indexes[name])
+	}
+	return table
+}
+
+// foreignKey reads the s2s_fk tag, "table(column)" or "table.column", or follows s2s_ref_value:"Relation.Field".
+func foreignKey(model reflect.Type, field reflect.StructField) (ForeignKey, bool) {
+	if tag := field.Tag.Get(S2S_FK); tag != "" {
+		tag = strings.TrimSuffix(strings.NewReplacer("(", ".", " ", "").Replace(tag), ")")
+		parts := strings.SplitN(tag, ".", 2)
+		fk := ForeignKey{RefTable: parts[0], RefColumn: "id"}
+		if len(parts) == 2 {
+			fk.RefColumn = parts[1]
+		}
+		return fk, true
+	}
+	parts := strings.SplitN(field.Tag.Get(repositories.S2S_REF_VALUE), ".", 2)
+	if len(parts) != 2 {
+		return ForeignKey{}, false
+	}
+	relation, ok := model.FieldByName(parts[0])
+	if !ok {
+		return ForeignKey{}, false
+	}
+	relationType := relation.Type
+	for relationType.Kind() == reflect.Ptr {
+		relationType = relationType.Elem()
+	}
+	if relationType.Kind() != reflect.Struct {
+		return ForeignKey{}, false
+	}
+	refField, ok := relationType.FieldByName(parts[1])
+	if !ok || refField.Tag.Get("db") == "" {
+		return ForeignKey{}, false
+	}
+	return ForeignKey{RefTable: repositories.TableName(relationType), RefColumn: refField.Tag.Get("db")}, true
+}
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	nullTypes = map[reflect.Type]reflect.Type{
+		reflect.TypeOf(sql.NullString{}):  reflect.TypeOf(""),
+		reflect.TypeOf(sql.NullInt64{}):   reflect.TypeOf(int64(0)),
+		reflect.TypeOf(sql.NullInt32{}):   reflect.TypeOf(int32(0)),
+		reflect.TypeOf(sql.NullInt16{}):   reflect.TypeOf(int16(0)),
+		reflect.TypeOf(sql.NullByte{}):    reflect.TypeOf(byte(0)),
+		reflect.TypeOf(sql.NullFloat64{}): reflect.TypeOf(float64(0)),
+		reflect.TypeOf(sql.NullBool{}):    reflect.TypeOf(false),
+		reflect.TypeOf(sql.NullTime{}):    timeType,
+	}
+)
+
+// baseType returns the type stored by a field and whether it can hold NULL.
+func baseType(fieldType reflect.Type) (reflect.Type, bool) {
+	nullable := false
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+		nullable = true
+	}
+	if base, ok := nullTypes[fieldType]; ok {
+		return base, true
+	}
+	return fieldType, nullable
+}
+
+func isInteger(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// ColumnType returns the column type of the Go type t for dialect.
+func ColumnType(t reflect.Type, dialect string) string {
+	switch {
+	case t == timeType:
+		if dialect == config.DialectMySQL {
+			return "DATETIME"
+		}
+		return "TIMESTAMP"
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		if dialect == config.DialectPostgres {
+			return "BYTEA"
+		}
+		return "BLOB"
+	case isInteger(t):
+		if dialect == config.DialectSQLite {
+			return "INTEGER"
+		}
+		switch t.Kind() {
+		case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+			return "BIGINT"
+		}
+		return "INTEGER"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Float32:
+		return "REAL"
+	case reflect.Float64:
+		switch dialect {
+		case config.DialectPostgres:
+			return "DOUBLE PRECISION"
+		case config.DialectMySQL:
+			return "DOUBLE"
+		}
+		return "REAL"
+	case reflect.String:
+		if dialect == config.DialectMySQL {
+			return "VARCHAR(255)"
+		}
+		return "TEXT"
+	}
+	return "TEXT"
+}
+
+// Family groups column types that store the same values: integer, real, boolean, text, time and blob.
+func Family(columnType string) string {
+	t := strings.ToUpper(columnType)
+	switch {
+	case strings.HasPrefix(t, "TINYINT(1)") || strings.Contains(t, "BOOL"):
+		return "boolean"
+	case strings.Contains(t, "INT") || strings.Contains(t, "SERIAL"):
+		return "integer"
+	case strings.Contains(t, "CHAR") || strings.Contains(t, "TEXT") || strings.Contains(t, "CLOB") || strings.Contains(t, "JSON") || strings.Contains(t, "UUID"):
+		return "text"
+	case strings.Contains(t, "REAL") || strings.Contains(t, "FLOA") || strings.Contains(t, "DOUB") || strings.Contains(t, "NUMERIC") || strings.Contains(t, "DECIMAL"):
+		return "real"
+	case strings.Contains(t, "DATE") || strings.Contains(t, "TIME"):
+		return "time"
+	case strings.Contains(t, "BLOB") || strings.Contains(t, "BYTEA") || strings.Contains(t, "BINARY"):
+		return "blob"
+	}
+	return t
+}
+
+// Compatible reports whether a column of type live can store the values of type wanted.
+func Compatible(wanted string, live string) bool {
+	a, b := Family(wanted), Family(live)
+	if a == b {
+		return true
+	}
+	pair := map[string]bool{a: true, b: true}
+	// booleans are stored as integers by SQLite and MySQL, integers fit in real columns
+	return (pair["boolean"] && pair["integer"]) || (a == "integer" && b == "real")
+}
+
+// sortByReferences orders tables so that the referenced tables come first.
+func sortByReferences(tables []Table) []Table {
+	sort.SliceStable(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	byName := map[string]Table{}
+	for _, table := range tables {
+		byName[table.Name] = table
+	}
+	sorted := []Table{}
+	visited := map[string]bool{}
+	var visit func(table Table)
+	visit = func(table Table) {
+		if visited[table.Name] {
+			return
+		}
+		visited[table.Name] = true
+		for _, fk := range table.ForeignKeys {
+			if ref, ok := byName[fk.RefTable]; ok {
+				visit(ref)
+			}
+		}
+		sorted = append(sorted, table)
+	}
+	for _, table := range tables {
+		visit(table)
+	}
+	return sorted
+}
@@ -0,0 +1,149 @@
+package schema
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Group struct {
+	ID   int    `json:"id" db:"id" s2s_table_name:"groups"`
+	Name string `json:"name" db:"name" s2s_unique:"true"`
+}
+
+type Member struct {
+	MemberID  *int64    `json:"id" db:"id" s2s_id:"true"`
+	Email     string    `json:"email" db:"email" s2s_index:"idx_members_contact"`
+	Phone     *string   `json:"phone" db:"phone" s2s_index:"idx_members_contact"`
+	Score     float64   `json:"score" db:"score"`
+	JoinedAt  time.Time `json:"joined_at" db:"joined_at"`
+	GroupID   *int      `json:"-" db:"group_id" s2s_ref_value:"Group.ID"`
+	Group     *Group    `json:"group,omitempty" s2s:"id = ?" s2s_param:"GroupID"`
+	Notes     string    `json:"notes" db:"notes" s2s_type:"VARCHAR(500)"`
+	Ignored   string    `json:"ignored"`
+	Relations *[]Group  `json:"groups,omitempty" s2s:"id in (select group_id from member_groups where member_id = ?)"`
+}
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "schema.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DB = db
+	config.Dialect = config.DialectSQLite
+	return db
+}
+
+func exec(t *testing.T, db *sql.DB, statements []string) {
+	for _, statement := range statements {
+		if strings.HasPrefix(statement, "--") {
+			continue
+		}
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(statement, err)
+		}
+	}
+}
+
+func TestTableOf(t *testing.T) {
+	table := TableOf(reflect.TypeOf(Member{}), config.DialectPostgres)
+	if table.Name != "member" || len(table.Columns) != 7 {
+		t.Fatal("unexpected table", table)
+	}
+	id, _ := table.Column("id")
+	phone, _ := table.Column("phone")
+	email, _ := table.Column("email")
+	notes, _ := table.Column("notes")
+	if !id.PrimaryKey || !id.AutoIncrement || id.Type != "BIGINT" || !phone.Nullable || email.Nullable || notes.Type != "VARCHAR(500)" {
+		t.Fatal("unexpected columns", table.Columns)
+	}
+	if len(table.ForeignKeys) != 1 || table.ForeignKeys[0] != (ForeignKey{Column: "group_id", RefTable: "groups", RefColumn: "id"}) {
+		t.Fatal("unexpected foreign keys", table.ForeignKeys)
+	}
+	if len(table.Indexes) != 1 || strings.Join(table.Indexes[0].Columns, ",") != "email,phone" {
+		t.Fatal("unexpected indexes", table.Indexes)
+	}
+
+	statements := CreateSQL(config.DialectPostgres, table, TableOf(reflect.TypeOf(Group{}), config.DialectPostgres))
+	if !strings.HasPrefix(statements[0], "CREATE TABLE groups") || !strings.Contains(statements[2], "id BIGSERIAL PRIMARY KEY") ||
+		!strings.Contains(statements[2], "joined_at TIMESTAMP NOT NULL") {
+		t.Fatal("unexpected statements", statements)
+	}
+	mysql := CreateSQL(config.DialectMySQL, TableOf(reflect.TypeOf(Member{}), config.DialectMySQL))
+	if !strings.Contains(mysql[0], "id BIGINT AUTO_INCREMENT PRIMARY KEY") || !strings.Contains(mysql[0], "email VARCHAR(255) NOT NULL") {
+		t.Fatal("unexpected mysql statements", mysql)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	wanted := []Table{TableOf(reflect.TypeOf(Member{}), config.DialectSQLite), TableOf(reflect.TypeOf(Group{}), config.DialectSQLite)}
+
+	live, err := Introspect(db, config.DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Diff(wanted, live, config.DialectSQLite, Options{})
+	exec(t, db, m.Up)
+	live, _ = Introspect(db, config.DialectSQLite)
+	if again := Diff(wanted, live, config.DialectSQLite, Options{}); !again.Empty() {
+		t.Fatal("expected no changes after applying the migration", again.Up)
+	}
+	if len(live["member"].ForeignKeys) != 1 || len(live["member"].Indexes) != 1 || len(live["groups"].Indexes) != 1 {
+		t.Fatal("unexpected live tables", live)
+	}
+	exec(t, db, m.Down)
+	if live, _ := Introspect(db, config.DialectSQLite); len(live) != 0 {
+		t.Fatal("expected the down migration to drop the tables", live)
+	}
+
+	// an existing table gets the missing columns and indexes
+	exec(t, db, []string{"CREATE TABLE groups (id INTEGER PRIMARY KEY, legacy TEXT)", "INSERT INTO groups (legacy) VALUES ('x')"})
+	live, _ = Introspect(db, config.DialectSQLite)
+	m = Diff(wanted[1:], live, config.DialectSQLite, Options{})
+	if m.Up[0] != "ALTER TABLE groups ADD COLUMN name TEXT DEFAULT '' NOT NULL" || !strings.HasPrefix(m.Up[1], "-- groups.legacy") {
+		t.Fatal("unexpected changes", m.Up)
+	}
+	exec(t, db, m.Up)
+	exec(t, db, m.Down)
+	if live, _ := Introspect(db, config.DialectSQLite); len(live["groups"].Columns) != 2 || len(live["groups"].Indexes) != 0 {
+		t.Fatal("expected the down migration to restore the table", live["groups"])
+	}
+	if m := Diff(wanted[1:], live, config.DialectSQLite, Options{DropColumns: true}); m.Up[1] != "ALTER TABLE groups DROP COLUMN legacy" {
+		t.Fatal("expected the unmapped column to be dropped", m.Up)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	Register(Group{}, &Member{}, Group{})
+	if len(Models()) != 2 {
+		t.Fatal("expected each model once", Models())
+	}
+	dir := t.TempDir()
+	m, err := Generate(dir, "Create members", Options{})
+	if err != nil || m == nil {
+		t.Fatal(m, err)
+	}
+	up, err := os.ReadFile(filepath.Join(dir, m.Version+"_create_members.up.sql"))
+	if err != nil || !strings.HasPrefix(string(up), "CREATE TABLE groups") {
+		t.Fatal(string(up), err)
+	}
+	down, _ := os.ReadFile(filepath.Join(dir, m.Version+"_create_members.down.sql"))
+	if string(down) != "DROP TABLE member;\nDROP TABLE groups;\n" {
+		t.Fatal("unexpected down migration", string(down))
+	}
+	exec(t, db, m.Up)
+	if m, err := Generate(dir, "nothing", Options{}); m != nil || err != nil {
+		t.Fatal("expected no migration", m, err)
+	}
+}
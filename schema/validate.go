@@ -0,0 +1,153 @@
+package schema
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+// Problem is a mismatch between a field of a model and the database.
+type Problem struct {
+	Model   string
+	Field   string
+	Message string
+}
+
+// Problems is returned by Validate with every problem found.
+type Problems []Problem
+
+func (p Problems) Error() string {
+	msgs := make([]string, 0, len(p))
+	for _, problem := range p {
+		msgs = append(msgs, problem.Model+"."+problem.Field+": "+problem.Message)
+	}
+	return "schema validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the models of the repositories created so far against config.DB, e.g. at startup
+// after the repositories are built, see ValidateModels.
+func Validate() error {
+	return ValidateModels(config.DB, config.Dialect, repositories.Models()...)
+}
+
+// ValidateModels checks that the table and the db columns of each model exist with compatible types,
+// that s2s_ref_value and s2s_param name fields of the model and that the s2s relation queries prepare.
+// It returns Problems with all the problems found.
+func ValidateModels(db *sql.DB, dialect string, models ...reflect.Type) error {
+	live, err := Introspect(db, dialect)
+	if err != nil {
+		return err
+	}
+	problems := Problems{}
+	for _, model := range models {
+		problems = append(problems, validateModel(db, dialect, model, live)...)
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func validateModel(db *sql.DB, dialect string, model reflect.Type, live map[string]Table) Problems {
+	problems := Problems{}
+	add := func(field string, format string, args ...interface{}) {
+		problems = append(problems, Problem{Model: model.Name(), Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	name := repositories.TableName(model)
+	table, tableExists := live[name]
+	if !tableExists {
+		add("", "table %s does not exist", name)
+	}
+	idField := "ID"
+	for i := 0; i < model.NumField(); i++ {
+		if model.Field(i).Tag.Get(repositories.S2S_ID) == "true" {
+			idField = model.Field(i).Name
+		}
+	}
+
+	for i := 0; i < model.NumField(); i++ {
+		field := model.Field(i)
+		if column := field.Tag.Get("db"); column != "" && column != "-" && tableExists {
+			wanted := field.Tag.Get(S2S_TYPE)
+			if wanted == "" {
+				fieldType, _ := baseType(field.Type)
+				wanted = ColumnType(fieldType, dialect)
+			}
+			// SQLite columns declared without a type take any value
+			if liveColumn, ok := table.Column(column); !ok {
+				add(field.Name, "column %s does not exist in %s", column, name)
+			} else if liveColumn.Type != "" && !Compatible(wanted, liveColumn.Type) {
+				add(field.Name, "column %s is %s, not compatible with %s", column, liveColumn.Type, wanted)
+			}
+		}
+
+		if ref := field.Tag.Get(repositories.S2S_REF_VALUE); ref != "" {
+			if message := refProblem(model, ref); message != "" {
+				add(field.Name, "%s %q: %s", repositories.S2S_REF_VALUE, ref, message)
+			}
+		}
+
+		if field.Tag.Get(repositories.S2S) == "" {
+			continue
+		}
+		if params := field.Tag.Get(repositories.S2S_PARAM); params != "" {
+			for _, param := range strings.Split(params, ",") {
+				if _, ok := model.FieldByName(param); !ok {
+					add(field.Name, "%s %q: no field %s", repositories.S2S_PARAM, params, param)
+				}
+			}
+		} else if _, ok := model.FieldByName(idField); !ok {
+			add(field.Name, "no %s field to pass to the relation, use %s", idField, repositories.S2S_PARAM)
+		}
+		if !relationShape(field.Type) {
+			add(field.Name, "relations must be a struct, a slice of structs or a pointer to one")
+			continue
+		}
+		query := repositories.RelationQuery(field)
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			add(field.Name, "%s query %q: %v", repositories.S2S, query, err)
+			continue
+		}
+		stmt.Close()
+	}
+	return problems
+}
+
+// relationShape reports whether fieldType is T, *T, []T or *[]T, the relation types loaded by repositories.
+func relationShape(fieldType reflect.Type) bool {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+	return fieldType.Kind() == reflect.Struct
+}
+
+// refProblem checks that ref, "Relation.Field", names a struct field of model and a field of it.
+func refProblem(model reflect.Type, ref string) string {
+	parts := strings.Split(ref, ".")
+	if len(parts) != 2 {
+		return "expected Relation.Field"
+	}
+	relation, ok := model.FieldByName(parts[0])
+	if !ok {
+		return "no field " + parts[0]
+	}
+	relationType := relation.Type
+	if relationType.Kind() == reflect.Ptr {
+		relationType = relationType.Elem()
+	}
+	if relationType.Kind() != reflect.Struct {
+		return parts[0] + " is not a struct"
+	}
+	if _, ok := relationType.FieldByName(parts[1]); !ok {
+		return "no field " + parts[1] + " in " + relationType.Name()
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package schema
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/arturoeanton/go-struct2serve/config"
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+type Author struct {
+	ID     int      `json:"id" db:"id" s2s_table_name:"authors"`
+	Name   string   `json:"name" db:"name"`
+	Titles *[]Title `json:"titles" s2s:"select title from books where author_id = ?"`
+}
+
+type Title struct {
+	Title string `json:"title" db:"title"`
+}
+
+type Book struct {
+	ID       int     `json:"id" db:"id" s2s_table_name:"books"`
+	Title    string  `json:"title" db:"titel"`
+	Pages    string  `json:"pages" db:"pages"`
+	AuthorID int     `json:"-" db:"author_id" s2s_ref_value:"Author.Id"`
+	Author   *Author `json:"author" s2s:"id = ?" s2s_param:"AuthorId"`
+	Reviews  *[]int  `json:"reviews" s2s:"select stars from reviews where book_id = ?"`
+	Sequel   *Book   `json:"sequel" s2s:"id = (select sequel_id from sequels where book_id = ?)"`
+}
+
+type Shelf struct {
+	ID int `json:"id" db:"id"`
+}
+
+func TestValidate(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	exec(t, db, []string{
+		"CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT, pages INTEGER, author_id INTEGER)",
+	})
+
+	if err := ValidateModels(db, config.DialectSQLite, reflect.TypeOf(Author{})); err != nil {
+		t.Fatal("expected a valid model", err)
+	}
+
+	err := ValidateModels(db, config.DialectSQLite, reflect.TypeOf(Book{}), reflect.TypeOf(Shelf{}))
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatal("expected problems", err)
+	}
+	fields := []string{}
+	for _, problem := range problems {
+		fields = append(fields, problem.Model+"."+problem.Field)
+	}
+	expected := "Book.Title,Book.Pages,Book.AuthorID,Book.Author,Book.Reviews,Book.Sequel,Shelf."
+	if strings.Join(fields, ",") != expected {
+		t.Fatal("unexpected problems", err)
+	}
+	for _, message := range []string{"column titel does not exist", "pages is INTEGER, not compatible with TEXT", "no field Id in Author",
+		"s2s_param \"AuthorId\": no field AuthorId", "relations must be a struct", "no such table: sequels", "table shelf does not exist"} {
+		if !strings.Contains(err.Error(), message) {
+			t.Fatal("expected", message, "in", err)
+		}
+	}
+
+	config.DB = db
+	repositories.NewRepository[Author]()
+	// models registered for migrations share the registry, so their tables are validated too
+	Register(Group{}, Member{})
+	if err := Validate(); err == nil || !strings.Contains(err.Error(), "table member does not exist") {
+		t.Fatal("expected the registered models to be validated", err)
+	}
+	live, err := Introspect(db, config.DialectSQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec(t, db, append(Diff(Tables(config.DialectSQLite), live, config.DialectSQLite, Options{}).Up,
+		"CREATE TABLE member_groups (member_id INTEGER, group_id INTEGER)"))
+	if err := Validate(); err != nil {
+		t.Fatal("expected the repositories to be valid", err)
+	}
+	repositories.NewRepository[Shelf]()
+	if err := Validate(); err == nil || !strings.Contains(err.Error(), "table shelf does not exist") {
+		t.Fatal("expected the new repository to be validated", err)
+	}
+}
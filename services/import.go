@@ -0,0 +1,170 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/arturoeanton/go-struct2serve/utils"
+	"github.com/arturoeanton/go-struct2serve/validators"
+)
+
+const (
+	ImportCSV    = "csv"
+	ImportNDJSON = "ndjson"
+)
+
+var ErrUnknownImportFormat = errors.New("unknown import format")
+
+// ImportRowError lists the problems of one input row; Row starts at 1 and does not count the CSV header.
+type ImportRowError struct {
+	Row     int                     `json:"row"`
+	Message string                  `json:"message,omitempty"`
+	Fields  []validators.FieldError `json:"fields,omitempty"`
+}
+
+type ImportReport struct {
+	Total          int              `json:"total"`
+	Inserted       int              `json:"inserted"`
+	DryRun         bool             `json:"dry_run"`
+	IDs            []int64          `json:"ids,omitempty"`
+	UnknownColumns []string         `json:"unknown_columns,omitempty"`
+	Errors         []ImportRowError `json:"errors,omitempty"`
+}
+
+// Import reads CSV (with header) or NDJSON rows from r, validates each of them and, unless dryRun is set
+// or a row failed, inserts them all with CreateMany in one transaction.
+func (r *Service[T]) Import(reader io.Reader, format string, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun}
+	var items []*T
+	var err error
+	switch strings.ToLower(format) {
+	case ImportCSV:
+		items, err = readCSV[T](reader, report)
+	case ImportNDJSON:
+		items, err = readNDJSON[T](reader, report)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownImportFormat, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		if err := validators.Validate(item); err != nil {
+			var verr validators.ValidationErrors
+			if errors.As(err, &verr) {
+				report.Errors = append(report.Errors, ImportRowError{Row: i + 1, Fields: verr})
+				continue
+			}
+			report.Errors = append(report.Errors, ImportRowError{Row: i + 1, Message: err.Error()})
+		}
+	}
+	if dryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	ids, err := r.repo.CreateMany(items)
+	if err != nil {
+		return report, err
+	}
+	report.IDs = ids
+	report.Inserted = len(ids)
+	return report, nil
+}
+
+// readCSV maps the header columns to fields by json name or db tag. Rows that fail to parse are reported and left nil.
+func readCSV[T any](reader io.Reader, report *ImportReport) ([]*T, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return []*T{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	itemType := reflect.TypeOf((*T)(nil)).Elem()
+	fieldsByName := map[string]int{}
+	for i := 0; i < itemType.NumField(); i++ {
+		field := itemType.Field(i)
+		if tag := field.Tag.Get("db"); tag != "" {
+			fieldsByName[strings.ToLower(tag)] = i
+		}
+		if tag := field.Tag.Get("json"); tag != "-" {
+			fieldsByName[strings.ToLower(validators.JsonName(field))] = i
+		}
+	}
+	columns := make([]int, len(header))
+	for i, name := range header {
+		index, ok := fieldsByName[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			report.UnknownColumns = append(report.UnknownColumns, name)
+			index = -1
+		}
+		columns[i] = index
+	}
+
+	items := []*T{}
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		report.Total++
+		if err != nil {
+			report.Errors = append(report.Errors, ImportRowError{Row: report.Total, Message: err.Error()})
+			items = append(items, nil)
+			continue
+		}
+		item := repositories.CreateNewElement[T]()
+		itemValue := reflect.ValueOf(item).Elem()
+		fieldErrors := []validators.FieldError{}
+		for i, value := range record {
+			if i >= len(columns) || columns[i] < 0 {
+				continue
+			}
+			if err := utils.SetFromString(itemValue.Field(columns[i]), value); err != nil {
+				fieldErrors = append(fieldErrors, validators.FieldError{Field: header[i], Rule: "type", Message: err.Error()})
+			}
+		}
+		if len(fieldErrors) > 0 {
+			report.Errors = append(report.Errors, ImportRowError{Row: report.Total, Fields: fieldErrors})
+			items = append(items, nil)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func readNDJSON[T any](reader io.Reader, report *ImportReport) ([]*T, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	items := []*T{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		report.Total++
+		item := repositories.CreateNewElement[T]()
+		if err := json.Unmarshal([]byte(line), item); err != nil {
+			report.Errors = append(report.Errors, ImportRowError{Row: report.Total, Message: err.Error()})
+			items = append(items, nil)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+)
+
+type Contact struct {
+	ID    *int   `json:"id" db:"id"`
+	Name  string `json:"name" db:"name" validate:"required"`
+	Email string `json:"email" db:"email" validate:"email"`
+	Age   int    `json:"age" db:"age"`
+}
+
+type fakeRepository[T any] struct {
+	repositories.IRepository[T]
+	created []*T
+}
+
+func (f *fakeRepository[T]) CreateMany(items []*T) ([]int64, error) {
+	f.created = append(f.created, items...)
+	ids := []int64{}
+	for i := range items {
+		ids = append(ids, int64(i+1))
+	}
+	return ids, nil
+}
+
+func TestImportCSV(t *testing.T) {
+	repo := &fakeRepository[Contact]{}
+	service := NewService[Contact](repo)
+
+	data := "name,email,age,extra\nann,ann@mail.com,30,x\n,bad,abc,y\nbob,bob@mail.com,41,z\n"
+	report, err := service.Import(strings.NewReader(data), ImportCSV, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 3 || len(report.Errors) != 1 || report.Errors[0].Row != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.UnknownColumns) != 1 || report.UnknownColumns[0] != "extra" {
+		t.Error("expected extra as unknown column", report.UnknownColumns)
+	}
+	if len(repo.created) != 0 {
+		t.Error("nothing should be inserted when a row fails")
+	}
+
+	data = "name,email,age\nann,ann@mail.com,30\nbob,bob@mail.com,41\n"
+	report, _ = service.Import(strings.NewReader(data), ImportCSV, true)
+	if report.Inserted != 0 || len(repo.created) != 0 {
+		t.Error("dry run must not insert")
+	}
+	report, _ = service.Import(strings.NewReader(data), ImportCSV, false)
+	if report.Inserted != 2 || repo.created[1].Age != 41 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestImportNDJSON(t *testing.T) {
+	repo := &fakeRepository[Contact]{}
+	service := NewService[Contact](repo)
+
+	data := `{"name":"ann","email":"ann@mail.com"}` + "\n" + `{"name":` + "\n"
+	report, err := service.Import(strings.NewReader(data), ImportNDJSON, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 2 || len(report.Errors) != 1 || report.Errors[0].Row != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
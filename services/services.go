@@ -1,14 +1,42 @@
 package services
 
-import "github.com/arturoeanton/go-struct2serve/repositories"
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/arturoeanton/go-struct2serve/repositories"
+	"github.com/arturoeanton/go-struct2serve/validators"
+)
 
 type IService[T any] interface {
 	GetAll() ([]*T, error)
 	GetByID(id interface{}) (*T, error)
 	GetByCriteria(criteria string, args ...interface{}) ([]*T, error)
+	ForEach(criteria string, fn func(item *T) error, args ...interface{}) error
 	Create(item *T) (int64, error)
 	Update(item *T) error
 	Delete(id interface{}) error
+	CreateMany(items []*T) ([]int64, error)
+	UpdateMany(items []*T) error
+	DeleteMany(ids []interface{}) error
+	UpdateWhere(set map[string]interface{}, criteria string, args ...interface{}) (int64, error)
+	DeleteWhere(criteria string, args ...interface{}) (int64, error)
+	UpdateAll(set map[string]interface{}) (int64, error)
+	DeleteAll() (int64, error)
+	Count(criteria string, args ...interface{}) (int64, error)
+	Exists(criteria string, args ...interface{}) (bool, error)
+	GroupBy(groupBy []string, aggregates []repositories.Aggregate, criteria string, args ...interface{}) ([]map[string]interface{}, error)
+	Upsert(item *T, conflictColumns ...string) error
+	UpsertColumns(item *T, conflictColumns []string, updateColumns []string) error
+	UpsertMany(items []*T, conflictColumns []string, updateColumns []string) error
+	Import(reader io.Reader, format string, dryRun bool) (*ImportReport, error)
+	Relations() []repositories.Relation
+	GetRelation(id interface{}, name string) (interface{}, error)
+	Link(id interface{}, name string, targetID interface{}) error
+	Unlink(id interface{}, name string, targetID interface{}) error
+	WithContext(ctx context.Context) IService[T]
+	History(id interface{}) ([]repositories.Revision, error)
 }
 
 type Service[T any] struct {
@@ -21,6 +49,15 @@ func NewService[T any](repo repositories.IRepository[T]) *Service[T] {
 	}
 }
 
+// WithContext returns a service whose repository runs with ctx, e.g. the request context carrying the tenant.
+func (r *Service[T]) WithContext(ctx context.Context) IService[T] {
+	return NewService(r.repo.WithContext(ctx))
+}
+
+func (r *Service[T]) History(id interface{}) ([]repositories.Revision, error) {
+	return r.repo.History(id)
+}
+
 func (r *Service[T]) GetAll() ([]*T, error) {
 	items, err := r.repo.GetAll()
 	if err != nil {
@@ -45,7 +82,14 @@ func (r *Service[T]) GetByCriteria(criteria string, args ...interface{}) ([]*T,
 	return items, nil
 }
 
+func (r *Service[T]) ForEach(criteria string, fn func(item *T) error, args ...interface{}) error {
+	return r.repo.ForEach(criteria, fn, args...)
+}
+
 func (r *Service[T]) Create(item *T) (int64, error) {
+	if err := validators.Validate(item); err != nil {
+		return 0, err
+	}
 	id, err := r.repo.Create(item)
 	if err != nil {
 		return 0, err
@@ -55,6 +99,9 @@ func (r *Service[T]) Create(item *T) (int64, error) {
 }
 
 func (r *Service[T]) Update(item *T) error {
+	if err := validators.Validate(item); err != nil {
+		return err
+	}
 	err := r.repo.Update(item)
 	if err != nil {
 		return err
@@ -69,3 +116,113 @@ func (r *Service[T]) Delete(id interface{}) error {
 	}
 	return nil
 }
+
+func (r *Service[T]) CreateMany(items []*T) ([]int64, error) {
+	if err := validateMany(items); err != nil {
+		return nil, err
+	}
+	ids, err := r.repo.CreateMany(items)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *Service[T]) UpdateMany(items []*T) error {
+	if err := validateMany(items); err != nil {
+		return err
+	}
+	return r.repo.UpdateMany(items)
+}
+
+func (r *Service[T]) DeleteMany(ids []interface{}) error {
+	return r.repo.DeleteMany(ids)
+}
+
+func (r *Service[T]) UpdateWhere(set map[string]interface{}, criteria string, args ...interface{}) (int64, error) {
+	return r.repo.UpdateWhere(set, criteria, args...)
+}
+
+func (r *Service[T]) DeleteWhere(criteria string, args ...interface{}) (int64, error) {
+	return r.repo.DeleteWhere(criteria, args...)
+}
+
+func (r *Service[T]) UpdateAll(set map[string]interface{}) (int64, error) {
+	return r.repo.UpdateAll(set)
+}
+
+func (r *Service[T]) DeleteAll() (int64, error) {
+	return r.repo.DeleteAll()
+}
+
+func (r *Service[T]) Count(criteria string, args ...interface{}) (int64, error) {
+	return r.repo.Count(criteria, args...)
+}
+
+func (r *Service[T]) Exists(criteria string, args ...interface{}) (bool, error) {
+	return r.repo.Exists(criteria, args...)
+}
+
+func (r *Service[T]) GroupBy(groupBy []string, aggregates []repositories.Aggregate, criteria string, args ...interface{}) ([]map[string]interface{}, error) {
+	return r.repo.GroupBy(groupBy, aggregates, criteria, args...)
+}
+
+func (r *Service[T]) Upsert(item *T, conflictColumns ...string) error {
+	if err := validators.Validate(item); err != nil {
+		return err
+	}
+	return r.repo.Upsert(item, conflictColumns...)
+}
+
+func (r *Service[T]) UpsertColumns(item *T, conflictColumns []string, updateColumns []string) error {
+	if err := validators.Validate(item); err != nil {
+		return err
+	}
+	return r.repo.UpsertColumns(item, conflictColumns, updateColumns)
+}
+
+func (r *Service[T]) UpsertMany(items []*T, conflictColumns []string, updateColumns []string) error {
+	if err := validateMany(items); err != nil {
+		return err
+	}
+	return r.repo.UpsertMany(items, conflictColumns, updateColumns)
+}
+
+func (r *Service[T]) Relations() []repositories.Relation {
+	return r.repo.Relations()
+}
+
+func (r *Service[T]) GetRelation(id interface{}, name string) (interface{}, error) {
+	return r.repo.GetRelation(id, name)
+}
+
+func (r *Service[T]) Link(id interface{}, name string, targetID interface{}) error {
+	return r.repo.Link(id, name, targetID)
+}
+
+func (r *Service[T]) Unlink(id interface{}, name string, targetID interface{}) error {
+	return r.repo.Unlink(id, name, targetID)
+}
+
+// validateMany validates every item and prefixes field names with the item index.
+func validateMany[T any](items []*T) error {
+	all := validators.ValidationErrors{}
+	for i, item := range items {
+		err := validators.Validate(item)
+		if err == nil {
+			continue
+		}
+		verr, ok := err.(validators.ValidationErrors)
+		if !ok {
+			return err
+		}
+		for _, fe := range verr {
+			fe.Field = "[" + strconv.Itoa(i) + "]." + fe.Field
+			all = append(all, fe)
+		}
+	}
+	if len(all) > 0 {
+		return all
+	}
+	return nil
+}
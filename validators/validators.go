@@ -0,0 +1,278 @@
+package validators
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	S2S_VALIDATE string = "validate"
+)
+
+// ValidatorFunc checks a single field value; param is the text after "=" in the rule (may be empty).
+type ValidatorFunc func(value reflect.Value, param string) bool
+
+// FieldError describes one failed rule, keyed by the json name of the field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is returned by Validate when one or more fields fail.
+type ValidationErrors []FieldError
+
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(ve))
+	for _, fe := range ve {
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+var (
+	emailRegex  = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
+	regexCache  = map[string]*regexp.Regexp{}
+	regexMutex  sync.Mutex
+	customMutex sync.RWMutex
+	custom      = map[string]ValidatorFunc{}
+)
+
+// RegisterValidator adds a custom rule usable as validate:"name" or validate:"name=param".
+func RegisterValidator(name string, fn ValidatorFunc) {
+	customMutex.Lock()
+	defer customMutex.Unlock()
+	custom[name] = fn
+}
+
+// Validate checks every field of item (a struct or pointer to struct) against its validate tag.
+// Fields without the required rule are optional: their rules are skipped on the zero value.
+func Validate(item interface{}) error {
+	v := reflect.ValueOf(item)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+
+	errs := ValidationErrors{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get(S2S_VALIDATE)
+		if tag == "" || tag == "-" {
+			continue
+		}
+		name := JsonName(field)
+		rules := ParseRules(tag)
+		if !hasRule(rules, "required") && v.Field(i).Kind() != reflect.Ptr && v.Field(i).IsZero() {
+			// optional fields left at their zero value were not sent; nil pointers are checked in check
+			continue
+		}
+		for _, rule := range rules {
+			ok, msg := check(v.Field(i), rule.Name, rule.Param)
+			if !ok {
+				errs = append(errs, FieldError{Field: name, Rule: rule.Name, Param: rule.Param, Message: msg})
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// JsonName returns the name used for a field in json payloads.
+func JsonName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "" || tag == "-" {
+		return field.Name
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Rule is one entry of a validate tag, e.g. min=3 is {Name: "min", Param: "3"}.
+type Rule struct {
+	Name  string
+	Param string
+}
+
+// ParseRules parses a validate tag.
+func ParseRules(tag string) []Rule {
+	rules := []Rule{}
+	for _, rule := range splitRules(tag) {
+		name, param, _ := strings.Cut(rule, "=")
+		rules = append(rules, Rule{Name: name, Param: param})
+	}
+	return rules
+}
+
+func hasRule(rules []Rule, name string) bool {
+	for _, rule := range rules {
+		if rule.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// splitRules splits on commas; regex must be the last rule because its pattern may contain commas.
+func splitRules(tag string) []string {
+	rules := []string{}
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			rules = append(rules, tag)
+			break
+		}
+		idx := strings.Index(tag, ",")
+		if idx < 0 {
+			rules = append(rules, strings.TrimSpace(tag))
+			break
+		}
+		if rule := strings.TrimSpace(tag[:idx]); rule != "" {
+			rules = append(rules, rule)
+		}
+		tag = tag[idx+1:]
+	}
+	return rules
+}
+
+func check(value reflect.Value, rule string, param string) (bool, string) {
+	if rule == "required" {
+		if isEmpty(value) {
+			return false, "is required"
+		}
+		return true, ""
+	}
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return true, ""
+		}
+		value = value.Elem()
+	}
+
+	switch rule {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return false, "invalid " + rule + " parameter " + param
+		}
+		size, isLen := measure(value)
+		if rule == "min" && size < limit {
+			if isLen {
+				return false, "must have at least " + param + " characters or items"
+			}
+			return false, "must be greater than or equal to " + param
+		}
+		if rule == "max" && size > limit {
+			if isLen {
+				return false, "must have at most " + param + " characters or items"
+			}
+			return false, "must be less than or equal to " + param
+		}
+		return true, ""
+	case "len":
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return false, "invalid len parameter " + param
+		}
+		size, _ := measure(value)
+		if int(size) != n {
+			return false, "must have length " + param
+		}
+		return true, ""
+	case "email":
+		if value.Kind() != reflect.String || value.String() == "" {
+			return true, ""
+		}
+		if !emailRegex.MatchString(value.String()) {
+			return false, "must be a valid email"
+		}
+		return true, ""
+	case "oneof":
+		s := fmt.Sprint(value.Interface())
+		for _, option := range strings.Fields(param) {
+			if s == option {
+				return true, ""
+			}
+		}
+		return false, "must be one of [" + param + "]"
+	case "regex":
+		re, err := compile(param)
+		if err != nil {
+			return false, "invalid regex " + param
+		}
+		if !re.MatchString(fmt.Sprint(value.Interface())) {
+			return false, "must match " + param
+		}
+		return true, ""
+	}
+
+	customMutex.RLock()
+	fn, ok := custom[rule]
+	customMutex.RUnlock()
+	if !ok {
+		return false, "unknown rule " + rule
+	}
+	if !fn(value, param) {
+		return false, "failed " + rule
+	}
+	return true, ""
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	regexMutex.Lock()
+	defer regexMutex.Unlock()
+	if re, ok := regexCache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	regexCache[pattern] = re
+	return re, nil
+}
+
+// measure returns the length for strings, slices and maps, and the numeric value otherwise.
+func measure(value reflect.Value) (float64, bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(len([]rune(value.String()))), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(value.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), false
+	}
+	return 0, false
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map:
+		return value.IsNil() || value.Len() == 0
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	}
+	return value.IsZero()
+}
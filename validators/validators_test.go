@@ -0,0 +1,65 @@
+package validators
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type Account struct {
+	Name   string  `json:"name" validate:"required,min=3,max=10"`
+	Email  string  `json:"email" validate:"required,email"`
+	Kind   string  `json:"kind" validate:"oneof=admin user"`
+	Code   string  `json:"code" validate:"len=4"`
+	Phone  *string `json:"phone,omitempty" validate:"regex=^[0-9]{3,5}$"`
+	Age    int     `json:"age" validate:"min=18"`
+	Secret string  `json:"-" validate:"even"`
+}
+
+func TestValidate(t *testing.T) {
+	RegisterValidator("even", func(v reflect.Value, param string) bool {
+		return len(v.String())%2 == 0
+	})
+
+	phone := "12"
+	a := &Account{Name: "ab", Email: "bad", Kind: "root", Code: "123", Phone: &phone, Age: 10, Secret: "abc"}
+	err := Validate(a)
+	var verr ValidationErrors
+	if !errors.As(err, &verr) {
+		t.Fatal("expected ValidationErrors")
+	}
+	fields := map[string]string{}
+	for _, fe := range verr {
+		fields[fe.Field] = fe.Rule
+	}
+	expected := map[string]string{"name": "min", "email": "email", "kind": "oneof", "code": "len", "phone": "regex", "age": "min", "Secret": "even"}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("field %s: expected rule %s, got %s", k, v, fields[k])
+		}
+	}
+
+	a = &Account{Name: "admin", Email: "admin@admin.com", Kind: "admin", Code: "1234", Age: 20, Secret: "ab"}
+	if err := Validate(a); err != nil {
+		t.Error(err)
+	}
+
+	// oneof, len and min only apply to optional fields that were set
+	if err := Validate(&Account{Name: "admin", Email: "admin@admin.com"}); err != nil {
+		t.Error("expected optional zero values to pass", err)
+	}
+	for _, account := range []*Account{
+		{Name: "admin", Email: "admin@admin.com", Kind: "root"},
+		{Name: "admin", Email: "admin@admin.com", Code: "1"},
+		{Name: "admin", Email: "admin@admin.com", Age: 1},
+	} {
+		if err := Validate(account); err == nil {
+			t.Error("expected the rules of set fields to apply", account)
+		}
+	}
+
+	if err := Validate(&Account{}); err == nil || !strings.Contains(err.Error(), "name: is required") {
+		t.Error("expected required error", err)
+	}
+}